@@ -10,3 +10,37 @@ var ENV = []string{
 }
 
 const PAGE_LIMIT = 10
+
+const MAX_UPLOAD_SIZE = 20 * 1024 * 1024
+
+// Longest edge, in pixels, of the renditions generated for every item image
+const (
+	IMAGE_THUMBNAIL_SIZE = 200
+	IMAGE_MEDIUM_SIZE    = 800
+)
+
+// Largest item image accepted for upload, in pixels, checked before the image
+// is decoded
+const IMAGE_MAX_PIXELS = 50_000_000
+
+var ITEM_IMAGE_KINDS = []string{
+	"obverse",
+	"reverse",
+	"edge",
+	"certificate",
+}
+
+// Detail attributes naming the ruler or issuing authority of a coin, matched
+// case-insensitively when recommending similar items
+var RULER_ATTRIBUTES = []string{
+	"ruler",
+	"king",
+	"emperor",
+	"issuer",
+}
+
+// Items minted within this many years of each other count as similar
+const RECOMMENDATION_YEAR_RANGE = 10
+
+// Most items returned in each section of an item's recommendations
+const RECOMMENDATION_MAX_LIMIT = 50
@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/google/uuid"
+)
+
+// FakeProvider stands in for a payment gateway during development. Payment
+// orders are created locally, and callbacks and webhooks use the Razorpay
+// formats signed with Secret, so Sign and SignWebhook can produce what the
+// real provider would send.
+type FakeProvider struct {
+	Secret string
+}
+
+func NewFakeProvider(secret string) *FakeProvider {
+	if secret == "" {
+		secret = "fake_secret"
+	}
+	return &FakeProvider{Secret: secret}
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) CreateOrder(ctx context.Context, amount money.Amount, currency money.Currency, receipt string) (*PaymentOrder, error) {
+	return &PaymentOrder{
+		Provider:        p.Name(),
+		ProviderOrderID: "order_fake_" + uuid.NewString(),
+		Amount:          amount,
+		Currency:        currency,
+		PublicKey:       "fake",
+	}, nil
+}
+
+// Refund is processed on the spot.
+func (p *FakeProvider) Refund(ctx context.Context, provider_payment_id string, amount money.Amount, receipt string) (*RefundResult, error) {
+	return &RefundResult{
+		ProviderRefundID: "rfnd_fake_" + uuid.NewString(),
+		Status:           RefundStatusProcessed,
+	}, nil
+}
+
+func (p *FakeProvider) VerifyCallback(callback Callback) error {
+	return verify(callbackPayload(callback), callback.Signature, p.Secret)
+}
+
+func (p *FakeProvider) SignatureHeader() string {
+	return "X-Fake-Signature"
+}
+
+func (p *FakeProvider) ParseWebhook(body []byte, signature string) (*Event, error) {
+	if err := verify(body, signature, p.Secret); err != nil {
+		return nil, err
+	}
+	return parseRazorpayEvent(body)
+}
+
+// Sign completes a checkout as the client would after paying: it returns a
+// new payment id for the payment order and the callback signature for it.
+func (p *FakeProvider) Sign(provider_order_id string) Callback {
+	callback := Callback{
+		ProviderOrderID:   provider_order_id,
+		ProviderPaymentID: "pay_fake_" + uuid.NewString(),
+	}
+	callback.Signature = sign(callbackPayload(callback), p.Secret)
+	return callback
+}
+
+// SignWebhook builds a signed webhook body for an event, as the provider
+// would post it.
+func (p *FakeProvider) SignWebhook(event Event) ([]byte, string, error) {
+	payment := razorpayPayment{
+		ID:               event.ProviderPaymentID,
+		OrderID:          event.ProviderOrderID,
+		Amount:           toPaise(event.Amount),
+		Method:           event.Method,
+		ErrorDescription: event.FailureReason,
+	}
+	webhook := map[string]interface{}{
+		"event": event.Type,
+		"payload": map[string]interface{}{
+			"payment": map[string]interface{}{"entity": payment},
+		},
+	}
+	if event.Type == EventPaymentRefunded || event.Type == EventRefundFailed {
+		webhook["payload"].(map[string]interface{})["refund"] = map[string]interface{}{
+			"entity": razorpayRefund{ID: event.ProviderRefundID, PaymentID: event.ProviderPaymentID, Amount: toPaise(event.Amount), Receipt: event.Receipt},
+		}
+	}
+
+	body, err := json.Marshal(webhook)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, sign(body, p.Secret), nil
+}
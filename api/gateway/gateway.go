@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+
+	"github.com/Baalamurgan/coin-selling-backend/config"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+)
+
+const (
+	EventPaymentCaptured = "payment.captured"
+	EventPaymentFailed   = "payment.failed"
+	EventPaymentRefunded = "payment.refunded"
+	EventRefundFailed    = "refund.failed"
+)
+
+const (
+	RefundStatusPending   = "pending"
+	RefundStatusProcessed = "processed"
+)
+
+var ErrInvalidSignature = errors.New("invalid payment signature")
+
+// PaymentOrder is the order a checkout is opened against at the provider.
+// The client needs it, and the provider's public key, to take the payment.
+type PaymentOrder struct {
+	Provider        string         `json:"provider"`
+	ProviderOrderID string         `json:"provider_order_id"`
+	Amount          money.Amount   `json:"amount"`
+	Currency        money.Currency `json:"currency"`
+	PublicKey       string         `json:"public_key"`
+}
+
+// Callback is what the client posts back after a checkout completes.
+type Callback struct {
+	ProviderOrderID   string `json:"provider_order_id"`
+	ProviderPaymentID string `json:"provider_payment_id"`
+	Signature         string `json:"signature"`
+}
+
+// Event is a webhook notification about a payment, already verified.
+type Event struct {
+	Type              string
+	ProviderOrderID   string
+	ProviderPaymentID string
+	ProviderRefundID  string // set for refund events
+	Receipt           string // the reference a refund was sent with, set for refunds the shop started
+	Amount            money.Amount
+	Method            string // upi, card, netbanking, wallet as named by the provider
+	FailureReason     string
+}
+
+// RefundResult is a refund accepted by the provider. It may still be pending,
+// in which case a webhook reports when it is processed or has failed.
+type RefundResult struct {
+	ProviderRefundID string
+	Status           string
+}
+
+// PaymentProvider takes payments through an online payment gateway. Orders
+// are paid against a payment order created up front; the payment is
+// confirmed by the signed callback from the client and, independently, by
+// the provider's signed webhook.
+type PaymentProvider interface {
+	Name() string
+	CreateOrder(ctx context.Context, amount money.Amount, currency money.Currency, receipt string) (*PaymentOrder, error)
+	VerifyCallback(callback Callback) error
+	// Refund pays amount of a payment back. receipt is the shop's reference
+	// for the refund and comes back on the refund's webhooks.
+	Refund(ctx context.Context, provider_payment_id string, amount money.Amount, receipt string) (*RefundResult, error)
+	// SignatureHeader is the request header carrying the webhook signature.
+	SignatureHeader() string
+	ParseWebhook(body []byte, signature string) (*Event, error)
+}
+
+var provider PaymentProvider = nil
+
+func GetProvider() PaymentProvider {
+	if provider != nil {
+		return provider
+	}
+	provider = Connect()
+	return provider
+}
+
+// Connect sets up the configured provider. It fails closed: the fake provider
+// is only used when PAYMENT_ALLOW_FAKE is set, and any other provider name,
+// or none, stops the server rather than leaving payments unguarded.
+func Connect() PaymentProvider {
+	switch config.PAYMENT_PROVIDER {
+	case "razorpay":
+		if config.PAYMENT_KEY_ID == "" || config.PAYMENT_KEY_SECRET == "" || config.PAYMENT_WEBHOOK_SECRET == "" {
+			log.Fatal("PAYMENT_KEY_ID, PAYMENT_KEY_SECRET and PAYMENT_WEBHOOK_SECRET are required for the razorpay provider")
+		}
+		return NewRazorpayProvider(config.PAYMENT_KEY_ID, config.PAYMENT_KEY_SECRET, config.PAYMENT_WEBHOOK_SECRET)
+	case "fake", "":
+		if !config.PAYMENT_ALLOW_FAKE {
+			log.Fatal("PAYMENT_PROVIDER must be set to razorpay, or PAYMENT_ALLOW_FAKE to take fake payments in development")
+		}
+		return NewFakeProvider(config.PAYMENT_KEY_SECRET)
+	default:
+		log.Fatalf("Unknown PAYMENT_PROVIDER %q", config.PAYMENT_PROVIDER)
+		return nil
+	}
+}
+
+// sign is the hex encoded HMAC-SHA256 of payload, the signature scheme of
+// both callbacks and webhooks.
+func sign(payload []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verify(payload []byte, signature string, secret string) error {
+	if !hmac.Equal([]byte(sign(payload, secret)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// callbackPayload is what the signature of a checkout callback covers.
+func callbackPayload(callback Callback) []byte {
+	return []byte(callback.ProviderOrderID + "|" + callback.ProviderPaymentID)
+}
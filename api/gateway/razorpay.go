@@ -0,0 +1,210 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+)
+
+const razorpayBaseURL = "https://api.razorpay.com/v1"
+
+// RazorpayProvider takes payments through Razorpay. Amounts are exchanged in
+// paise.
+type RazorpayProvider struct {
+	KeyID         string
+	KeySecret     string
+	WebhookSecret string
+	BaseURL       string
+	Client        *http.Client
+}
+
+func NewRazorpayProvider(key_id string, key_secret string, webhook_secret string) *RazorpayProvider {
+	return &RazorpayProvider{
+		KeyID:         key_id,
+		KeySecret:     key_secret,
+		WebhookSecret: webhook_secret,
+		BaseURL:       razorpayBaseURL,
+		Client:        &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (p *RazorpayProvider) Name() string {
+	return "razorpay"
+}
+
+func toPaise(amount money.Amount) int64 {
+	return int64(amount)
+}
+
+func fromPaise(amount int64) money.Amount {
+	return money.Amount(amount)
+}
+
+func (p *RazorpayProvider) CreateOrder(ctx context.Context, amount money.Amount, currency money.Currency, receipt string) (*PaymentOrder, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"amount":   toPaise(amount),
+		"currency": currency,
+		"receipt":  receipt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+"/orders", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.SetBasicAuth(p.KeyID, p.KeySecret)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := p.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var order struct {
+		ID       string         `json:"id"`
+		Amount   int64          `json:"amount"`
+		Currency money.Currency `json:"currency"`
+		Error    struct {
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&order); err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("razorpay: creating order failed with %d: %s", response.StatusCode, order.Error.Description)
+	}
+
+	return &PaymentOrder{
+		Provider:        p.Name(),
+		ProviderOrderID: order.ID,
+		Amount:          fromPaise(order.Amount),
+		Currency:        order.Currency,
+		PublicKey:       p.KeyID,
+	}, nil
+}
+
+func (p *RazorpayProvider) Refund(ctx context.Context, provider_payment_id string, amount money.Amount, receipt string) (*RefundResult, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"amount":  toPaise(amount),
+		"receipt": receipt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+"/payments/"+provider_payment_id+"/refund", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.SetBasicAuth(p.KeyID, p.KeySecret)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := p.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var refund struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+		Error  struct {
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&refund); err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("razorpay: refund failed with %d: %s", response.StatusCode, refund.Error.Description)
+	}
+
+	status := RefundStatusPending
+	if refund.Status == "processed" {
+		status = RefundStatusProcessed
+	}
+	return &RefundResult{ProviderRefundID: refund.ID, Status: status}, nil
+}
+
+func (p *RazorpayProvider) VerifyCallback(callback Callback) error {
+	return verify(callbackPayload(callback), callback.Signature, p.KeySecret)
+}
+
+func (p *RazorpayProvider) SignatureHeader() string {
+	return "X-Razorpay-Signature"
+}
+
+func (p *RazorpayProvider) ParseWebhook(body []byte, signature string) (*Event, error) {
+	if err := verify(body, signature, p.WebhookSecret); err != nil {
+		return nil, err
+	}
+	return parseRazorpayEvent(body)
+}
+
+type razorpayPayment struct {
+	ID               string `json:"id"`
+	OrderID          string `json:"order_id"`
+	Amount           int64  `json:"amount"`
+	Method           string `json:"method"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type razorpayRefund struct {
+	ID        string `json:"id"`
+	PaymentID string `json:"payment_id"`
+	Amount    int64  `json:"amount"`
+	Receipt   string `json:"receipt"`
+}
+
+// parseRazorpayEvent reads the webhook events the shop acts on. Others are
+// returned with their type only, for the caller to ignore.
+func parseRazorpayEvent(body []byte) (*Event, error) {
+	var webhook struct {
+		Event   string `json:"event"`
+		Payload struct {
+			Payment struct {
+				Entity razorpayPayment `json:"entity"`
+			} `json:"payment"`
+			Refund struct {
+				Entity razorpayRefund `json:"entity"`
+			} `json:"refund"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return nil, err
+	}
+
+	payment := webhook.Payload.Payment.Entity
+	event := &Event{
+		Type:              webhook.Event,
+		ProviderOrderID:   payment.OrderID,
+		ProviderPaymentID: payment.ID,
+		Amount:            fromPaise(payment.Amount),
+		Method:            payment.Method,
+	}
+	switch webhook.Event {
+	case EventPaymentFailed:
+		event.FailureReason = payment.ErrorDescription
+	case "refund.processed", EventPaymentRefunded, EventRefundFailed:
+		refund := webhook.Payload.Refund.Entity
+		if webhook.Event != EventRefundFailed {
+			event.Type = EventPaymentRefunded
+		}
+		if refund.PaymentID != "" {
+			event.ProviderPaymentID = refund.PaymentID
+		}
+		event.ProviderRefundID = refund.ID
+		event.Receipt = refund.Receipt
+		event.Amount = fromPaise(refund.Amount)
+	}
+	return event, nil
+}
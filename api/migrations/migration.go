@@ -4,7 +4,10 @@ import (
 	"log"
 
 	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/inventory"
 	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/orders"
+	"gorm.io/gorm"
 )
 
 func Migrate() {
@@ -13,14 +16,99 @@ func Migrate() {
 	if err != nil {
 		log.Fatalf("Error enabling UUID extension: %v", err)
 	}
+	if err := convertMoneyColumns(database); err != nil {
+		log.Fatalf("Error converting money columns: %v", err)
+	}
 	database.AutoMigrate(
 		&models.User{},
 		&models.Category{},
 		&models.Item{},
 		&models.Detail{},
+		&models.ItemImage{},
+		&models.ItemPriceHistory{},
+		&models.ItemPriceSchedule{},
+		&models.InventoryUnit{},
+		&models.StockMovement{},
+		&models.StockReservation{},
+		&models.Location{},
+		&models.StockLevel{},
+		&models.StockTransfer{},
+		&models.StockAlert{},
+		&models.StockSubscription{},
+		&models.Notification{},
+		&models.SKUSequence{},
+		&models.BundleComponent{},
+		&models.RelatedItem{},
+		&models.OrderEvent{},
+		&models.Payment{},
+		&models.PaymentAttempt{},
+		&models.Refund{},
+		&models.DocumentSequence{},
+		&models.CreditNote{},
+		&models.CreditNoteLine{},
+		&models.Invoice{},
+		&models.InvoiceLine{},
 		&models.Orders{},
 		&models.OrderItem{},
 		&models.ShippingDetails{},
 		&models.DeliveryDetails{},
 	)
+
+	if err := inventory.BackfillOpeningStock(database); err != nil {
+		log.Fatalf("Error backfilling stock ledger: %v", err)
+	}
+	if err := orders.BackfillPayments(database); err != nil {
+		log.Fatalf("Error backfilling payments: %v", err)
+	}
+	if err := orders.BackfillInvoices(database); err != nil {
+		log.Fatalf("Error backfilling invoices: %v", err)
+	}
+}
+
+// moneyColumns are the columns that held amounts as decimal rupees before
+// amounts were kept in paise.
+var moneyColumns = map[string][]string{
+	"items":                {"price"},
+	"item_price_histories": {"previous_price", "price"},
+	"item_price_schedules": {"price"},
+	"inventory_units":      {"acquisition_cost"},
+	"orders":               {"billable_amount", "billable_amount_paid"},
+	"order_items":          {"billable_amount", "billable_amount_paid"},
+	"payments":             {"amount"},
+	"payment_attempts":     {"amount"},
+	"refunds":              {"amount"},
+	"invoices":             {"taxable_value", "cgst", "sgst", "igst", "total"},
+	"invoice_lines":        {"taxable_value", "cgst", "sgst", "igst", "total"},
+	"credit_notes":         {"taxable_value", "cgst", "sgst", "igst", "total"},
+	"credit_note_lines":    {"taxable_value", "cgst", "sgst", "igst", "total"},
+}
+
+// convertMoneyColumns turns decimal rupee columns into bigint paise. It has
+// to run before AutoMigrate, which would change their type without scaling
+// the values, and skips columns that are integers already or do not exist.
+func convertMoneyColumns(database *gorm.DB) error {
+	return database.Transaction(func(tx *gorm.DB) error {
+		for table, columns := range moneyColumns {
+			for _, column := range columns {
+				var dataType string
+				if err := tx.Raw(`SELECT data_type FROM information_schema.columns
+					WHERE table_schema = CURRENT_SCHEMA() AND table_name = ? AND column_name = ?`,
+					table, column).Scan(&dataType).Error; err != nil {
+					return err
+				}
+				if dataType != "numeric" && dataType != "double precision" && dataType != "real" {
+					continue
+				}
+
+				log.Printf("Converting %s.%s to paise", table, column)
+				if err := tx.Exec(`ALTER TABLE "` + table + `" ALTER COLUMN "` + column + `" DROP DEFAULT`).Error; err != nil {
+					return err
+				}
+				if err := tx.Exec(`ALTER TABLE "` + table + `" ALTER COLUMN "` + column + `" TYPE bigint USING ROUND("` + column + `" * 100)::bigint`).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
 }
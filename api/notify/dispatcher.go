@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+)
+
+const maxAttempts = 5
+
+// DispatchQueued sends queued notifications through the configured notifier.
+// Failed sends stay queued until they have been attempted maxAttempts times.
+func DispatchQueued(ctx context.Context) (int, error) {
+	var notifications []models.Notification
+	if err := db.GetDB().Where("status = ?", models.NotificationStatusQueued).
+		Order("created_at ASC").Limit(100).Find(&notifications).Error; err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, notification := range notifications {
+		updates := map[string]interface{}{
+			"attempts": notification.Attempts + 1,
+		}
+		err := GetNotifier().Send(ctx, Message{
+			To:      notification.Recipient,
+			Subject: notification.Subject,
+			Body:    notification.Body,
+		})
+		if err != nil {
+			updates["last_error"] = err.Error()
+			if notification.Attempts+1 >= maxAttempts {
+				updates["status"] = models.NotificationStatusFailed
+			}
+		} else {
+			updates["status"] = models.NotificationStatusSent
+			updates["sent_at"] = time.Now().Unix()
+			sent++
+		}
+		if err := db.GetDB().Model(&notification).Updates(updates).Error; err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// StartDispatcher runs DispatchQueued every interval for the lifetime of the
+// process.
+func StartDispatcher(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := DispatchQueued(context.Background()); err != nil {
+				log.Println("Notification dispatch failed:", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,14 @@
+package notify
+
+import (
+	"context"
+	"log"
+)
+
+// LogNotifier writes messages to the process log instead of sending them.
+type LogNotifier struct{}
+
+func (n *LogNotifier) Send(ctx context.Context, message Message) error {
+	log.Printf("Notification to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
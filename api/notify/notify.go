@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"context"
+	"log"
+
+	"github.com/Baalamurgan/coin-selling-backend/config"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"gorm.io/gorm"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers a message to its recipient. Messages are queued with
+// Enqueue and handed to the notifier by the dispatcher, so callers never
+// wait on delivery.
+type Notifier interface {
+	Send(ctx context.Context, message Message) error
+}
+
+var notifier Notifier = nil
+
+func GetNotifier() Notifier {
+	if notifier != nil {
+		return notifier
+	}
+	notifier = Connect()
+	return notifier
+}
+
+func Connect() Notifier {
+	switch config.NOTIFIER_DRIVER {
+	case "smtp":
+		if config.SMTP_HOST == "" || config.SMTP_FROM == "" {
+			log.Fatal("SMTP_HOST and SMTP_FROM are required for the smtp notifier")
+		}
+		return NewSMTPNotifier(config.SMTP_HOST, config.SMTP_PORT, config.SMTP_USERNAME, config.SMTP_PASSWORD, config.SMTP_FROM)
+	default:
+		return &LogNotifier{}
+	}
+}
+
+// Enqueue adds an email to the outbox within tx, so it is only sent if the
+// surrounding change commits.
+func Enqueue(tx *gorm.DB, to string, subject string, body string) error {
+	if to == "" {
+		return nil
+	}
+	return tx.Create(&models.Notification{
+		Channel:   "email",
+		Recipient: to,
+		Subject:   subject,
+		Body:      body,
+		Status:    models.NotificationStatusQueued,
+	}).Error
+}
@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPNotifier sends messages as plain text emails through an SMTP relay.
+type SMTPNotifier struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+}
+
+func NewSMTPNotifier(host string, port string, username string, password string, from string) *SMTPNotifier {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPNotifier{
+		Addr: net.JoinHostPort(host, port),
+		Auth: auth,
+		From: from,
+	}
+}
+
+func (n *SMTPNotifier) Send(ctx context.Context, message Message) error {
+	if strings.ContainsAny(message.To, "\r\n") || strings.ContainsAny(message.Subject, "\r\n") {
+		return fmt.Errorf("invalid header in message to %q", message.To)
+	}
+
+	body := strings.Join([]string{
+		"From: " + n.From,
+		"To: " + message.To,
+		"Subject: " + message.Subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		message.Body,
+	}, "\r\n")
+	return smtp.SendMail(n.Addr, n.Auth, n.From, []string{message.To}, []byte(body))
+}
@@ -1,9 +1,11 @@
 package routes
 
 import (
+	"github.com/Baalamurgan/coin-selling-backend/api/gateway"
 	"github.com/Baalamurgan/coin-selling-backend/pkg/auth"
 	"github.com/Baalamurgan/coin-selling-backend/pkg/category"
 	"github.com/Baalamurgan/coin-selling-backend/pkg/data"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/inventory"
 	"github.com/Baalamurgan/coin-selling-backend/pkg/item"
 	"github.com/Baalamurgan/coin-selling-backend/pkg/orders"
 	"github.com/gofiber/fiber/v2"
@@ -13,6 +15,9 @@ func SetupRoutes(app *fiber.App) {
 	api := app.Group("/api")
 	v1 := api.Group("/v1")
 	v1.Post("/populate", data.Populate)
+	v1.Post("/import/items", data.ImportItems)
+	v1.Get("/export/items", data.ExportItems)
+	v1.Get("/export/feed", data.ExportMerchantFeed)
 
 	// Auth
 	authGroup := v1.Group("/auth")
@@ -42,9 +47,72 @@ func SetupRoutes(app *fiber.App) {
 	itemGroup.Get("/sub_category/:sub_category_id", item.GetItemsBySubCategoryID)
 	itemGroup.Get("/:id", item.GetItemByID)
 	itemGroup.Get("/slug/:slug", item.GetItemBySlug)
+	itemGroup.Get("/sku/preview", item.PreviewSKU)
+	itemGroup.Post("/labels", item.PrintItemLabels)
 	itemGroup.Post("/:category_id", item.CreateItem)
 	itemGroup.Put("/:id", item.UpdateItem)
 	itemGroup.Delete("/:id", item.DeleteItem)
+	itemGroup.Patch("/:id/publish", item.PublishItem)
+	itemGroup.Patch("/:id/unpublish", item.UnpublishItem)
+	itemGroup.Patch("/:id/archive", item.ArchiveItem)
+	// Item Prices
+	itemGroup.Get("/:id/prices", item.GetItemPriceTimeline)
+	itemGroup.Post("/:id/prices/schedule", item.ScheduleItemPrice)
+	itemGroup.Delete("/:id/prices/schedule/:schedule_id", item.CancelItemPriceSchedule)
+	// Item Variants
+	itemGroup.Get("/:id/variants", item.GetItemVariants)
+	itemGroup.Post("/:id/variants", item.CreateItemVariant)
+	itemGroup.Put("/:id/parent", item.SetItemParent)
+	// Related Items
+	itemGroup.Get("/:id/related", item.GetRelatedItems)
+	itemGroup.Put("/:id/related", item.SetRelatedItems)
+	// Bundle Components
+	itemGroup.Get("/:id/components", item.GetBundleComponents)
+	itemGroup.Put("/:id/components", item.SetBundleComponents)
+	// Item Images
+	itemGroup.Get("/:id/images", item.GetItemImages)
+	itemGroup.Post("/:id/images", item.UploadItemImages)
+	itemGroup.Put("/:id/images/order", item.ReorderItemImages)
+	itemGroup.Delete("/:id/images/:image_id", item.DeleteItemImage)
+
+	// Inventory
+	inventoryGroup := v1.Group("/inventory")
+	inventoryGroup.Get("/verify", inventory.VerifyStock)
+	inventoryGroup.Get("/item/:item_id/ledger", inventory.GetItemLedger)
+	inventoryGroup.Post("/item/:item_id/adjust", inventory.AdjustStock)
+	inventoryGroup.Get("/item/:item_id/units", inventory.GetItemUnits)
+	inventoryGroup.Post("/item/:item_id/units", inventory.CreateUnit)
+	inventoryGroup.Get("/units/:id", inventory.GetUnitByID)
+	inventoryGroup.Put("/units/:id", inventory.UpdateUnit)
+	inventoryGroup.Delete("/units/:id", inventory.DeleteUnit)
+	inventoryGroup.Get("/locations", inventory.GetLocations)
+	inventoryGroup.Post("/locations", inventory.CreateLocation)
+	inventoryGroup.Put("/locations/:id", inventory.UpdateLocation)
+	inventoryGroup.Get("/item/:item_id/levels", inventory.GetItemStockLevels)
+	inventoryGroup.Get("/transfers", inventory.GetTransfers)
+	inventoryGroup.Post("/transfers", inventory.CreateTransfer)
+	inventoryGroup.Patch("/transfers/:id/receive", inventory.ReceiveTransfer)
+	inventoryGroup.Patch("/transfers/:id/cancel", inventory.CancelTransfer)
+	inventoryGroup.Get("/alerts", inventory.GetStockAlerts)
+	inventoryGroup.Patch("/alerts/:id/acknowledge", inventory.AcknowledgeStockAlert)
+	inventoryGroup.Get("/item/:item_id/subscriptions", inventory.GetItemSubscriptions)
+
+	// Storefront
+	storeGroup := v1.Group("/store")
+	storeGroup.Get("/items", item.GetPublishedItems)
+	storeGroup.Get("/items/slug/:slug", item.GetPublishedItemBySlug)
+	storeGroup.Get("/items/:id/recommendations", item.GetItemRecommendations)
+	storeGroup.Post("/items/:id/notify", inventory.SubscribeBackInStock)
+	storeGroup.Delete("/subscriptions/:id", inventory.UnsubscribeBackInStock)
+	storeGroup.Post("/orders/:id/checkout", orders.CreateCheckout)
+	storeGroup.Post("/orders/:id/checkout/verify", orders.VerifyCheckout)
+	// the fake provider's checkout only exists when it was chosen for development
+	if _, ok := gateway.GetProvider().(*gateway.FakeProvider); ok {
+		storeGroup.Get("/checkout/fake/:provider_order_id", orders.FakeCheckoutPayment)
+	}
+
+	// Webhooks
+	v1.Post("/webhooks/payments", orders.PaymentWebhook)
 
 	// Order
 	orderGroup := v1.Group("/order")
@@ -59,9 +127,20 @@ func SetupRoutes(app *fiber.App) {
 	orderGroup.Patch("/:id/ship", orders.MarkOrderAsShipped)
 	orderGroup.Patch("/:id/deliver", orders.MarkOrderAsDelivered)
 	orderGroup.Patch("/:id/restore", orders.RestoreOrder)
+	orderGroup.Get("/:id/transitions", orders.GetOrderTransitions)
+	orderGroup.Get("/:id/quote", orders.GetOrderQuote)
+	orderGroup.Get("/:id/timeline", orders.GetOrderTimeline)
+	orderGroup.Post("/:id/notes", orders.AddOrderNote)
+	orderGroup.Get("/:id/payments", orders.GetOrderPayments)
+	orderGroup.Post("/:id/payments", orders.RecordPayment)
+	orderGroup.Get("/:id/invoice", orders.GetOrderInvoice)
+	orderGroup.Get("/:id/refunds", orders.GetOrderRefunds)
+	orderGroup.Post("/:id/refunds", orders.CreateRefund)
+	orderGroup.Get("/:id/credit-notes/:credit_note_id", orders.GetCreditNote)
 	// Order Item
 	orderItemGroup := orderGroup.Group("/item")
 	orderItemGroup.Post("/add", orders.AddItemToOrder)
 	orderItemGroup.Delete("/:order_id/:order_item_id", orders.DeleteOrderItemFromOrder)
+	orderItemGroup.Patch("/:order_id/:order_item_id/cancel", orders.CancelOrderItem)
 	orderItemGroup.Patch("/update-quantity", orders.UpdateOrderItemQuantity)
 }
@@ -1,5 +1,7 @@
 package schemas
 
+import "github.com/Baalamurgan/coin-selling-backend/pkg/money"
+
 type GetUserRequest struct {
 	UserID string `json:"user_id"`
 }
@@ -12,15 +14,16 @@ type CreateCategoryRequest struct {
 	Name             string `gorm:"not null" json:"name"`
 	Description      string `json:"description"`
 	ParentCategoryID string `gorm:"uuid; default: null" json:"parent_category_id"`
+	SKUPrefix        string `json:"sku_prefix" validate:"omitempty,alphanum,max=10"`
 }
 
 type Item struct {
-	Name        string   `json:"name"`
-	Year        int      `json:"year"`
-	ImageURL    string   `json:"image_url"`
-	Description string   `json:"description"`
-	Price       float64  `json:"price"`
-	Details     []Detail `json:"details"`
+	Name        string       `json:"name"`
+	Year        int          `json:"year"`
+	ImageURL    string       `json:"image_url"`
+	Description string       `json:"description"`
+	Price       money.Amount `json:"price"`
+	Details     []Detail     `json:"details"`
 }
 
 type Detail struct {
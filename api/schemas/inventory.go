@@ -0,0 +1,63 @@
+package schemas
+
+import "github.com/Baalamurgan/coin-selling-backend/pkg/money"
+
+type CreateInventoryUnitRequest struct {
+	SerialNumber      string       `json:"serial_number" validate:"required"`
+	CertificateNumber string       `json:"certificate_number"`
+	Grade             string       `json:"grade"`
+	AcquisitionCost   money.Amount `json:"acquisition_cost" validate:"gte=0"`
+	AcquiredAt        int          `json:"acquired_at"`
+	Location          string       `json:"location"`
+	Notes             string       `json:"notes"`
+}
+
+type UpdateInventoryUnitRequest struct {
+	SerialNumber      *string       `json:"serial_number"`
+	CertificateNumber *string       `json:"certificate_number"`
+	Grade             *string       `json:"grade"`
+	AcquisitionCost   *money.Amount `json:"acquisition_cost"`
+	AcquiredAt        *int          `json:"acquired_at"`
+	Location          *string       `json:"location"`
+	Notes             *string       `json:"notes"`
+}
+
+type StockAdjustmentRequest struct {
+	UserID     string `gorm:"uuid;" json:"user_id" validate:"required"`
+	Quantity   int    `json:"quantity" validate:"required"`
+	Reason     string `json:"reason" validate:"required,oneof=restock damage return correction"`
+	Note       string `json:"note"`
+	LocationID string `gorm:"uuid;" json:"location_id"` // required once the item is stocked per location
+}
+
+type CreateLocationRequest struct {
+	Name    string `json:"name" validate:"required"`
+	Code    string `json:"code" validate:"required"`
+	Kind    string `json:"kind" validate:"required,oneof=shop vault warehouse"`
+	Address string `json:"address"`
+}
+
+type UpdateLocationRequest struct {
+	Name     *string `json:"name"`
+	Kind     *string `json:"kind" validate:"omitempty,oneof=shop vault warehouse"`
+	Address  *string `json:"address"`
+	IsActive *bool   `json:"is_active"`
+}
+
+type CreateStockTransferRequest struct {
+	UserID         string `gorm:"uuid;" json:"user_id" validate:"required"`
+	ItemID         string `gorm:"uuid;" json:"item_id" validate:"required"`
+	FromLocationID string `gorm:"uuid;" json:"from_location_id" validate:"required"`
+	ToLocationID   string `gorm:"uuid;" json:"to_location_id" validate:"required"`
+	Quantity       int    `json:"quantity" validate:"required,gt=0"`
+	Note           string `json:"note"`
+}
+
+type AcknowledgeStockAlertRequest struct {
+	UserID string `gorm:"uuid;" json:"user_id" validate:"required"`
+}
+
+type StockSubscriptionRequest struct {
+	Email  string `json:"email" validate:"required,email"`
+	UserID string `gorm:"uuid;" json:"user_id"`
+}
@@ -1,30 +1,92 @@
 package schemas
 
-import "github.com/google/uuid"
+import (
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/google/uuid"
+)
 
 type CreateItemRequest struct {
-	Name        string   `json:"name" validate:"required"`
-	Description string   `json:"description"`
-	Year        int      `json:"year"`
-	ImageURL    string   `json:"image_url"`
-	Price       float64  `json:"price"`
-	SKU         string   `json:"sku"`
-	Stock       int      `json:"stock"`
-	Sold        int      `json:"sold"`
-	GST         float64  `json:"gst"`
-	Details     []Detail `json:"details"`
+	Name             string       `json:"name" validate:"required"`
+	Description      string       `json:"description"`
+	Year             int          `json:"year"`
+	ImageURL         string       `json:"image_url"`
+	Price            money.Amount `json:"price"`
+	SKU              string       `json:"sku"`
+	Stock            int          `json:"stock"`
+	Sold             int          `json:"sold"`
+	GST              float64      `json:"gst"`
+	HSN              string       `json:"hsn" validate:"omitempty,numeric,min=4,max=8"`
+	Details          []Detail     `json:"details"`
+	Status           string       `json:"status" validate:"omitempty,oneof=draft published"`
+	PublishAt        *int         `json:"publish_at"`
+	IsSerialised     bool         `json:"is_serialised"`
+	ReorderThreshold int          `json:"reorder_threshold" validate:"gte=0"`
+	IsBundle         bool         `json:"is_bundle"`
 }
 
 type UpdateItemRequest struct {
-	CategoryID  *uuid.UUID `json:"category_id"`
-	Name        *string    `json:"name"`
-	Description *string    `json:"description"`
-	Year        *int       `json:"year"`
-	ImageURL    *string    `json:"image_url"`
-	Price       *float64   `json:"price"`
-	SKU         *string    `json:"sku"`
-	Stock       *int       `json:"stock"`
-	Sold        *int       `json:"sold"`
-	GST         *float64   `json:"gst"`
-	Details     []Detail   `json:"details"`
+	CategoryID       *uuid.UUID    `json:"category_id"`
+	Name             *string       `json:"name"`
+	Description      *string       `json:"description"`
+	Year             *int          `json:"year"`
+	ImageURL         *string       `json:"image_url"`
+	Price            *money.Amount `json:"price"`
+	SKU              *string       `json:"sku"`
+	GST              *float64      `json:"gst"`
+	HSN              *string       `json:"hsn" validate:"omitempty,numeric,min=4,max=8"`
+	Details          []Detail      `json:"details"`
+	IsSerialised     *bool         `json:"is_serialised"`
+	ReorderThreshold *int          `json:"reorder_threshold" validate:"omitempty,gte=0"`
+}
+
+type ReorderItemImagesRequest struct {
+	ImageIDs []string `json:"image_ids" validate:"required"`
+}
+
+type PublishItemRequest struct {
+	PublishAt *int `json:"publish_at"`
+}
+
+type ScheduleItemPriceRequest struct {
+	Kind     string       `json:"kind" validate:"required,oneof=price_change sale"`
+	Price    money.Amount `json:"price" validate:"gt=0"`
+	GST      *float64     `json:"gst"`
+	StartsAt int          `json:"starts_at" validate:"required"`
+	EndsAt   *int         `json:"ends_at"`
+}
+
+type PrintItemLabelsRequest struct {
+	ItemIDs   []string `json:"item_ids" validate:"required,min=1"`
+	Copies    int      `json:"copies" validate:"omitempty,gte=1,lte=100"`
+	WithPrice bool     `json:"with_price"`
+}
+
+type BundleComponentRequest struct {
+	ItemID   string `gorm:"uuid;" json:"item_id" validate:"required"`
+	Quantity int    `json:"quantity" validate:"required,gt=0"`
+}
+
+type SetBundleComponentsRequest struct {
+	Components []BundleComponentRequest `json:"components" validate:"required,min=1,dive"`
+	Discount   float64                  `json:"discount" validate:"gte=0,lt=100"`
+}
+
+type CreateItemVariantRequest struct {
+	Grade       string       `json:"grade" validate:"required,max=50"`
+	SKU         string       `json:"sku"`
+	Description string       `json:"description"`
+	ImageURL    string       `json:"image_url"`
+	Price       money.Amount `json:"price" validate:"gt=0"`
+	GST         *float64     `json:"gst"`
+	Stock       int          `json:"stock" validate:"gte=0"`
+	Details     []Detail     `json:"details"`
+}
+
+type SetItemParentRequest struct {
+	ParentID string `gorm:"uuid;" json:"parent_id"` // empty detaches the variant
+	Grade    string `json:"grade" validate:"max=50"`
+}
+
+type SetRelatedItemsRequest struct {
+	ItemIDs []string `json:"item_ids"` // in display order, empty clears the list
 }
@@ -1,5 +1,7 @@
 package schemas
 
+import "github.com/Baalamurgan/coin-selling-backend/pkg/money"
+
 type CreateOrder struct {
 	UserID string `gorm:"uuid;" json:"user_id"`
 }
@@ -9,8 +11,20 @@ type ConfirmOrderRequest struct {
 }
 
 type MarkOrderAsPaidRequest struct {
-	UserID             string  `gorm:"uuid;" json:"user_id" validate:"required"`
-	BillableAmountPaid float64 `json:"billable_amount_paid" validate:"required"`
+	UserID             string       `gorm:"uuid;" json:"user_id" validate:"required"`
+	BillableAmountPaid money.Amount `json:"billable_amount_paid" validate:"required"` // amount received, recorded as a payment
+	Method             string       `json:"method" validate:"omitempty,oneof=cash upi bank_transfer card"`
+	Reference          string       `json:"reference"`
+}
+
+type RecordPaymentRequest struct {
+	UserID           string       `gorm:"uuid;" json:"user_id" validate:"required"`
+	Amount           money.Amount `json:"amount" validate:"required"`
+	Method           string       `json:"method" validate:"required,oneof=cash upi bank_transfer card"`
+	Reference        string       `json:"reference"`
+	ReceivedAt       int          `json:"received_at"` // defaults to now
+	Note             string       `json:"note"`
+	AllowOverpayment bool         `json:"allow_overpayment"`
 }
 
 type MarkOrderAsShippedRequest struct {
@@ -18,6 +32,7 @@ type MarkOrderAsShippedRequest struct {
 	ShippingName string `json:"shipping_name"`
 	ShippingID   string `json:"shipping_id"`
 	ShippingDate int    `json:"shipping_date"`
+	LocationID   string `gorm:"uuid;" json:"location_id"` // where the order is fulfilled from
 }
 
 type MarkOrderAsDeliveredRequest struct {
@@ -30,6 +45,18 @@ type MarkOrderAsDeliveredRequest struct {
 type CancelOrderRequest struct {
 	UserID             string `gorm:"uuid;" json:"user_id" validate:"required"`
 	CancellationReason string `json:"cancellation_reason" validate:"required"`
+	Refund             bool   `json:"refund"` // refund everything paid on the order
+}
+
+type VerifyCheckoutRequest struct {
+	ProviderOrderID   string `json:"provider_order_id" validate:"required"`
+	ProviderPaymentID string `json:"provider_payment_id" validate:"required"`
+	Signature         string `json:"signature" validate:"required"`
+}
+
+type CancelOrderItemRequest struct {
+	UserID             string `gorm:"uuid;" json:"user_id" validate:"required"`
+	CancellationReason string `json:"cancellation_reason"`
 }
 
 type RestoreOrderRequest struct {
@@ -37,20 +64,41 @@ type RestoreOrderRequest struct {
 }
 
 type AddItemToOrder struct {
-	OrderID  string `gorm:"uuid;" json:"order_id"`
-	ItemID   string `gorm:"uuid;" json:"item_id"`
-	Quantity int    `json:"quantity"`
+	OrderID   string   `gorm:"uuid;" json:"order_id"`
+	ItemID    string   `gorm:"uuid;" json:"item_id"`
+	Quantity  int      `json:"quantity"`
+	UnitIDs   []string `json:"unit_ids"`                // specific units of a serialised item, overrides quantity
+	VariantID string   `gorm:"uuid;" json:"variant_id"` // variant to order when item_id is a parent product
+	Grade     string   `json:"grade"`                   // alternative to variant_id
+	UserID    string   `gorm:"uuid;" json:"user_id"`    // who made the change, for the order timeline
 }
 
 type UpdateOrderItemQuantity struct {
 	OrderItemID string `gorm:"uuid" json:"order_item_id" validate:"required"`
 	Quantity    int    `json:"quantity" validate:"required"`
+	UserID      string `gorm:"uuid;" json:"user_id"`
 }
 
 type EditOrder struct {
 	OrderItems []struct {
-		OrderItemID  string   `gorm:"uuid" json:"order_item_id"`
-		Quantity     *int     `json:"quantity"`
-		PricePerItem *float64 `json:"price_per_item"`
+		OrderItemID  string        `gorm:"uuid" json:"order_item_id"`
+		Quantity     *int          `json:"quantity"`
+		PricePerItem *money.Amount `json:"price_per_item"`
 	} `json:"order_items"`
+	UserID string `gorm:"uuid;" json:"user_id"`
+}
+
+type AddOrderNoteRequest struct {
+	UserID string `gorm:"uuid;" json:"user_id" validate:"required"`
+	Note   string `json:"note" validate:"required"`
+}
+
+type CreateRefundRequest struct {
+	UserID      string       `gorm:"uuid;" json:"user_id" validate:"required"`
+	PaymentID   string       `gorm:"uuid;" json:"payment_id" validate:"required"`
+	OrderItemID string       `gorm:"uuid;" json:"order_item_id"`
+	Amount      money.Amount `json:"amount" validate:"required,gt=0"`
+	Reason      string       `json:"reason" validate:"required,oneof=cancelled returned damaged goodwill overpayment other"`
+	Method      string       `json:"method" validate:"omitempty,oneof=cash upi bank_transfer card online"`
+	Note        string       `json:"note"`
 }
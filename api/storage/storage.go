@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log"
+
+	"github.com/Baalamurgan/coin-selling-backend/config"
+)
+
+// BlobStore persists uploaded files (item images and their renditions) and
+// resolves the public URL they are served from.
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+var store BlobStore = nil
+
+func GetStore() BlobStore {
+	if store != nil {
+		return store
+	}
+	store = Connect()
+	return store
+}
+
+func Connect() BlobStore {
+	switch config.STORAGE_DRIVER {
+	case "s3":
+		s3Store, err := NewS3Store(config.S3_ENDPOINT, config.S3_ACCESS_KEY, config.S3_SECRET_KEY, config.S3_REGION, config.S3_BUCKET, config.STORAGE_BASE_URL, config.S3_USE_SSL)
+		if err != nil {
+			log.Fatal("Failed to connect to the blob store:", err)
+		}
+		return s3Store
+	default:
+		return NewLocalStore(config.STORAGE_LOCAL_DIR, config.STORAGE_BASE_URL)
+	}
+}
@@ -22,6 +22,13 @@ func ImportEnv() {
 	viper.SetDefault("ENVIRONMENT", "development")
 	viper.SetDefault("PORT", 8080)
 	viper.SetDefault("MIGRATE", false)
+	viper.SetDefault("STORAGE_DRIVER", "local")
+	viper.SetDefault("STORAGE_LOCAL_DIR", "./uploads")
+	viper.SetDefault("STOREFRONT_URL", "http://localhost:3000")
+	viper.SetDefault("RESERVATION_TTL_MINUTES", 30)
+	viper.SetDefault("NOTIFIER_DRIVER", "log")
+	viper.SetDefault("SMTP_PORT", "587")
+	viper.SetDefault("SKU_PATTERN", "{PREFIX}-{YEAR}-{SEQ:4}")
 
 	viper.AutomaticEnv()
 
@@ -7,11 +7,42 @@ import (
 )
 
 var (
-	ENVIRONMENT     = ""
-	PORT            = ""
-	MIGRATE         = false
-	DB_URI          = ""
-	REDIS_DB_NUMBER = ""
+	ENVIRONMENT       = ""
+	PORT              = ""
+	MIGRATE           = false
+	DB_URI            = ""
+	REDIS_DB_NUMBER   = ""
+	STORAGE_DRIVER    = ""
+	STORAGE_LOCAL_DIR = ""
+	STORAGE_BASE_URL  = ""
+	S3_ENDPOINT       = ""
+	S3_REGION         = ""
+	S3_BUCKET         = ""
+	S3_ACCESS_KEY     = ""
+	S3_SECRET_KEY     = ""
+	S3_USE_SSL        = false
+	STOREFRONT_URL    = ""
+	NOTIFIER_DRIVER   = ""
+	SMTP_HOST         = ""
+	SMTP_PORT         = ""
+	SMTP_USERNAME     = ""
+	SMTP_PASSWORD     = ""
+	SMTP_FROM         = ""
+	ADMIN_ALERT_EMAIL = ""
+	SKU_PATTERN       = ""
+
+	PAYMENT_PROVIDER       = ""
+	PAYMENT_KEY_ID         = ""
+	PAYMENT_KEY_SECRET     = ""
+	PAYMENT_WEBHOOK_SECRET = ""
+	PAYMENT_ALLOW_FAKE     = false
+
+	SELLER_NAME    = ""
+	SELLER_ADDRESS = ""
+	SELLER_STATE   = ""
+	SELLER_GSTIN   = ""
+
+	RESERVATION_TTL_MINUTES = 30
 )
 
 func LoadConfig() {
@@ -26,4 +57,36 @@ func LoadConfig() {
 	dbPassword := viper.GetString("DB_PASSWORD")
 
 	DB_URI = fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=Asia/Kolkata", dbHost, dbUser, dbPassword, dbName, dbPort)
+
+	STORAGE_DRIVER = viper.GetString("STORAGE_DRIVER") // local | s3
+	STORAGE_LOCAL_DIR = viper.GetString("STORAGE_LOCAL_DIR")
+	STORAGE_BASE_URL = viper.GetString("STORAGE_BASE_URL")
+	S3_ENDPOINT = viper.GetString("S3_ENDPOINT")
+	S3_REGION = viper.GetString("S3_REGION")
+	S3_BUCKET = viper.GetString("S3_BUCKET")
+	S3_ACCESS_KEY = viper.GetString("S3_ACCESS_KEY")
+	S3_SECRET_KEY = viper.GetString("S3_SECRET_KEY")
+	S3_USE_SSL = viper.GetBool("S3_USE_SSL")
+	STOREFRONT_URL = viper.GetString("STOREFRONT_URL")
+	RESERVATION_TTL_MINUTES = viper.GetInt("RESERVATION_TTL_MINUTES")
+	NOTIFIER_DRIVER = viper.GetString("NOTIFIER_DRIVER") // log | smtp
+	SMTP_HOST = viper.GetString("SMTP_HOST")
+	SMTP_PORT = viper.GetString("SMTP_PORT")
+	SMTP_USERNAME = viper.GetString("SMTP_USERNAME")
+	SMTP_PASSWORD = viper.GetString("SMTP_PASSWORD")
+	SMTP_FROM = viper.GetString("SMTP_FROM")
+	ADMIN_ALERT_EMAIL = viper.GetString("ADMIN_ALERT_EMAIL")
+	SKU_PATTERN = viper.GetString("SKU_PATTERN") // tokens: {PREFIX}, {YEAR}, {SEQ} or {SEQ:width}
+
+	PAYMENT_PROVIDER = viper.GetString("PAYMENT_PROVIDER") // fake | razorpay
+	PAYMENT_KEY_ID = viper.GetString("PAYMENT_KEY_ID")
+	PAYMENT_KEY_SECRET = viper.GetString("PAYMENT_KEY_SECRET")
+	PAYMENT_WEBHOOK_SECRET = viper.GetString("PAYMENT_WEBHOOK_SECRET")
+	PAYMENT_ALLOW_FAKE = viper.GetBool("PAYMENT_ALLOW_FAKE") // development only: the fake provider lets anyone mark orders paid
+
+	// printed on tax documents; SELLER_STATE decides between CGST+SGST and IGST
+	SELLER_NAME = viper.GetString("SELLER_NAME")
+	SELLER_ADDRESS = viper.GetString("SELLER_ADDRESS")
+	SELLER_STATE = viper.GetString("SELLER_STATE")
+	SELLER_GSTIN = viper.GetString("SELLER_GSTIN")
 }
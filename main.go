@@ -3,12 +3,17 @@ package main
 import (
 	"log"
 	"strings"
+	"time"
 
+	"github.com/Baalamurgan/coin-selling-backend/api/constants"
 	"github.com/Baalamurgan/coin-selling-backend/api/db"
 	"github.com/Baalamurgan/coin-selling-backend/api/migrations"
+	"github.com/Baalamurgan/coin-selling-backend/api/notify"
 	"github.com/Baalamurgan/coin-selling-backend/api/routes"
 	"github.com/Baalamurgan/coin-selling-backend/api/utils"
 	"github.com/Baalamurgan/coin-selling-backend/config"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/item"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/orders"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -35,7 +40,9 @@ func main() {
 	// utils.InitRedis()
 
 	// Create a new Fiber app instance
-	app := fiber.New(fiber.Config{})
+	app := fiber.New(fiber.Config{
+		BodyLimit: constants.MAX_UPLOAD_SIZE,
+	})
 
 	// Register routes
 	app.Get("/", healthCheck)
@@ -58,8 +65,16 @@ func main() {
 	// Initialize the database connection
 	db.GetDB()
 
+	if config.STORAGE_DRIVER != "s3" {
+		app.Static("/uploads", config.STORAGE_LOCAL_DIR)
+	}
+
 	routes.SetupRoutes(app)
 
+	item.StartScheduler(time.Minute)
+	orders.StartReservationSweeper(time.Minute)
+	notify.StartDispatcher(30 * time.Second)
+
 	// Start the server on port 8080
 	log.Println("Server started on http://localhost:8080")
 	if err := app.Listen(":8080"); err != nil {
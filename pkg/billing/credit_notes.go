@@ -0,0 +1,88 @@
+package billing
+
+import (
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/config"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"gorm.io/gorm"
+)
+
+// IssueCreditNote numbers a credit note and stores it. The caller fills in
+// the refund, buyer and each line's Total and GSTRate; the tax breakdown and
+// seller details are worked out here.
+func IssueCreditNote(tx *gorm.DB, note *models.CreditNote) error {
+	issuedAt := time.Now()
+	number, err := NextNumber(tx, SeriesCreditNote, issuedAt)
+	if err != nil {
+		return err
+	}
+
+	note.Number = number
+	note.IssuedAt = int(issuedAt.Unix())
+	note.SellerName = config.SELLER_NAME
+	note.SellerGSTIN = config.SELLER_GSTIN
+	note.SellerState = config.SELLER_STATE
+	if note.Currency == "" {
+		note.Currency = money.INR
+	}
+
+	var totals TaxSplit
+	for i := range note.Lines {
+		line := &note.Lines[i]
+		split := SplitTax(line.Total, line.GSTRate, note.BuyerState)
+		line.TaxableValue = split.TaxableValue
+		line.CGST = split.CGST
+		line.SGST = split.SGST
+		line.IGST = split.IGST
+		line.Total = split.Total
+		totals = addSplit(totals, split)
+	}
+	note.TaxableValue = totals.TaxableValue
+	note.CGST = totals.CGST
+	note.SGST = totals.SGST
+	note.IGST = totals.IGST
+	note.Total = totals.Total
+
+	return tx.Create(note).Error
+}
+
+// RenderCreditNote prints a credit note as a PDF. invoice_reference names the
+// invoice or order the note is issued against.
+func RenderCreditNote(note *models.CreditNote, invoice_reference string) ([]byte, error) {
+	document := taxDocument{
+		Title:         "Credit Note",
+		Number:        note.Number,
+		IssuedAt:      note.IssuedAt,
+		SellerName:    note.SellerName,
+		SellerGSTIN:   note.SellerGSTIN,
+		SellerState:   note.SellerState,
+		BuyerName:     note.BuyerName,
+		BuyerAddress:  note.BuyerAddress,
+		BuyerState:    note.BuyerState,
+		References:    []string{"Against " + invoice_reference, "Reason: " + note.Reason},
+		TaxableValue:  note.TaxableValue,
+		CGST:          note.CGST,
+		SGST:          note.SGST,
+		IGST:          note.IGST,
+		Total:         note.Total,
+		Currency:      note.Currency,
+		Interstate:    !Intrastate(note.BuyerState),
+		SellerAddress: config.SELLER_ADDRESS,
+	}
+	for _, line := range note.Lines {
+		document.Lines = append(document.Lines, taxDocumentLine{
+			Description:  line.Description,
+			SKU:          line.SKU,
+			HSN:          line.HSN,
+			GSTRate:      line.GSTRate,
+			TaxableValue: line.TaxableValue,
+			CGST:         line.CGST,
+			SGST:         line.SGST,
+			IGST:         line.IGST,
+			Total:        line.Total,
+		})
+	}
+	return document.render()
+}
@@ -0,0 +1,92 @@
+package billing
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/config"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"gorm.io/gorm"
+)
+
+// IssueInvoice numbers an invoice and stores it. The caller fills in the
+// order, buyer and each line's Total and GSTRate; the tax breakdown and
+// seller details are worked out here.
+func IssueInvoice(tx *gorm.DB, invoice *models.Invoice) error {
+	issuedAt := time.Now()
+	number, err := NextNumber(tx, SeriesInvoice, issuedAt)
+	if err != nil {
+		return err
+	}
+
+	invoice.Number = number
+	invoice.IssuedAt = int(issuedAt.Unix())
+	invoice.SellerName = config.SELLER_NAME
+	invoice.SellerGSTIN = config.SELLER_GSTIN
+	invoice.SellerState = config.SELLER_STATE
+	if invoice.Currency == "" {
+		invoice.Currency = money.INR
+	}
+
+	var totals TaxSplit
+	for i := range invoice.Lines {
+		line := &invoice.Lines[i]
+		split := SplitTax(line.Total, line.GSTRate, invoice.BuyerState)
+		line.TaxableValue = split.TaxableValue
+		line.CGST = split.CGST
+		line.SGST = split.SGST
+		line.IGST = split.IGST
+		line.Total = split.Total
+		totals = addSplit(totals, split)
+	}
+	invoice.TaxableValue = totals.TaxableValue
+	invoice.CGST = totals.CGST
+	invoice.SGST = totals.SGST
+	invoice.IGST = totals.IGST
+	invoice.Total = totals.Total
+
+	return tx.Create(invoice).Error
+}
+
+// RenderInvoice prints an invoice as a PDF.
+func RenderInvoice(invoice *models.Invoice) ([]byte, error) {
+	document := taxDocument{
+		Title:         "Tax Invoice",
+		Number:        invoice.Number,
+		IssuedAt:      invoice.IssuedAt,
+		SellerName:    invoice.SellerName,
+		SellerAddress: config.SELLER_ADDRESS,
+		SellerGSTIN:   invoice.SellerGSTIN,
+		SellerState:   invoice.SellerState,
+		BuyerName:     invoice.BuyerName,
+		BuyerAddress:  invoice.BuyerAddress,
+		BuyerState:    invoice.BuyerState,
+		References:    []string{"Order " + invoice.OrderID.String()},
+		TaxableValue:  invoice.TaxableValue,
+		CGST:          invoice.CGST,
+		SGST:          invoice.SGST,
+		IGST:          invoice.IGST,
+		Total:         invoice.Total,
+		Currency:      invoice.Currency,
+		Interstate:    !Intrastate(invoice.BuyerState),
+	}
+	for _, line := range invoice.Lines {
+		description := line.Description
+		if line.Quantity > 1 {
+			description = fmt.Sprintf("%s x %d", description, line.Quantity)
+		}
+		document.Lines = append(document.Lines, taxDocumentLine{
+			Description:  description,
+			SKU:          line.SKU,
+			HSN:          line.HSN,
+			GSTRate:      line.GSTRate,
+			TaxableValue: line.TaxableValue,
+			CGST:         line.CGST,
+			SGST:         line.SGST,
+			IGST:         line.IGST,
+			Total:        line.Total,
+		})
+	}
+	return document.render()
+}
@@ -0,0 +1,47 @@
+package billing
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	SeriesInvoice    = "INV"
+	SeriesCreditNote = "CN"
+)
+
+// ist is the time zone financial years are counted in.
+var ist = time.FixedZone("IST", 5*60*60+30*60)
+
+// FinancialYear names the Indian financial year, April to March, that t falls
+// in, such as "2026-27".
+func FinancialYear(t time.Time) string {
+	t = t.In(ist)
+	start := t.Year()
+	if t.Month() < time.April {
+		start--
+	}
+	return fmt.Sprintf("%d-%02d", start, (start+1)%100)
+}
+
+// NextNumber hands out the next document number of series for the financial
+// year of at, such as "CN/2026-27/0001". Numbers are gapless as long as tx
+// commits.
+func NextNumber(tx *gorm.DB, series string, at time.Time) (string, error) {
+	year := FinancialYear(at)
+	sequence := models.DocumentSequence{Series: series + "/" + year, Value: 1}
+	if err := tx.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "series"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"value": gorm.Expr("document_sequences.value + 1")}),
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "value"}}},
+	).Create(&sequence).Error; err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s/%04d", series, year, sequence.Value), nil
+}
@@ -0,0 +1,175 @@
+package billing
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/go-pdf/fpdf"
+)
+
+// taxDocument is what credit notes and invoices print: the parties, the
+// lines with their tax and the totals.
+type taxDocument struct {
+	Title         string
+	Number        string
+	IssuedAt      int
+	SellerName    string
+	SellerAddress string
+	SellerGSTIN   string
+	SellerState   string
+	BuyerName     string
+	BuyerAddress  string
+	BuyerState    string
+	References    []string
+	Lines         []taxDocumentLine
+	TaxableValue  money.Amount
+	CGST          money.Amount
+	SGST          money.Amount
+	IGST          money.Amount
+	Total         money.Amount
+	Currency      money.Currency
+	Interstate    bool
+}
+
+type taxDocumentLine struct {
+	Description  string
+	SKU          string
+	HSN          string
+	GSTRate      float64
+	TaxableValue money.Amount
+	CGST         money.Amount
+	SGST         money.Amount
+	IGST         money.Amount
+	Total        money.Amount
+}
+
+const (
+	pageMargin  = 12.0
+	lineHeight  = 5.0
+	headerSize  = 14.0
+	contentSize = 8.0
+)
+
+type column struct {
+	Title string
+	Width float64
+	Align string
+}
+
+func (d *taxDocument) columns() []column {
+	columns := []column{
+		{"#", 8, "C"},
+		{"Description", 46, "L"},
+		{"HSN", 14, "C"},
+		{"SKU", 22, "L"},
+		{"GST %", 12, "R"},
+		{"Taxable", 24, "R"},
+	}
+	if d.Interstate {
+		columns = append(columns, column{"IGST", 36, "R"})
+	} else {
+		columns = append(columns, column{"CGST", 18, "R"}, column{"SGST", 18, "R"})
+	}
+	return append(columns, column{"Total", 24, "R"})
+}
+
+func (d *taxDocument) render() ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pageMargin, pageMargin, pageMargin)
+	pdf.SetAutoPageBreak(true, pageMargin)
+	translate := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", headerSize)
+	pdf.CellFormat(0, 8, strings.ToUpper(d.Title), "", 1, "C", false, 0, "")
+	pdf.Ln(2)
+
+	pdf.SetFont("Helvetica", "B", contentSize+2)
+	pdf.CellFormat(0, lineHeight, translate(d.SellerName), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", contentSize)
+	if d.SellerAddress != "" {
+		pdf.MultiCell(0, lineHeight-1, translate(d.SellerAddress), "", "L", false)
+	}
+	pdf.CellFormat(0, lineHeight, fmt.Sprintf("GSTIN: %s    State: %s", d.SellerGSTIN, d.SellerState), "", 1, "L", false, 0, "")
+	pdf.Ln(2)
+
+	issuedAt := time.Unix(int64(d.IssuedAt), 0).In(ist).Format("02 Jan 2006")
+	pdf.CellFormat(0, lineHeight, fmt.Sprintf("%s No: %s    Date: %s", d.Title, d.Number, issuedAt), "", 1, "L", false, 0, "")
+	for _, reference := range d.References {
+		pdf.CellFormat(0, lineHeight, translate(reference), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(2)
+
+	pdf.SetFont("Helvetica", "B", contentSize)
+	pdf.CellFormat(0, lineHeight, "Bill to", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", contentSize)
+	pdf.CellFormat(0, lineHeight, translate(d.BuyerName), "", 1, "L", false, 0, "")
+	if d.BuyerAddress != "" {
+		pdf.MultiCell(0, lineHeight-1, translate(d.BuyerAddress), "", "L", false)
+	}
+	pdf.CellFormat(0, lineHeight, "Place of supply: "+translate(d.BuyerState), "", 1, "L", false, 0, "")
+	pdf.Ln(3)
+
+	columns := d.columns()
+	pdf.SetFont("Helvetica", "B", contentSize)
+	pdf.SetFillColor(235, 235, 235)
+	for _, column := range columns {
+		pdf.CellFormat(column.Width, lineHeight+1, column.Title, "1", 0, column.Align, true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Helvetica", "", contentSize)
+	for i, line := range d.Lines {
+		values := []string{
+			fmt.Sprintf("%d", i+1),
+			translate(truncate(line.Description, 36)),
+			line.HSN,
+			line.SKU,
+			fmt.Sprintf("%.2f", line.GSTRate),
+			line.TaxableValue.String(),
+		}
+		if d.Interstate {
+			values = append(values, line.IGST.String())
+		} else {
+			values = append(values, line.CGST.String(), line.SGST.String())
+		}
+		values = append(values, line.Total.String())
+		for j, column := range columns {
+			pdf.CellFormat(column.Width, lineHeight, values[j], "1", 0, column.Align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	pdf.Ln(3)
+
+	totals := [][2]string{{"Taxable value", d.TaxableValue.String()}}
+	if d.Interstate {
+		totals = append(totals, [2]string{"IGST", d.IGST.String()})
+	} else {
+		totals = append(totals, [2]string{"CGST", d.CGST.String()}, [2]string{"SGST", d.SGST.String()})
+	}
+	totals = append(totals, [2]string{fmt.Sprintf("Total (%s)", d.Currency), d.Total.String()})
+	for i, total := range totals {
+		if i == len(totals)-1 {
+			pdf.SetFont("Helvetica", "B", contentSize+1)
+		}
+		pdf.CellFormat(150, lineHeight, total[0], "", 0, "R", false, 0, "")
+		pdf.CellFormat(36, lineHeight, total[1], "", 1, "R", false, 0, "")
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length-3]) + "..."
+}
@@ -0,0 +1,78 @@
+package billing
+
+import (
+	"math"
+	"strings"
+
+	"github.com/Baalamurgan/coin-selling-backend/config"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+)
+
+// TaxSplit is an amount that includes GST, broken down into its taxable value
+// and tax. Supplies within the seller's state pay CGST and SGST in equal
+// halves, supplies to other states pay IGST.
+type TaxSplit struct {
+	TaxableValue money.Amount `json:"taxable_value"`
+	CGST         money.Amount `json:"cgst"`
+	SGST         money.Amount `json:"sgst"`
+	IGST         money.Amount `json:"igst"`
+	Total        money.Amount `json:"total"`
+}
+
+// Tax is the GST at rate percent on a line's taxable value, rounded half away
+// from zero to the paisa. It is worked out per line, never on an order total.
+func Tax(taxable money.Amount, rate float64) money.Amount {
+	return taxable.Percent(rate)
+}
+
+// TaxOn adds GST at rate percent to a line's taxable value for a buyer in
+// state.
+func TaxOn(taxable money.Amount, rate float64, state string) TaxSplit {
+	return splitFor(taxable, Tax(taxable, rate), state)
+}
+
+// Intrastate reports whether a buyer in state is in the seller's state. A
+// buyer without a state is taken to buy over the counter, within it.
+func Intrastate(state string) bool {
+	return state == "" || strings.EqualFold(strings.TrimSpace(state), strings.TrimSpace(config.SELLER_STATE))
+}
+
+// SplitTax breaks total, inclusive of GST at rate percent, down for a buyer
+// in state. It is how invoices and credit notes tax their lines, working back
+// from the GST-inclusive amount each line was billed:
+//
+//   - the taxable value is total / (1 + rate/100), rounded half away from zero
+//     to the paisa;
+//   - the tax is whatever is left of total, so it is never rounded on its own;
+//   - within the seller's state CGST is the tax halved and rounded half away
+//     from zero, SGST is what is left, so the two add up to the line's tax.
+//
+// The parts always add up to total, so a line never moves by a paisa between
+// the order, its payments, invoice and credit notes. Document totals are the
+// sums of their lines' split amounts.
+func SplitTax(total money.Amount, rate float64, state string) TaxSplit {
+	basisPoints := int64(math.Round(rate * 100))
+	taxable := total.Ratio(10000, 10000+basisPoints)
+	return splitFor(taxable, total-taxable, state)
+}
+
+func splitFor(taxable money.Amount, tax money.Amount, state string) TaxSplit {
+	split := TaxSplit{TaxableValue: taxable, Total: taxable + tax}
+	if Intrastate(state) {
+		split.CGST = tax.Ratio(1, 2)
+		split.SGST = tax - split.CGST
+	} else {
+		split.IGST = tax
+	}
+	return split
+}
+
+func addSplit(a TaxSplit, b TaxSplit) TaxSplit {
+	return TaxSplit{
+		TaxableValue: a.TaxableValue + b.TaxableValue,
+		CGST:         a.CGST + b.CGST,
+		SGST:         a.SGST + b.SGST,
+		IGST:         a.IGST + b.IGST,
+		Total:        a.Total + b.Total,
+	}
+}
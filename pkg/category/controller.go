@@ -3,6 +3,7 @@ package category
 import (
 	"errors"
 	"strconv"
+	"strings"
 
 	"github.com/Baalamurgan/coin-selling-backend/api/db"
 	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
@@ -92,6 +93,7 @@ func CreateCategory(c *fiber.Ctx) error {
 	newCategory.Name = req.Name
 	newCategory.Description = req.Description
 	newCategory.Slug = utils.GenerateCategorySlug(req.Description)
+	newCategory.SKUPrefix = strings.ToUpper(req.SKUPrefix)
 	if req.ParentCategoryID == "" {
 		newCategory.ParentCategoryID = nil
 	} else {
@@ -133,6 +135,7 @@ func UpdateCategory(c *fiber.Ctx) error {
 		return views.InvalidParams(c)
 	}
 
+	req.SKUPrefix = strings.ToUpper(req.SKUPrefix)
 	result := db.GetDB().Table("categories").Where("id = ?", id).Updates(req)
 	if result.Error != nil {
 		return views.InternalServerError(c, result.Error)
@@ -2,14 +2,100 @@ package data
 
 import (
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
 	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/inventory"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/item"
 	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
+const (
+	populateCreated = "created"
+	populateUpdated = "updated"
+	populateSkipped = "skipped"
+)
+
+type PopulateCounts struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+}
+
+func (p *PopulateCounts) add(result string) {
+	switch result {
+	case populateCreated:
+		p.Created++
+	case populateUpdated:
+		p.Updated++
+	default:
+		p.Skipped++
+	}
+}
+
+// errDryRun rolls back the transaction of an import that was only a dry run.
+var errDryRun = errors.New("dry run")
+
+// invalidFileError stops an import over something wrong with the file. Its
+// message is safe to show to the caller.
+type invalidFileError struct {
+	message string
+}
+
+func (e *invalidFileError) Error() string {
+	return e.message
+}
+
+// populateCategoryRow is a category as written in a populate file.
+type populateCategoryRow struct {
+	ID               uuid.UUID         `json:"id"`
+	Name             string            `json:"name"`
+	Description      string            `json:"description"`
+	ParentCategoryID *uuid.UUID        `json:"parent_category_id"`
+	Slug             string            `json:"slug"`
+	Items            []populateItemRow `json:"items"`
+}
+
+// populateItemRow holds the item fields a populate file may set. Everything
+// else, such as bundles, serialisation and sold counts, is only managed
+// through the API. Optional fields are pointers so a field the file leaves
+// out is told apart from one set to zero, and is left alone on update.
+type populateItemRow struct {
+	SKU              string          `json:"sku"`
+	Name             string          `json:"name"`
+	Slug             string          `json:"slug"`
+	Description      *string         `json:"description"`
+	Year             *int            `json:"year"`
+	ImageURL         *string         `json:"image_url"`
+	Price            *money.Amount   `json:"price"`
+	GST              *float64        `json:"gst"`
+	HSN              *string         `json:"hsn"`
+	Stock            *int            `json:"stock"`
+	ReorderThreshold *int            `json:"reorder_threshold"`
+	Status           string          `json:"status"`
+	Details          []models.Detail `json:"details"`
+}
+
+type PopulateReport struct {
+	DryRun     bool           `json:"dry_run"`
+	Categories PopulateCounts `json:"categories"`
+	Items      PopulateCounts `json:"items"`
+}
+
+// Populate loads a JSON array of categories, each with its nested Items and
+// their Details. Categories are matched by slug and items by SKU, so the same
+// file can be imported repeatedly. The file is decoded one category at a time
+// and everything runs in a single transaction: any error rolls the whole
+// import back. With dry_run=true the transaction is always rolled back and
+// only the report is returned.
 func Populate(c *fiber.Ctx) error {
 	file, err := c.FormFile("file")
 	if err != nil {
@@ -22,19 +108,279 @@ func Populate(c *fiber.Ctx) error {
 	}
 	defer fileContent.Close()
 
-	data, err := io.ReadAll(fileContent)
-	if err != nil {
-		return views.BadRequestWithMessage(c, "Failed to read file")
-	}
+	dryRun := c.FormValue("dry_run", c.Query("dry_run")) == "true"
+	report := PopulateReport{DryRun: dryRun}
 
-	var categories []models.Category
-	if err := json.Unmarshal(data, &categories); err != nil {
+	decoder := json.NewDecoder(fileContent)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
 		return views.BadRequestWithMessage(c, "Invalid JSON format")
 	}
 
-	if err := db.GetDB().Model(&models.Category{}).Create(&categories).Error; err != nil {
+	// Parent ids in the file may refer to categories of the file itself,
+	// which can already exist under a different id.
+	categoryIDs := map[uuid.UUID]uuid.UUID{}
+
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		for index := 0; decoder.More(); index++ {
+			var category populateCategoryRow
+			if err := decoder.Decode(&category); err != nil {
+				return &invalidFileError{fmt.Sprintf("Invalid JSON format at category %d", index)}
+			}
+
+			if err := populateCategory(tx, &category, categoryIDs, &report); err != nil {
+				return &invalidFileError{fmt.Sprintf("category %d (%s): %s", index, category.Name, err.Error())}
+			}
+		}
+		if _, err := decoder.Token(); err != nil {
+			return &invalidFileError{"Invalid JSON format"}
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	var invalidFile *invalidFileError
+	if errors.As(err, &invalidFile) {
+		return views.BadRequestWithMessage(c, invalidFile.message)
+	} else if err != nil && !errors.Is(err, errDryRun) {
 		return views.InternalServerError(c, err)
 	}
+	return views.StatusOK(c, report)
+}
+
+func populateCategory(tx *gorm.DB, category *populateCategoryRow, categoryIDs map[uuid.UUID]uuid.UUID, report *PopulateReport) error {
+	if category.Name == "" {
+		return errors.New("name is required")
+	}
+	if category.Slug == "" {
+		if category.Description != "" {
+			category.Slug = utils.GenerateCategorySlug(category.Description)
+		} else {
+			category.Slug = utils.GenerateCategorySlug(category.Name)
+		}
+	}
+	if category.ParentCategoryID != nil {
+		if parentID, ok := categoryIDs[*category.ParentCategoryID]; ok {
+			category.ParentCategoryID = &parentID
+		}
+	}
+
+	var existing models.Category
+	result := populateSkipped
+	if err := tx.Where("slug = ?", category.Slug).First(&existing).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		fileID := category.ID
+		created := models.Category{
+			ID:               category.ID,
+			Name:             category.Name,
+			Description:      category.Description,
+			ParentCategoryID: category.ParentCategoryID,
+			Slug:             category.Slug,
+		}
+		if err := tx.Create(&created).Error; err != nil {
+			return err
+		}
+		existing = created
+		result = populateCreated
+		if fileID != uuid.Nil {
+			categoryIDs[fileID] = created.ID
+		}
+	} else {
+		if category.ID != uuid.Nil {
+			categoryIDs[category.ID] = existing.ID
+		}
+		updates := map[string]interface{}{}
+		if category.Name != existing.Name {
+			updates["name"] = category.Name
+		}
+		if category.Description != "" && category.Description != existing.Description {
+			updates["description"] = category.Description
+		}
+		if category.ParentCategoryID != nil && (existing.ParentCategoryID == nil || *existing.ParentCategoryID != *category.ParentCategoryID) {
+			updates["parent_category_id"] = *category.ParentCategoryID
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(&existing).Updates(updates).Error; err != nil {
+				return err
+			}
+			result = populateUpdated
+		}
+	}
+	report.Categories.add(result)
+
+	for _, it := range category.Items {
+		itemResult, err := populateItem(tx, existing.ID, it)
+		if err != nil {
+			return fmt.Errorf("item %s: %w", it.SKU, err)
+		}
+		report.Items.add(itemResult)
+	}
+	return nil
+}
+
+func populateItem(tx *gorm.DB, category_id uuid.UUID, row populateItemRow) (string, error) {
+	if row.SKU == "" {
+		return "", errors.New("sku is required")
+	}
+	if row.Name == "" {
+		return "", errors.New("name is required")
+	}
+	if row.Slug == "" {
+		row.Slug = utils.GenerateItemSlug(row.Name)
+	}
+	switch row.Status {
+	case "":
+		row.Status = models.ItemStatusDraft
+	case models.ItemStatusDraft, models.ItemStatusPublished, models.ItemStatusArchived:
+	default:
+		return "", fmt.Errorf("invalid status: %s", row.Status)
+	}
+	if row.Price != nil && *row.Price < 0 {
+		return "", fmt.Errorf("invalid price: %s", *row.Price)
+	}
+	if row.Stock != nil && *row.Stock < 0 {
+		return "", fmt.Errorf("invalid stock: %d", *row.Stock)
+	}
+
+	var existing models.Item
+	if err := tx.Where("sku = ?", row.SKU).First(&existing).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", err
+		}
+		created := models.Item{
+			CategoryID: category_id,
+			SKU:        row.SKU,
+			Name:       row.Name,
+			Slug:       row.Slug,
+			Status:     row.Status,
+		}
+		if row.Status == models.ItemStatusPublished {
+			created.PublishedAt = int(time.Now().Unix())
+		}
+		if row.Description != nil {
+			created.Description = *row.Description
+		}
+		if row.Year != nil {
+			created.Year = *row.Year
+		}
+		if row.ImageURL != nil {
+			created.ImageURL = *row.ImageURL
+		}
+		if row.Price != nil {
+			created.Price = *row.Price
+		}
+		if row.GST != nil {
+			created.GST = *row.GST
+		}
+		if row.HSN != nil {
+			created.HSN = *row.HSN
+		}
+		if row.Stock != nil {
+			created.Stock = *row.Stock
+		}
+		if row.ReorderThreshold != nil {
+			created.ReorderThreshold = *row.ReorderThreshold
+		}
+		if err := tx.Create(&created).Error; err != nil {
+			return "", err
+		}
+		if err := tx.Create(&models.ItemPriceHistory{
+			ItemID:      created.ID,
+			Price:       created.Price,
+			GST:         created.GST,
+			Source:      "import",
+			EffectiveAt: int(time.Now().Unix()),
+		}).Error; err != nil {
+			return "", err
+		}
+		if err := inventory.RecordOpeningStock(tx, &created, "populate"); err != nil {
+			return "", err
+		}
+		if _, err := upsertDetails(tx, created.ID, row.Details); err != nil {
+			return "", err
+		}
+		return populateCreated, nil
+	}
+
+	updates := map[string]interface{}{}
+	if existing.CategoryID != category_id {
+		updates["category_id"] = category_id
+	}
+	if row.Name != existing.Name {
+		updates["name"] = row.Name
+		updates["slug"] = row.Slug
+	}
+	if row.Description != nil && *row.Description != existing.Description {
+		updates["description"] = *row.Description
+	}
+	if row.Year != nil && *row.Year != existing.Year {
+		updates["year"] = *row.Year
+	}
+	if row.ImageURL != nil && *row.ImageURL != "" && *row.ImageURL != existing.ImageURL {
+		updates["image_url"] = *row.ImageURL
+	}
+	if row.HSN != nil && *row.HSN != existing.HSN {
+		updates["hsn"] = *row.HSN
+	}
+	if row.ReorderThreshold != nil && *row.ReorderThreshold != existing.ReorderThreshold {
+		updates["reorder_threshold"] = *row.ReorderThreshold
+	}
+
+	price, gst := existing.Price, existing.GST
+	if row.Price != nil {
+		price = *row.Price
+	}
+	if row.GST != nil {
+		gst = *row.GST
+	}
+	if price != existing.Price && existing.IsBundle {
+		return "", errors.New("the price of a bundle follows its components")
+	}
+	if price != existing.Price || gst != existing.GST {
+		if err := item.RecordPriceChange(tx, &existing, price, gst, "import", nil); err != nil {
+			return "", err
+		}
+		updates["price"] = price
+		updates["gst"] = gst
+	}
+	if len(updates) > 0 {
+		if err := tx.Model(&existing).Updates(updates).Error; err != nil {
+			return "", err
+		}
+	}
+	if _, ok := updates["price"]; ok {
+		if err := item.SyncBundlePrices(tx, existing.ID); err != nil {
+			return "", err
+		}
+	}
+
+	stockChanged := row.Stock != nil && *row.Stock != existing.Stock
+	if stockChanged && existing.IsSerialised {
+		return "", errors.New("stock of a serialised item follows its inventory units")
+	}
+	if stockChanged && existing.IsBundle {
+		return "", inventory.ErrBundleStock
+	}
+	if stockChanged {
+		if err := inventory.Move(tx, &models.StockMovement{
+			ItemID:   existing.ID,
+			Quantity: *row.Stock - existing.Stock,
+			Reason:   models.StockReasonCorrection,
+			Note:     "populate",
+		}); err != nil {
+			return "", err
+		}
+	}
 
-	return views.StatusOK(c, "Data inserted successfully")
+	detailsChanged, err := upsertDetails(tx, existing.ID, row.Details)
+	if err != nil {
+		return "", err
+	}
+	if len(updates) > 0 || stockChanged || detailsChanged {
+		return populateUpdated, nil
+	}
+	return populateSkipped, nil
 }
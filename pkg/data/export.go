@@ -0,0 +1,342 @@
+package data
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/config"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/item"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const exportBatchSize = 500
+
+var errInvalidExportFilter = errors.New("invalid export filter")
+
+var exportCSVColumns = []string{"sku", "name", "description", "year", "category", "status", "price", "gst", "stock", "sold", "image_url", "slug"}
+
+type exportedItem struct {
+	ID           uuid.UUID         `json:"id"`
+	SKU          string            `json:"sku"`
+	Name         string            `json:"name"`
+	Description  string            `json:"description"`
+	Year         int               `json:"year"`
+	CategoryID   uuid.UUID         `json:"category_id"`
+	CategoryPath string            `json:"category_path"`
+	Status       string            `json:"status"`
+	Price        money.Amount      `json:"price"`
+	SalePrice    *money.Amount     `json:"sale_price,omitempty"`
+	GST          float64           `json:"gst"`
+	Stock        int               `json:"stock"`
+	Sold         int               `json:"sold"`
+	ImageURL     string            `json:"image_url"`
+	Images       []string          `json:"images"`
+	Slug         string            `json:"slug"`
+	Details      map[string]string `json:"details"`
+	UpdatedAt    int               `json:"updated_at"`
+}
+
+// ExportItems streams the catalog as CSV (format=csv, the default) or JSON
+// Lines (format=jsonl). The CSV layout matches what ImportItems reads, with
+// one extra column per Detail attribute.
+func ExportItems(c *fiber.Ctx) error {
+	format := c.Query("format", "csv")
+	if format != "csv" && format != "jsonl" {
+		return views.BadRequestWithMessage(c, "format must be csv or jsonl")
+	}
+
+	paths, dbQuery, err := exportQuery(c, false)
+	if errors.Is(err, errInvalidExportFilter) {
+		return views.BadRequest(c)
+	} else if err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	if format == "jsonl" {
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="items.jsonl"`)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			encoder := json.NewEncoder(w)
+			err := streamItems(dbQuery, func(it models.Item) error {
+				return encoder.Encode(toExportedItem(it, paths))
+			}, w)
+			if err != nil {
+				log.Println("Item export failed:", err)
+			}
+		})
+		return nil
+	}
+
+	var attributes []string
+	if err := db.GetDB().Model(&models.Detail{}).
+		Where("item_id IN (?)", dbQuery.Session(&gorm.Session{}).Select("id")).
+		Distinct("attribute").Order("attribute").Pluck("attribute", &attributes).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="items.csv"`)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		writer := csv.NewWriter(w)
+		header := append(append([]string{}, exportCSVColumns...), attributes...)
+		if err := writer.Write(header); err != nil {
+			log.Println("Item export failed:", err)
+			return
+		}
+		err := streamItems(dbQuery, func(it models.Item) error {
+			exported := toExportedItem(it, paths)
+			record := []string{
+				exported.SKU,
+				exported.Name,
+				exported.Description,
+				strconv.Itoa(exported.Year),
+				exported.CategoryPath,
+				exported.Status,
+				exported.Price.String(),
+				strconv.FormatFloat(exported.GST, 'f', -1, 64),
+				strconv.Itoa(exported.Stock),
+				strconv.Itoa(exported.Sold),
+				exported.ImageURL,
+				exported.Slug,
+			}
+			for _, attribute := range attributes {
+				record = append(record, exported.Details[attribute])
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+			writer.Flush()
+			return writer.Error()
+		}, w)
+		if err != nil {
+			log.Println("Item export failed:", err)
+		}
+	})
+	return nil
+}
+
+type merchantItem struct {
+	XMLName          xml.Name `xml:"item"`
+	ID               string   `xml:"g:id"`
+	Title            string   `xml:"title"`
+	Description      string   `xml:"description"`
+	Link             string   `xml:"link"`
+	ImageLink        string   `xml:"g:image_link,omitempty"`
+	AdditionalImages []string `xml:"g:additional_image_link,omitempty"`
+	Availability     string   `xml:"g:availability"`
+	Price            string   `xml:"g:price"`
+	SalePrice        string   `xml:"g:sale_price,omitempty"`
+	Condition        string   `xml:"g:condition"`
+	ProductType      string   `xml:"g:product_type,omitempty"`
+	IdentifierExists string   `xml:"g:identifier_exists"`
+}
+
+// ExportMerchantFeed streams a Google Merchant Center RSS feed of the
+// published items.
+func ExportMerchantFeed(c *fiber.Ctx) error {
+	paths, dbQuery, err := exportQuery(c, true)
+	if errors.Is(err, errInvalidExportFilter) {
+		return views.BadRequest(c)
+	} else if err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	storefrontURL := strings.TrimRight(config.STOREFRONT_URL, "/")
+
+	c.Set(fiber.HeaderContentType, "application/xml; charset=utf-8")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		w.WriteString(xml.Header)
+		w.WriteString(`<rss version="2.0" xmlns:g="http://base.google.com/ns/1.0"><channel>`)
+		writeXMLElement(w, "title", "Coinnect")
+		writeXMLElement(w, "link", storefrontURL)
+		writeXMLElement(w, "description", "Coinnect product feed")
+
+		encoder := xml.NewEncoder(w)
+		err := streamItems(dbQuery, func(it models.Item) error {
+			exported := toExportedItem(it, paths)
+			entry := merchantItem{
+				ID:               exported.SKU,
+				Title:            exported.Name,
+				Description:      exported.Description,
+				Link:             storefrontURL + "/item/" + exported.Slug,
+				ImageLink:        exported.ImageURL,
+				Availability:     "out_of_stock",
+				Price:            fmt.Sprintf("%s %s", exported.Price, money.INR),
+				Condition:        "new",
+				ProductType:      exported.CategoryPath,
+				IdentifierExists: "no",
+			}
+			if len(exported.Images) > 0 {
+				entry.ImageLink = exported.Images[0]
+				entry.AdditionalImages = exported.Images[1:]
+			}
+			if exported.Stock > 0 {
+				entry.Availability = "in_stock"
+			}
+			if exported.SalePrice != nil {
+				entry.SalePrice = fmt.Sprintf("%s %s", *exported.SalePrice, money.INR)
+			}
+			if entry.Description == "" {
+				entry.Description = exported.Name
+			}
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+			return encoder.Flush()
+		}, w)
+		if err != nil {
+			log.Println("Merchant feed export failed:", err)
+		}
+		w.WriteString("</channel></rss>")
+	})
+	return nil
+}
+
+func writeXMLElement(w *bufio.Writer, name string, value string) {
+	w.WriteString("<" + name + ">")
+	xml.EscapeText(w, []byte(value))
+	w.WriteString("</" + name + ">")
+}
+
+// exportQuery builds the item query shared by the exports from the
+// category_ids (subcategories included) and status filters. Feeds are always
+// limited to published items.
+func exportQuery(c *fiber.Ctx, publishedOnly bool) (map[uuid.UUID]string, *gorm.DB, error) {
+	var categories []models.Category
+	if err := db.GetDB().Find(&categories).Error; err != nil {
+		return nil, nil, err
+	}
+	paths := categoryPaths(categories)
+
+	dbQuery := db.GetDB().Model(&models.Item{})
+
+	if categoryIDs := c.Query("category_ids", ""); categoryIDs != "" {
+		var parsedCategoryIDs []uuid.UUID
+		for _, categoryID := range strings.Split(categoryIDs, ",") {
+			parsedCategoryID, err := utils.ParseUUID(categoryID)
+			if err != nil || parsedCategoryID == nil {
+				return nil, nil, errInvalidExportFilter
+			}
+			parsedCategoryIDs = append(parsedCategoryIDs, descendantCategoryIDs(categories, *parsedCategoryID)...)
+		}
+		dbQuery = dbQuery.Where("category_id IN ?", parsedCategoryIDs)
+	}
+
+	if publishedOnly {
+		dbQuery = dbQuery.Scopes(item.Published)
+	} else if status := c.Query("status", ""); status != "" {
+		dbQuery = dbQuery.Where("status IN ?", strings.Split(status, ","))
+	}
+
+	return paths, dbQuery, nil
+}
+
+// streamItems walks the query in batches, calling write for every item and
+// flushing w after each batch so the response is sent progressively.
+func streamItems(dbQuery *gorm.DB, write func(models.Item) error, w *bufio.Writer) error {
+	var items []models.Item
+	var writeErr error
+	result := dbQuery.Session(&gorm.Session{}).
+		Preload("Details").
+		Preload("Images", func(db *gorm.DB) *gorm.DB { return db.Order("position ASC") }).
+		Order("sku ASC").
+		FindInBatches(&items, exportBatchSize, func(tx *gorm.DB, batch int) error {
+			if err := item.WithSalePrices(items); err != nil {
+				return err
+			}
+			for _, it := range items {
+				if writeErr = write(it); writeErr != nil {
+					return writeErr
+				}
+			}
+			return w.Flush()
+		})
+	if writeErr != nil {
+		return writeErr
+	}
+	return result.Error
+}
+
+func toExportedItem(it models.Item, paths map[uuid.UUID]string) exportedItem {
+	exported := exportedItem{
+		ID:           it.ID,
+		SKU:          it.SKU,
+		Name:         it.Name,
+		Description:  it.Description,
+		Year:         it.Year,
+		CategoryID:   it.CategoryID,
+		CategoryPath: paths[it.CategoryID],
+		Status:       it.Status,
+		Price:        it.Price,
+		SalePrice:    it.SalePrice,
+		GST:          it.GST,
+		Stock:        it.Stock,
+		Sold:         it.Sold,
+		ImageURL:     it.ImageURL,
+		Images:       []string{},
+		Slug:         it.Slug,
+		Details:      map[string]string{},
+		UpdatedAt:    it.UpdatedAt,
+	}
+	for _, detail := range it.Details {
+		exported.Details[detail.Attribute] = detail.Value
+	}
+	for _, image := range it.Images {
+		exported.Images = append(exported.Images, image.OriginalURL)
+	}
+	return exported
+}
+
+// categoryPaths maps every category to its "Root > Child > Leaf" name path.
+func categoryPaths(categories []models.Category) map[uuid.UUID]string {
+	byID := map[uuid.UUID]models.Category{}
+	for _, category := range categories {
+		byID[category.ID] = category
+	}
+
+	paths := map[uuid.UUID]string{}
+	for _, category := range categories {
+		names := []string{category.Name}
+		seen := map[uuid.UUID]bool{category.ID: true}
+		parentID := category.ParentCategoryID
+		for parentID != nil && !seen[*parentID] {
+			parent, ok := byID[*parentID]
+			if !ok {
+				break
+			}
+			seen[parent.ID] = true
+			names = append([]string{parent.Name}, names...)
+			parentID = parent.ParentCategoryID
+		}
+		paths[category.ID] = strings.Join(names, " > ")
+	}
+	return paths
+}
+
+func descendantCategoryIDs(categories []models.Category, root uuid.UUID) []uuid.UUID {
+	ids := []uuid.UUID{root}
+	seen := map[uuid.UUID]bool{root: true}
+	for i := 0; i < len(ids); i++ {
+		for _, category := range categories {
+			if category.ParentCategoryID != nil && *category.ParentCategoryID == ids[i] && !seen[category.ID] {
+				seen[category.ID] = true
+				ids = append(ids, category.ID)
+			}
+		}
+	}
+	return ids
+}
@@ -0,0 +1,442 @@
+package data
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/inventory"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/item"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// Spreadsheet columns mapped onto item fields. Every other column is imported
+// as a Detail attribute named after its header.
+var itemImportFields = map[string]bool{
+	"sku":         true,
+	"name":        true,
+	"description": true,
+	"year":        true,
+	"price":       true,
+	"gst":         true,
+	"stock":       true,
+	"sold":        true,
+	"image_url":   true,
+	"status":      true,
+	"category":    true,
+}
+
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	SKU     string `json:"sku"`
+	Message string `json:"message"`
+}
+
+type ImportReport struct {
+	DryRun    bool             `json:"dry_run"`
+	TotalRows int              `json:"total_rows"`
+	Created   int              `json:"created"`
+	Updated   int              `json:"updated"`
+	Failed    int              `json:"failed"`
+	Errors    []ImportRowError `json:"errors"`
+}
+
+type importRow struct {
+	line    int
+	fields  map[string]string
+	details []models.Detail
+}
+
+// ImportItems upserts items by SKU from an uploaded CSV or XLSX file. The
+// first row holds the headers; an optional "mapping" form value (JSON object
+// of header -> field) renames headers before they are matched. Categories are
+// resolved by slug or by a "Parent > Child" name path. With dry_run=true the
+// whole import is rolled back and only the report is returned.
+func ImportItems(c *fiber.Ctx) error {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return views.BadRequestWithMessage(c, "Failed to get file")
+	}
+
+	mapping := map[string]string{}
+	if rawMapping := c.FormValue("mapping"); rawMapping != "" {
+		if err := json.Unmarshal([]byte(rawMapping), &mapping); err != nil {
+			return views.BadRequestWithMessage(c, "Invalid mapping")
+		}
+	}
+	dryRun := c.FormValue("dry_run", c.Query("dry_run")) == "true"
+
+	fileContent, err := file.Open()
+	if err != nil {
+		return views.BadRequestWithMessage(c, "Failed to open file")
+	}
+	defer fileContent.Close()
+
+	var records [][]string
+	switch strings.ToLower(filepath.Ext(file.Filename)) {
+	case ".csv":
+		reader := csv.NewReader(fileContent)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		records, err = reader.ReadAll()
+	case ".xlsx":
+		records, err = readXLSX(fileContent)
+	default:
+		return views.BadRequestWithMessage(c, "Unsupported file type, expected .csv or .xlsx")
+	}
+	if err != nil {
+		return views.BadRequestWithMessage(c, "Failed to read file")
+	}
+	if len(records) < 2 {
+		return views.BadRequestWithMessage(c, "file has no rows")
+	}
+
+	rows := parseImportRows(records, mapping)
+	report := ImportReport{DryRun: dryRun, TotalRows: len(rows), Errors: []ImportRowError{}}
+
+	resolver, err := newCategoryResolver()
+	if err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		seenSKUs := map[string]int{}
+		for _, row := range rows {
+			sku := row.fields["sku"]
+			if previous, ok := seenSKUs[sku]; ok && sku != "" {
+				report.Failed++
+				report.Errors = append(report.Errors, ImportRowError{Row: row.line, SKU: sku, Message: fmt.Sprintf("duplicate sku, already imported on row %d", previous)})
+				continue
+			}
+			seenSKUs[sku] = row.line
+
+			// each row runs under its own savepoint, so a failed row is
+			// undone without losing the others
+			var created bool
+			if err := tx.Transaction(func(tx *gorm.DB) error {
+				var err error
+				created, err = importItemRow(tx, resolver, row)
+				return err
+			}); err != nil {
+				report.Failed++
+				report.Errors = append(report.Errors, ImportRowError{Row: row.line, SKU: sku, Message: err.Error()})
+				continue
+			}
+			if created {
+				report.Created++
+			} else {
+				report.Updated++
+			}
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, report)
+}
+
+func readXLSX(r io.Reader) ([][]string, error) {
+	workbook, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer workbook.Close()
+
+	sheets := workbook.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, errors.New("workbook has no sheets")
+	}
+	return workbook.GetRows(sheets[0])
+}
+
+func normaliseHeader(header string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(header)), " ", "_")
+}
+
+func parseImportRows(records [][]string, mapping map[string]string) []importRow {
+	headers := make([]string, len(records[0]))
+	for i, header := range records[0] {
+		header = strings.TrimSpace(header)
+		if mapped, ok := mapping[header]; ok {
+			header = mapped
+		}
+		headers[i] = header
+	}
+
+	var rows []importRow
+	for i, record := range records[1:] {
+		row := importRow{line: i + 2, fields: map[string]string{}}
+		empty := true
+		for j, value := range record {
+			if j >= len(headers) || headers[j] == "" {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			if value != "" {
+				empty = false
+			}
+			if field := normaliseHeader(headers[j]); itemImportFields[field] {
+				row.fields[field] = value
+			} else if value != "" {
+				row.details = append(row.details, models.Detail{Attribute: headers[j], Value: value})
+			}
+		}
+		if !empty {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// importItemRow creates or updates the item identified by the row's SKU.
+// Empty cells leave the existing value untouched.
+func importItemRow(tx *gorm.DB, resolver *categoryResolver, row importRow) (bool, error) {
+	sku := row.fields["sku"]
+	if sku == "" {
+		return false, errors.New("sku is required")
+	}
+
+	var existing models.Item
+	created := false
+	if err := tx.Where("sku = ?", sku).First(&existing).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, err
+		}
+		created = true
+	}
+
+	updated := existing
+	updated.SKU = sku
+	if created {
+		updated.Status = models.ItemStatusDraft
+	}
+
+	if value := row.fields["category"]; value != "" {
+		category_id, err := resolver.resolve(value)
+		if err != nil {
+			return false, err
+		}
+		updated.CategoryID = category_id
+	} else if created {
+		return false, errors.New("category is required for new items")
+	}
+
+	if value := row.fields["name"]; value != "" {
+		updated.Name = value
+		updated.Slug = utils.GenerateItemSlug(value)
+	} else if created {
+		return false, errors.New("name is required for new items")
+	}
+	if value, ok := row.fields["description"]; ok && value != "" {
+		updated.Description = value
+	}
+	if value, ok := row.fields["image_url"]; ok && value != "" {
+		updated.ImageURL = value
+	}
+
+	intFields := []struct {
+		name   string
+		target *int
+	}{
+		{"year", &updated.Year},
+		{"stock", &updated.Stock},
+		{"sold", &updated.Sold},
+	}
+	if row.fields["stock"] != "" && updated.IsSerialised {
+		return false, errors.New("stock of a serialised item follows its inventory units")
+	}
+	for _, field := range intFields {
+		if value := row.fields[field.name]; value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || (field.name != "year" && parsed < 0) {
+				return false, fmt.Errorf("invalid %s: %s", field.name, value)
+			}
+			*field.target = parsed
+		}
+	}
+	// a cell may repeat what an export wrote, but stock of a bundle and sold
+	// counts never change through an import
+	if updated.IsBundle && updated.Stock != existing.Stock {
+		return false, inventory.ErrBundleStock
+	}
+	if updated.Sold != existing.Sold {
+		return false, errors.New("sold only changes through orders")
+	}
+
+	if value := row.fields["price"]; value != "" {
+		price, err := money.Parse(value)
+		if err != nil || price < 0 {
+			return false, fmt.Errorf("invalid price: %s", value)
+		}
+		updated.Price = price
+	} else if created {
+		return false, errors.New("price is required for new items")
+	}
+	if value := row.fields["gst"]; value != "" {
+		gst, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || gst < 0 || gst > 100 {
+			return false, fmt.Errorf("invalid gst: %s", value)
+		}
+		updated.GST = gst
+	}
+
+	if value := row.fields["status"]; value != "" {
+		switch value {
+		case models.ItemStatusDraft, models.ItemStatusPublished, models.ItemStatusArchived:
+			if value == models.ItemStatusPublished && updated.Status != models.ItemStatusPublished {
+				updated.PublishedAt = int(time.Now().Unix())
+			}
+			updated.Status = value
+		default:
+			return false, fmt.Errorf("invalid status: %s", value)
+		}
+	}
+
+	if created {
+		if err := tx.Create(&updated).Error; err != nil {
+			return false, err
+		}
+		if err := tx.Create(&models.ItemPriceHistory{
+			ItemID:      updated.ID,
+			Price:       updated.Price,
+			GST:         updated.GST,
+			Source:      "import",
+			EffectiveAt: int(time.Now().Unix()),
+		}).Error; err != nil {
+			return false, err
+		}
+		if err := inventory.RecordOpeningStock(tx, &updated, "import"); err != nil {
+			return false, err
+		}
+	} else {
+		if err := item.RecordPriceChange(tx, &existing, updated.Price, updated.GST, "import", nil); err != nil {
+			return false, err
+		}
+		if err := tx.Omit("stock", "sold").Save(&updated).Error; err != nil {
+			return false, err
+		}
+		if updated.Price != existing.Price {
+			if err := item.SyncBundlePrices(tx, existing.ID); err != nil {
+				return false, err
+			}
+		}
+		if updated.Stock != existing.Stock && !existing.IsBundle {
+			if err := inventory.Move(tx, &models.StockMovement{
+				ItemID:   existing.ID,
+				Quantity: updated.Stock - existing.Stock,
+				Reason:   models.StockReasonCorrection,
+				Note:     "import",
+			}); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if _, err := upsertDetails(tx, updated.ID, row.details); err != nil {
+		return false, err
+	}
+
+	return created, nil
+}
+
+// upsertDetails sets each attribute's value on the item, adding attributes
+// that do not exist yet. Attributes missing from details are kept. It reports
+// whether anything was written.
+func upsertDetails(tx *gorm.DB, item_id uuid.UUID, details []models.Detail) (bool, error) {
+	changed := false
+	for _, detail := range details {
+		var existing models.Detail
+		err := tx.Where("item_id = ? AND attribute = ?", item_id, detail.Attribute).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := tx.Create(&models.Detail{ItemID: item_id, Attribute: detail.Attribute, Value: detail.Value}).Error; err != nil {
+				return changed, err
+			}
+			changed = true
+			continue
+		} else if err != nil {
+			return changed, err
+		}
+		if existing.Value != detail.Value {
+			if err := tx.Model(&existing).Update("value", detail.Value).Error; err != nil {
+				return changed, err
+			}
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// categoryResolver looks categories up by slug or by name path using a
+// snapshot of the categories table taken when the import starts.
+type categoryResolver struct {
+	bySlug     map[string]uuid.UUID
+	byParentID map[uuid.UUID][]models.Category
+	roots      []models.Category
+}
+
+func newCategoryResolver() (*categoryResolver, error) {
+	var categories []models.Category
+	if err := db.GetDB().Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	resolver := &categoryResolver{bySlug: map[string]uuid.UUID{}, byParentID: map[uuid.UUID][]models.Category{}}
+	for _, category := range categories {
+		if category.Slug != "" {
+			resolver.bySlug[category.Slug] = category.ID
+		}
+		if category.ParentCategoryID == nil {
+			resolver.roots = append(resolver.roots, category)
+		} else {
+			resolver.byParentID[*category.ParentCategoryID] = append(resolver.byParentID[*category.ParentCategoryID], category)
+		}
+	}
+	return resolver, nil
+}
+
+func (r *categoryResolver) resolve(value string) (uuid.UUID, error) {
+	if id, ok := r.bySlug[strings.ToLower(value)]; ok {
+		return id, nil
+	}
+
+	names := strings.FieldsFunc(value, func(r rune) bool { return r == '>' || r == '/' })
+	candidates := r.roots
+	var match *models.Category
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		match = nil
+		for i := range candidates {
+			if strings.EqualFold(candidates[i].Name, name) {
+				match = &candidates[i]
+				break
+			}
+		}
+		if match == nil {
+			return uuid.Nil, fmt.Errorf("category not found: %s", value)
+		}
+		candidates = r.byParentID[match.ID]
+	}
+	if match == nil {
+		return uuid.Nil, fmt.Errorf("category not found: %s", value)
+	}
+	return match.ID, nil
+}
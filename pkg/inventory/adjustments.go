@@ -0,0 +1,200 @@
+package inventory
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func GetItemLedger(c *fiber.Ctx) error {
+	item_id, err := uuid.Parse(c.Params("item_id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page < 1 {
+		return views.BadRequest(c)
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit", "50"))
+	if err != nil || limit < 0 {
+		return views.BadRequest(c)
+	}
+
+	var item models.Item
+	if err := db.GetDB().Where("id = ?", item_id).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	dbQuery := db.GetDB().Model(&models.StockMovement{}).Where("item_id = ?", item_id)
+	if reason := c.Query("reason", ""); reason != "" {
+		dbQuery = dbQuery.Where("reason = ?", reason)
+	}
+	if location_id := c.Query("location_id", ""); location_id != "" {
+		dbQuery = dbQuery.Where("location_id = ?", location_id)
+	}
+
+	var total int64
+	if err := dbQuery.Count(&total).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	var movements []models.StockMovement
+	if err := dbQuery.Order("created_at DESC").Scopes(utils.Paginate(page, limit)).Find(&movements).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	var ledgerStock int
+	if err := db.GetDB().Model(&models.StockMovement{}).Where("item_id = ?", item_id).
+		Select("COALESCE(SUM(quantity), 0)").Scan(&ledgerStock).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	return views.StatusOK(c, fiber.Map{
+		"item_id":      item.ID,
+		"stock":        item.Stock,
+		"ledger_stock": ledgerStock,
+		"in_sync":      ledgerStock == item.Stock,
+		"movements":    movements,
+		"pagination": fiber.Map{
+			"page":          page,
+			"limit":         limit,
+			"total_records": total,
+			"total_pages":   utils.CalculateTotalPages(total, limit),
+		},
+	})
+}
+
+// AdjustStock records a manual stock movement (restock, damage, return or
+// correction) for an item that is not serialised. Once an item is stocked per
+// location the adjustment has to name the location it happens at.
+func AdjustStock(c *fiber.Ctx) error {
+	item_id, err := uuid.Parse(c.Params("item_id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var req schemas.StockAdjustmentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	user_id, err := uuid.Parse(req.UserID)
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	switch req.Reason {
+	case models.StockReasonRestock, models.StockReasonReturn:
+		if req.Quantity < 0 {
+			return views.BadRequestWithMessage(c, req.Reason+" must add stock")
+		}
+	case models.StockReasonDamage:
+		if req.Quantity > 0 {
+			return views.BadRequestWithMessage(c, "damage must remove stock")
+		}
+	}
+
+	var item models.Item
+	if err := db.GetDB().Where("id = ?", item_id).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+	if item.IsSerialised {
+		return views.BadRequestWithMessage(c, "stock of a serialised item follows its inventory units")
+	}
+	if item.IsBundle {
+		return views.BadRequestWithMessage(c, ErrBundleStock.Error())
+	}
+
+	var location_id *uuid.UUID
+	if req.LocationID != "" {
+		parsedLocationID, err := uuid.Parse(req.LocationID)
+		if err != nil {
+			return views.BadRequest(c)
+		}
+		var location models.Location
+		if err := db.GetDB().Where("id = ?", parsedLocationID).First(&location).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return views.RecordNotFound(c)
+			}
+			return views.InternalServerError(c, err)
+		}
+		if !location.IsActive && req.Quantity > 0 {
+			return views.BadRequestWithMessage(c, ErrLocationInactive.Error())
+		}
+		location_id = &parsedLocationID
+	}
+
+	movement := models.StockMovement{
+		ItemID:     item_id,
+		Quantity:   req.Quantity,
+		Reason:     req.Reason,
+		ActorID:    &user_id,
+		Note:       req.Note,
+		LocationID: location_id,
+	}
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if location_id == nil {
+			tracked, err := TracksLocations(tx, item_id)
+			if err != nil {
+				return err
+			}
+			if tracked {
+				return ErrLocationRequired
+			}
+		}
+		return Move(tx, &movement)
+	}); err != nil {
+		if errors.Is(err, ErrInsufficientStock) || errors.Is(err, ErrInsufficientLocationStock) || errors.Is(err, ErrLocationRequired) {
+			return views.BadRequestWithMessage(c, err.Error())
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	return views.ObjectCreated(c, movement)
+}
+
+// VerifyStock lists the items whose stock does not match their ledger.
+// Bundles have no ledger of their own and are left out.
+func VerifyStock(c *fiber.Ctx) error {
+	type stockMismatch struct {
+		ItemID      uuid.UUID `json:"item_id"`
+		SKU         string    `json:"sku"`
+		Stock       int       `json:"stock"`
+		LedgerStock int       `json:"ledger_stock"`
+	}
+
+	var mismatches []stockMismatch
+	if err := db.GetDB().Table("items").
+		Select("items.id AS item_id, items.sku, items.stock, COALESCE(SUM(stock_movements.quantity), 0) AS ledger_stock").
+		Joins("LEFT JOIN stock_movements ON stock_movements.item_id = items.id").
+		Where("items.is_bundle = ?", false).
+		Group("items.id, items.sku, items.stock").
+		Having("items.stock <> COALESCE(SUM(stock_movements.quantity), 0)").
+		Scan(&mismatches).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	return views.StatusOK(c, fiber.Map{
+		"in_sync":    len(mismatches) == 0,
+		"mismatches": mismatches,
+	})
+}
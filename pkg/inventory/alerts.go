@@ -0,0 +1,247 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/notify"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/config"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// checkStockThresholds reacts to an item's stock moving from item.Stock to
+// movement.StockAfter: it raises or resolves the low-stock alert and tells
+// subscribers when an out of stock item is replenished.
+func checkStockThresholds(tx *gorm.DB, item *models.Item, movement *models.StockMovement) error {
+	before, after := item.Stock, movement.StockAfter
+
+	if item.ReorderThreshold > 0 {
+		if before > item.ReorderThreshold && after <= item.ReorderThreshold {
+			if err := raiseStockAlert(tx, item, movement); err != nil {
+				return err
+			}
+		} else if after > item.ReorderThreshold {
+			if err := tx.Model(&models.StockAlert{}).
+				Where("item_id = ? AND status IN ?", item.ID, []string{models.StockAlertStatusOpen, models.StockAlertStatusAcknowledged}).
+				Updates(map[string]interface{}{
+					"status":      models.StockAlertStatusResolved,
+					"resolved_at": time.Now().Unix(),
+				}).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	if before <= 0 && after > 0 {
+		return notifySubscribers(tx, item)
+	}
+	return nil
+}
+
+func raiseStockAlert(tx *gorm.DB, item *models.Item, movement *models.StockMovement) error {
+	var count int64
+	if err := tx.Model(&models.StockAlert{}).
+		Where("item_id = ? AND status IN ?", item.ID, []string{models.StockAlertStatusOpen, models.StockAlertStatusAcknowledged}).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if err := tx.Create(&models.StockAlert{
+		ItemID:    item.ID,
+		Threshold: item.ReorderThreshold,
+		Stock:     movement.StockAfter,
+		Status:    models.StockAlertStatusOpen,
+		OrderID:   movement.OrderID,
+	}).Error; err != nil {
+		return err
+	}
+
+	// without an admin address the alert is only shown on the dashboard
+	if config.ADMIN_ALERT_EMAIL == "" {
+		return nil
+	}
+	return notify.Enqueue(tx, config.ADMIN_ALERT_EMAIL,
+		fmt.Sprintf("Low stock: %s (%s)", item.Name, item.SKU),
+		fmt.Sprintf("Stock of %s (%s) is down to %d, at or below its reorder threshold of %d.",
+			item.Name, item.SKU, movement.StockAfter, item.ReorderThreshold))
+}
+
+func notifySubscribers(tx *gorm.DB, item *models.Item) error {
+	var subscriptions []models.StockSubscription
+	if err := tx.Where("item_id = ? AND status = ?", item.ID, models.SubscriptionStatusActive).Find(&subscriptions).Error; err != nil {
+		return err
+	}
+
+	link := strings.TrimRight(config.STOREFRONT_URL, "/") + "/item/" + item.Slug
+	for _, subscription := range subscriptions {
+		if err := notify.Enqueue(tx, subscription.Email,
+			item.Name+" is back in stock",
+			fmt.Sprintf("%s is available again. Get it before it sells out: %s", item.Name, link)); err != nil {
+			return err
+		}
+		if err := tx.Model(&subscription).Updates(map[string]interface{}{
+			"status":      models.SubscriptionStatusNotified,
+			"notified_at": time.Now().Unix(),
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetStockAlerts(c *fiber.Ctx) error {
+	dbQuery := db.GetDB().Preload("Item")
+	if status := c.Query("status", ""); status != "" {
+		dbQuery = dbQuery.Where("status IN ?", strings.Split(status, ","))
+	}
+
+	var alerts []models.StockAlert
+	if err := dbQuery.Order("created_at DESC").Find(&alerts).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, alerts)
+}
+
+func AcknowledgeStockAlert(c *fiber.Ctx) error {
+	var req schemas.AcknowledgeStockAlertRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	alert_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	user_id, err := uuid.Parse(req.UserID)
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var alert models.StockAlert
+	if err := db.GetDB().Where("id = ?", alert_id).First(&alert).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+	if alert.Status != models.StockAlertStatusOpen {
+		return views.BadRequestWithMessage(c, "alert is not open")
+	}
+
+	if err := db.GetDB().Model(&alert).Updates(map[string]interface{}{
+		"status":          models.StockAlertStatusAcknowledged,
+		"acknowledged_by": user_id,
+		"acknowledged_at": time.Now().Unix(),
+	}).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, alert)
+}
+
+func GetItemSubscriptions(c *fiber.Ctx) error {
+	item_id, err := uuid.Parse(c.Params("item_id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	dbQuery := db.GetDB().Where("item_id = ?", item_id)
+	if status := c.Query("status", ""); status != "" {
+		dbQuery = dbQuery.Where("status = ?", status)
+	}
+
+	var subscriptions []models.StockSubscription
+	if err := dbQuery.Order("created_at ASC").Find(&subscriptions).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, subscriptions)
+}
+
+// SubscribeBackInStock lets a customer ask to be emailed once an out of stock
+// item is available again.
+func SubscribeBackInStock(c *fiber.Ctx) error {
+	item_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var req schemas.StockSubscriptionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	var user_id *uuid.UUID
+	if req.UserID != "" {
+		parsedUserID, err := uuid.Parse(req.UserID)
+		if err != nil {
+			return views.BadRequest(c)
+		}
+		user_id = &parsedUserID
+	}
+
+	var item models.Item
+	if err := db.GetDB().Where("id = ? AND status = ?", item_id, models.ItemStatusPublished).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+	if item.Stock > 0 {
+		return views.BadRequestWithMessage(c, "item is in stock")
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	var subscription models.StockSubscription
+	if err := db.GetDB().Where("item_id = ? AND email = ? AND status = ?", item_id, email, models.SubscriptionStatusActive).
+		First(&subscription).Error; err == nil {
+		return views.StatusOK(c, subscription)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return views.InternalServerError(c, err)
+	}
+
+	subscription = models.StockSubscription{
+		ItemID: item_id,
+		UserID: user_id,
+		Email:  email,
+		Status: models.SubscriptionStatusActive,
+	}
+	if err := db.GetDB().Create(&subscription).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.ObjectCreated(c, subscription)
+}
+
+func UnsubscribeBackInStock(c *fiber.Ctx) error {
+	subscription_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	result := db.GetDB().Model(&models.StockSubscription{}).
+		Where("id = ? AND status = ?", subscription_id, models.SubscriptionStatusActive).
+		Update("status", models.SubscriptionStatusCancelled)
+	if result.Error != nil {
+		return views.InternalServerError(c, result.Error)
+	} else if result.RowsAffected == 0 {
+		return views.RecordNotFound(c)
+	}
+	return views.StatusOK(c, "subscription cancelled")
+}
@@ -0,0 +1,126 @@
+package inventory
+
+import (
+	"errors"
+
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrBundleStock = errors.New("stock of a bundle follows its components")
+
+func bundleComponents(tx *gorm.DB, bundle_id uuid.UUID) ([]models.BundleComponent, error) {
+	var components []models.BundleComponent
+	err := tx.Where("bundle_id = ?", bundle_id).Order("created_at ASC").Find(&components).Error
+	return components, err
+}
+
+// moveBundle applies a movement of a bundle to its components, multiplied by
+// the quantity of each component in the bundle. The bundle keeps no ledger of
+// its own; its stock is re-derived from the components afterwards.
+func moveBundle(tx *gorm.DB, movement *models.StockMovement) error {
+	var bundle models.Item
+	if err := tx.Where("id = ?", movement.ItemID).First(&bundle).Error; err != nil {
+		return err
+	}
+
+	components, err := bundleComponents(tx, bundle.ID)
+	if err != nil {
+		return err
+	}
+	if len(components) == 0 && movement.Quantity < 0 {
+		return ErrInsufficientStock
+	}
+
+	for _, component := range components {
+		if err := Move(tx, &models.StockMovement{
+			ItemID:      component.ComponentID,
+			Quantity:    movement.Quantity * component.Quantity,
+			Reason:      movement.Reason,
+			ActorID:     movement.ActorID,
+			OrderID:     movement.OrderID,
+			OrderItemID: movement.OrderItemID,
+			Note:        "bundle " + bundle.SKU,
+		}); err != nil {
+			return err
+		}
+	}
+
+	// the components' sold counters moved with their own movements
+	if movement.Reason == models.StockReasonSale || movement.Reason == models.StockReasonReturn {
+		if err := tx.Model(&models.Item{}).Where("id = ?", bundle.ID).
+			Update("sold", gorm.Expr("sold - ?", movement.Quantity)).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := SyncBundleStock(tx, bundle.ID, movement.OrderID); err != nil {
+		return err
+	}
+	return tx.Model(&models.Item{}).Where("id = ?", bundle.ID).Pluck("stock", &movement.StockAfter).Error
+}
+
+// SyncBundleStock sets the stock of a bundle to the number of complete
+// bundles its components' stock can make up.
+func SyncBundleStock(tx *gorm.DB, bundle_id uuid.UUID, order_id *uuid.UUID) error {
+	var bundle models.Item
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", bundle_id).First(&bundle).Error; err != nil {
+		return err
+	}
+
+	var stock int
+	if err := tx.Table("bundle_components").
+		Select("COALESCE(MIN(items.stock / bundle_components.quantity), 0)").
+		Joins("JOIN items ON items.id = bundle_components.component_id").
+		Where("bundle_components.bundle_id = ?", bundle_id).
+		Scan(&stock).Error; err != nil {
+		return err
+	}
+	if stock == bundle.Stock {
+		return nil
+	}
+
+	if err := tx.Model(&models.Item{}).Where("id = ?", bundle_id).Update("stock", stock).Error; err != nil {
+		return err
+	}
+	return checkStockThresholds(tx, &bundle, &models.StockMovement{StockAfter: stock, OrderID: order_id})
+}
+
+// syncBundlesContaining re-derives the stock of every bundle the item is a
+// component of.
+func syncBundlesContaining(tx *gorm.DB, component_id uuid.UUID, order_id *uuid.UUID) error {
+	var bundleIDs []uuid.UUID
+	if err := tx.Model(&models.BundleComponent{}).Where("component_id = ?", component_id).
+		Pluck("bundle_id", &bundleIDs).Error; err != nil {
+		return err
+	}
+	for _, bundle_id := range bundleIDs {
+		if err := SyncBundleStock(tx, bundle_id, order_id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSold moves the sold counter of an item by quantity, and for a bundle the
+// sold counters of its components by their share of it.
+func addSold(tx *gorm.DB, item_id uuid.UUID, quantity int) error {
+	if err := tx.Model(&models.Item{}).Where("id = ?", item_id).
+		Update("sold", gorm.Expr("sold + ?", quantity)).Error; err != nil {
+		return err
+	}
+
+	components, err := bundleComponents(tx, item_id)
+	if err != nil {
+		return err
+	}
+	for _, component := range components {
+		if err := tx.Model(&models.Item{}).Where("id = ?", component.ComponentID).
+			Update("sold", gorm.Expr("sold + ?", quantity*component.Quantity)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package inventory
+
+import (
+	"errors"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func GetItemUnits(c *fiber.Ctx) error {
+	item_id, err := uuid.Parse(c.Params("item_id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	dbQuery := db.GetDB().Where("item_id = ?", item_id)
+	if status := c.Query("status", ""); status != "" {
+		dbQuery = dbQuery.Where("status = ?", status)
+	}
+
+	var units []models.InventoryUnit
+	if err := dbQuery.Order("created_at ASC").Find(&units).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, units)
+}
+
+func GetUnitByID(c *fiber.Ctx) error {
+	unit_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var unit models.InventoryUnit
+	if err := db.GetDB().Where("id = ?", unit_id).First(&unit).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, unit)
+}
+
+func CreateUnit(c *fiber.Ctx) error {
+	item_id, err := uuid.Parse(c.Params("item_id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var req schemas.CreateInventoryUnitRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	var item models.Item
+	if err := db.GetDB().Where("id = ?", item_id).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+	if !item.IsSerialised {
+		return views.BadRequestWithMessage(c, "item is not serialised")
+	}
+
+	if err := db.GetDB().Where("serial_number = ?", req.SerialNumber).First(&models.InventoryUnit{}).Error; err == nil {
+		return views.ConflictWithMessage(c, "serial number already exists")
+	}
+
+	unit := models.InventoryUnit{
+		ItemID:            item_id,
+		SerialNumber:      req.SerialNumber,
+		CertificateNumber: req.CertificateNumber,
+		Grade:             req.Grade,
+		AcquisitionCost:   req.AcquisitionCost,
+		AcquiredAt:        req.AcquiredAt,
+		Location:          req.Location,
+		Notes:             req.Notes,
+		Status:            models.UnitStatusAvailable,
+	}
+
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&unit).Error; err != nil {
+			return err
+		}
+		return Move(tx, &models.StockMovement{
+			ItemID:   item_id,
+			Quantity: 1,
+			Reason:   models.StockReasonRestock,
+			Note:     "unit " + unit.SerialNumber,
+		})
+	}); err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	return views.ObjectCreated(c, unit)
+}
+
+func UpdateUnit(c *fiber.Ctx) error {
+	var req schemas.UpdateInventoryUnitRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	unit_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var unit models.InventoryUnit
+	if err := db.GetDB().Where("id = ?", unit_id).First(&unit).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	updates := map[string]interface{}{}
+	if req.SerialNumber != nil {
+		if *req.SerialNumber == "" {
+			return views.BadRequestWithMessage(c, "serial number cannot be empty")
+		}
+		updates["serial_number"] = *req.SerialNumber
+	}
+	if req.CertificateNumber != nil {
+		updates["certificate_number"] = *req.CertificateNumber
+	}
+	if req.Grade != nil {
+		updates["grade"] = *req.Grade
+	}
+	if req.AcquisitionCost != nil {
+		if *req.AcquisitionCost < 0 {
+			return views.BadRequestWithMessage(c, "acquisition cost cannot be negative")
+		}
+		updates["acquisition_cost"] = *req.AcquisitionCost
+	}
+	if req.AcquiredAt != nil {
+		updates["acquired_at"] = *req.AcquiredAt
+	}
+	if req.Location != nil {
+		updates["location"] = *req.Location
+	}
+	if req.Notes != nil {
+		updates["notes"] = *req.Notes
+	}
+
+	if len(updates) > 0 {
+		if err := db.GetDB().Model(&unit).Updates(updates).Error; err != nil {
+			return views.InternalServerError(c, err)
+		}
+	}
+
+	return views.StatusOK(c, unit)
+}
+
+func DeleteUnit(c *fiber.Ctx) error {
+	unit_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var unit models.InventoryUnit
+	if err := db.GetDB().Where("id = ?", unit_id).First(&unit).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	if unit.Status != models.UnitStatusAvailable {
+		return views.BadRequestWithMessage(c, "only available units can be deleted")
+	}
+
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&unit).Error; err != nil {
+			return err
+		}
+		return Move(tx, &models.StockMovement{
+			ItemID:   unit.ItemID,
+			Quantity: -1,
+			Reason:   models.StockReasonCorrection,
+			Note:     "unit " + unit.SerialNumber + " deleted",
+		})
+	}); err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	return views.StatusOK(c, "unit deleted")
+}
@@ -0,0 +1,140 @@
+package inventory
+
+import (
+	"errors"
+
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInsufficientStock = errors.New("not enough stock available")
+
+// Move records a stock movement and applies it to the item's stock and sold
+// counters. Sales and returns also move the sold counter. For serialised
+// items the units must already have been changed by the caller; their stock
+// is re-derived from the units instead of being adjusted. A movement with a
+// location also changes the stock level at that location. Crossing the
+// item's reorder threshold or coming back into stock notifies admins and
+// subscribers. Movements of a bundle are applied to its components.
+func Move(tx *gorm.DB, movement *models.StockMovement) error {
+	var isBundle bool
+	if err := tx.Model(&models.Item{}).Where("id = ?", movement.ItemID).Pluck("is_bundle", &isBundle).Error; err != nil {
+		return err
+	}
+	if isBundle {
+		// components are locked before the bundle, like any other movement
+		return moveBundle(tx, movement)
+	}
+
+	var item models.Item
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", movement.ItemID).First(&item).Error; err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{}
+	switch movement.Reason {
+	case models.StockReasonSale:
+		updates["sold"] = gorm.Expr("sold + ?", -movement.Quantity)
+	case models.StockReasonReturn:
+		updates["sold"] = gorm.Expr("sold - ?", movement.Quantity)
+	}
+
+	if item.IsSerialised {
+		if err := SyncSerialisedStock(tx, item.ID); err != nil {
+			return err
+		}
+	} else {
+		if item.Stock+movement.Quantity < 0 {
+			return ErrInsufficientStock
+		}
+		updates["stock"] = gorm.Expr("stock + ?", movement.Quantity)
+	}
+
+	if movement.LocationID != nil {
+		if err := AdjustLevel(tx, item.ID, *movement.LocationID, movement.Quantity); err != nil {
+			return err
+		}
+	}
+
+	if len(updates) > 0 {
+		if err := tx.Model(&models.Item{}).Where("id = ?", item.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Model(&models.Item{}).Where("id = ?", item.ID).Pluck("stock", &movement.StockAfter).Error; err != nil {
+		return err
+	}
+	if err := tx.Create(movement).Error; err != nil {
+		return err
+	}
+	if err := checkStockThresholds(tx, &item, movement); err != nil {
+		return err
+	}
+	return syncBundlesContaining(tx, item.ID, movement.OrderID)
+}
+
+// RecordSale takes quantity units of the item out of stock for an order item.
+// A negative quantity puts them back as a return.
+func RecordSale(tx *gorm.DB, item_id uuid.UUID, quantity int, order_id uuid.UUID, order_item_id uuid.UUID) error {
+	if quantity == 0 {
+		return nil
+	}
+	reason := models.StockReasonSale
+	if quantity < 0 {
+		reason = models.StockReasonReturn
+	}
+	return Move(tx, &models.StockMovement{
+		ItemID:      item_id,
+		Quantity:    -quantity,
+		Reason:      reason,
+		OrderID:     &order_id,
+		OrderItemID: &order_item_id,
+	})
+}
+
+// RecordOpeningStock starts the ledger of a new item with its initial stock.
+func RecordOpeningStock(tx *gorm.DB, item *models.Item, note string) error {
+	return tx.Create(&models.StockMovement{
+		ItemID:     item.ID,
+		Quantity:   item.Stock,
+		StockAfter: item.Stock,
+		Reason:     models.StockReasonOpening,
+		Note:       note,
+	}).Error
+}
+
+// BackfillOpeningStock gives every item without ledger entries an opening
+// movement for its current stock, so ledgers start from the stock the item
+// had before the ledger existed.
+func BackfillOpeningStock(tx *gorm.DB) error {
+	return tx.Exec(`INSERT INTO stock_movements (item_id, quantity, stock_after, reason, note, created_at)
+		SELECT items.id, items.stock, items.stock, ?, 'backfilled from item stock', EXTRACT(EPOCH FROM NOW())::bigint
+		FROM items
+		WHERE NOT items.is_bundle
+		AND NOT EXISTS (SELECT 1 FROM stock_movements WHERE stock_movements.item_id = items.id)`,
+		models.StockReasonOpening).Error
+}
+
+// LockItem loads the item for update so concurrent changes to its stock
+// queue up. The components of a bundle are locked before the bundle, in the
+// same order Move takes them.
+func LockItem(tx *gorm.DB, item_id uuid.UUID) (*models.Item, error) {
+	components, err := bundleComponents(tx, item_id)
+	if err != nil {
+		return nil, err
+	}
+	for _, component := range components {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", component.ComponentID).First(&models.Item{}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var item models.Item
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", item_id).First(&item).Error; err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
@@ -0,0 +1,242 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInsufficientLocationStock = errors.New("not enough stock at this location")
+	ErrLocationRequired          = errors.New("item is stocked per location, a location is required")
+	ErrLocationInactive          = errors.New("location is not active")
+)
+
+// TracksLocations reports whether the item's stock is split across
+// locations. Items only start being tracked once they get a stock level.
+func TracksLocations(tx *gorm.DB, item_id uuid.UUID) (bool, error) {
+	var count int64
+	if err := tx.Model(&models.StockLevel{}).Where("item_id = ?", item_id).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// AdjustLevel changes the quantity on hand of an item at a location, creating
+// the stock level on first use. It does not touch the item's aggregate stock.
+func AdjustLevel(tx *gorm.DB, item_id uuid.UUID, location_id uuid.UUID, quantity int) error {
+	if err := tx.Where("id = ?", location_id).First(&models.Location{}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.StockLevel{
+		ItemID:     item_id,
+		LocationID: location_id,
+	}).Error; err != nil {
+		return err
+	}
+
+	var level models.StockLevel
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("item_id = ? AND location_id = ?", item_id, location_id).First(&level).Error; err != nil {
+		return err
+	}
+	if level.Quantity+quantity < 0 {
+		return ErrInsufficientLocationStock
+	}
+	return tx.Model(&level).Update("quantity", gorm.Expr("quantity + ?", quantity)).Error
+}
+
+// FulfilOrder takes the goods of an order out of the location it ships from.
+// Items that are not stocked per location are left alone.
+func FulfilOrder(tx *gorm.DB, order_id uuid.UUID, location_id *uuid.UUID) error {
+	return moveOrderGoods(tx, order_id, location_id, -1)
+}
+
+// ReturnOrderGoods puts the goods of a shipped order back into the location
+// it shipped from, when the order is cancelled.
+func ReturnOrderGoods(tx *gorm.DB, order_id uuid.UUID, location_id *uuid.UUID) error {
+	return moveOrderGoods(tx, order_id, location_id, 1)
+}
+
+func moveOrderGoods(tx *gorm.DB, order_id uuid.UUID, location_id *uuid.UUID, sign int) error {
+	var orderItems []models.OrderItem
+	if err := tx.Where("order_id = ? AND order_item_status NOT IN ?", order_id, []string{"cancelled", "expired"}).Find(&orderItems).Error; err != nil {
+		return err
+	}
+
+	for _, orderItem := range orderItems {
+		// bundles ship as their components
+		components, err := bundleComponents(tx, orderItem.ItemID)
+		if err != nil {
+			return err
+		}
+		if len(components) == 0 {
+			components = []models.BundleComponent{{ComponentID: orderItem.ItemID, Quantity: 1}}
+		}
+
+		for _, component := range components {
+			if err := fulfilItem(tx, component.ComponentID, location_id, sign*orderItem.Quantity*component.Quantity); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fulfilItem moves quantity of the item into the location, out of it when
+// negative.
+func fulfilItem(tx *gorm.DB, item_id uuid.UUID, location_id *uuid.UUID, quantity int) error {
+	tracked, err := TracksLocations(tx, item_id)
+	if err != nil || !tracked {
+		return err
+	}
+	if location_id == nil {
+		return ErrLocationRequired
+	}
+	if err := AdjustLevel(tx, item_id, *location_id, quantity); err != nil {
+		if errors.Is(err, ErrInsufficientLocationStock) {
+			return fmt.Errorf("%w: item %s", err, item_id)
+		}
+		return err
+	}
+	return nil
+}
+
+func GetLocations(c *fiber.Ctx) error {
+	dbQuery := db.GetDB().Model(&models.Location{})
+	if kind := c.Query("kind", ""); kind != "" {
+		dbQuery = dbQuery.Where("kind = ?", kind)
+	}
+	if c.Query("active", "") == "true" {
+		dbQuery = dbQuery.Where("is_active = ?", true)
+	}
+
+	var locations []models.Location
+	if err := dbQuery.Order("name ASC").Find(&locations).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, locations)
+}
+
+func CreateLocation(c *fiber.Ctx) error {
+	var req schemas.CreateLocationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	var count int64
+	if err := db.GetDB().Model(&models.Location{}).Where("code = ?", req.Code).Count(&count).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	if count > 0 {
+		return views.ConflictWithMessage(c, "location code already exists")
+	}
+
+	location := models.Location{
+		Name:     req.Name,
+		Code:     req.Code,
+		Kind:     req.Kind,
+		Address:  req.Address,
+		IsActive: true,
+	}
+	if err := db.GetDB().Create(&location).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.ObjectCreated(c, location)
+}
+
+func UpdateLocation(c *fiber.Ctx) error {
+	var req schemas.UpdateLocationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	location_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var location models.Location
+	if err := db.GetDB().Where("id = ?", location_id).First(&location).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	if req.Name != nil {
+		location.Name = *req.Name
+	}
+	if req.Kind != nil {
+		location.Kind = *req.Kind
+	}
+	if req.Address != nil {
+		location.Address = *req.Address
+	}
+	if req.IsActive != nil {
+		location.IsActive = *req.IsActive
+	}
+
+	if err := db.GetDB().Save(&location).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, location)
+}
+
+// GetItemStockLevels shows where an item's stock is: on hand per location,
+// in transit between locations and the aggregate stock sold from.
+func GetItemStockLevels(c *fiber.Ctx) error {
+	item_id, err := uuid.Parse(c.Params("item_id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var item models.Item
+	if err := db.GetDB().Where("id = ?", item_id).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	var levels []models.StockLevel
+	if err := db.GetDB().Preload("Location").Where("item_id = ?", item_id).Find(&levels).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	var inTransit int
+	if err := db.GetDB().Model(&models.StockTransfer{}).
+		Where("item_id = ? AND status = ?", item_id, models.TransferStatusInTransit).
+		Select("COALESCE(SUM(quantity), 0)").Scan(&inTransit).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	onHand := 0
+	for _, level := range levels {
+		onHand += level.Quantity
+	}
+
+	return views.StatusOK(c, fiber.Map{
+		"item_id":    item.ID,
+		"stock":      item.Stock,
+		"on_hand":    onHand,
+		"in_transit": inTransit,
+		"levels":     levels,
+	})
+}
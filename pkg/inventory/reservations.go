@@ -0,0 +1,135 @@
+package inventory
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/config"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func reservationExpiry() int {
+	return int(time.Now().Add(time.Duration(config.RESERVATION_TTL_MINUTES) * time.Minute).Unix())
+}
+
+// Reserve takes quantity units of the item out of stock for an order item of
+// a pending order, until the reservation is converted or expires.
+func Reserve(tx *gorm.DB, item_id uuid.UUID, order_id uuid.UUID, order_item_id uuid.UUID, quantity int) error {
+	if err := Move(tx, &models.StockMovement{
+		ItemID:      item_id,
+		Quantity:    -quantity,
+		Reason:      models.StockReasonReserve,
+		OrderID:     &order_id,
+		OrderItemID: &order_item_id,
+	}); err != nil {
+		return err
+	}
+	return tx.Create(&models.StockReservation{
+		ItemID:      item_id,
+		OrderID:     order_id,
+		OrderItemID: order_item_id,
+		Quantity:    quantity,
+		ExpiresAt:   reservationExpiry(),
+		Status:      models.ReservationStatusActive,
+	}).Error
+}
+
+func activeReservation(tx *gorm.DB, order_item_id uuid.UUID) (*models.StockReservation, error) {
+	var reservation models.StockReservation
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_item_id = ? AND status = ?", order_item_id, models.ReservationStatusActive).
+		First(&reservation).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// ResizeReservation grows or shrinks the active reservation of an order item
+// by diff and restarts its expiry. It reports false when the order item has
+// no active reservation, leaving stock untouched.
+func ResizeReservation(tx *gorm.DB, order_item_id uuid.UUID, diff int) (bool, error) {
+	reservation, err := activeReservation(tx, order_item_id)
+	if err != nil || reservation == nil {
+		return false, err
+	}
+
+	if diff != 0 {
+		reason := models.StockReasonReserve
+		if diff < 0 {
+			reason = models.StockReasonRelease
+		}
+		if err := Move(tx, &models.StockMovement{
+			ItemID:      reservation.ItemID,
+			Quantity:    -diff,
+			Reason:      reason,
+			OrderID:     &reservation.OrderID,
+			OrderItemID: &reservation.OrderItemID,
+		}); err != nil {
+			return true, err
+		}
+	}
+
+	return true, tx.Model(reservation).Updates(map[string]interface{}{
+		"quantity":   reservation.Quantity + diff,
+		"expires_at": reservationExpiry(),
+	}).Error
+}
+
+// ReleaseReservation returns the reserved stock of an order item and closes
+// the reservation with status (released or expired). It reports false when
+// the order item has no active reservation.
+func ReleaseReservation(tx *gorm.DB, order_item_id uuid.UUID, status string) (bool, error) {
+	reservation, err := activeReservation(tx, order_item_id)
+	if err != nil || reservation == nil {
+		return false, err
+	}
+
+	if err := Move(tx, &models.StockMovement{
+		ItemID:      reservation.ItemID,
+		Quantity:    reservation.Quantity,
+		Reason:      models.StockReasonRelease,
+		OrderID:     &reservation.OrderID,
+		OrderItemID: &reservation.OrderItemID,
+	}); err != nil {
+		return true, err
+	}
+
+	return true, tx.Model(reservation).Update("status", status).Error
+}
+
+// ConvertReservations turns the active reservations of an order into sales:
+// the stock stays out and the items' sold counters move. Reservations past
+// their expiry that the sweeper has not released yet still hold their stock
+// and are converted as well.
+func ConvertReservations(tx *gorm.DB, order_id uuid.UUID) error {
+	var reservations []models.StockReservation
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ? AND status = ?", order_id, models.ReservationStatusActive).
+		Find(&reservations).Error; err != nil {
+		return err
+	}
+
+	for _, reservation := range reservations {
+		if err := addSold(tx, reservation.ItemID, reservation.Quantity); err != nil {
+			return err
+		}
+		if err := tx.Model(&reservation).Update("status", models.ReservationStatusConverted).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpiredReservations lists the active reservations whose expiry has passed.
+func ExpiredReservations(tx *gorm.DB) ([]models.StockReservation, error) {
+	var reservations []models.StockReservation
+	err := tx.Where("status = ? AND expires_at <= ?", models.ReservationStatusActive, time.Now().Unix()).
+		Order("expires_at ASC").Find(&reservations).Error
+	return reservations, err
+}
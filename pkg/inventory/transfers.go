@@ -0,0 +1,157 @@
+package inventory
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errTransferClosed = errors.New("transfer is no longer in transit")
+
+func GetTransfers(c *fiber.Ctx) error {
+	dbQuery := db.GetDB().Model(&models.StockTransfer{})
+	if status := c.Query("status", ""); status != "" {
+		dbQuery = dbQuery.Where("status = ?", status)
+	}
+	if item_id := c.Query("item_id", ""); item_id != "" {
+		dbQuery = dbQuery.Where("item_id = ?", item_id)
+	}
+	if location_id := c.Query("location_id", ""); location_id != "" {
+		dbQuery = dbQuery.Where("from_location_id = ? OR to_location_id = ?", location_id, location_id)
+	}
+
+	var transfers []models.StockTransfer
+	if err := dbQuery.Order("created_at DESC").Find(&transfers).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, transfers)
+}
+
+// CreateTransfer dispatches stock from one location to another. The quantity
+// leaves the source straight away and stays in transit until received.
+func CreateTransfer(c *fiber.Ctx) error {
+	var req schemas.CreateStockTransferRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	user_id, err := uuid.Parse(req.UserID)
+	if err != nil {
+		return views.BadRequest(c)
+	}
+	item_id, err := uuid.Parse(req.ItemID)
+	if err != nil {
+		return views.BadRequest(c)
+	}
+	from_location_id, err := uuid.Parse(req.FromLocationID)
+	if err != nil {
+		return views.BadRequest(c)
+	}
+	to_location_id, err := uuid.Parse(req.ToLocationID)
+	if err != nil {
+		return views.BadRequest(c)
+	}
+	if from_location_id == to_location_id {
+		return views.BadRequestWithMessage(c, "source and destination must differ")
+	}
+
+	var destination models.Location
+	if err := db.GetDB().Where("id = ?", to_location_id).First(&destination).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+	if !destination.IsActive {
+		return views.BadRequestWithMessage(c, ErrLocationInactive.Error())
+	}
+
+	transfer := models.StockTransfer{
+		ItemID:         item_id,
+		FromLocationID: from_location_id,
+		ToLocationID:   to_location_id,
+		Quantity:       req.Quantity,
+		Status:         models.TransferStatusInTransit,
+		ActorID:        &user_id,
+		Note:           req.Note,
+	}
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := AdjustLevel(tx, item_id, from_location_id, -req.Quantity); err != nil {
+			return err
+		}
+		return tx.Create(&transfer).Error
+	}); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		if errors.Is(err, ErrInsufficientLocationStock) {
+			return views.BadRequestWithMessage(c, err.Error())
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	return views.ObjectCreated(c, transfer)
+}
+
+// ReceiveTransfer books an in-transit transfer into its destination.
+func ReceiveTransfer(c *fiber.Ctx) error {
+	return closeTransfer(c, models.TransferStatusReceived)
+}
+
+// CancelTransfer returns an in-transit transfer to its source.
+func CancelTransfer(c *fiber.Ctx) error {
+	return closeTransfer(c, models.TransferStatusCancelled)
+}
+
+func closeTransfer(c *fiber.Ctx, status string) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var transfer models.StockTransfer
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&transfer).Error; err != nil {
+			return err
+		}
+		if transfer.Status != models.TransferStatusInTransit {
+			return errTransferClosed
+		}
+
+		location_id := transfer.ToLocationID
+		if status == models.TransferStatusCancelled {
+			location_id = transfer.FromLocationID
+		}
+		if err := AdjustLevel(tx, transfer.ItemID, location_id, transfer.Quantity); err != nil {
+			return err
+		}
+
+		transfer.Status = status
+		if status == models.TransferStatusReceived {
+			transfer.ReceivedAt = int(time.Now().Unix())
+		}
+		return tx.Save(&transfer).Error
+	}); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		if errors.Is(err, errTransferClosed) {
+			return views.BadRequestWithMessage(c, err.Error())
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	return views.StatusOK(c, transfer)
+}
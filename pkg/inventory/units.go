@@ -0,0 +1,109 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrUnitsUnavailable = errors.New("requested units are not available")
+
+// SyncSerialisedStock sets Item.Stock to the number of available units. It is
+// a no-op for items that are not serialised.
+func SyncSerialisedStock(tx *gorm.DB, item_id uuid.UUID) error {
+	return tx.Model(&models.Item{}).
+		Where("id = ? AND is_serialised = ?", item_id, true).
+		Update("stock", tx.Model(&models.InventoryUnit{}).
+			Select("COUNT(*)").
+			Where("item_id = ? AND status = ?", item_id, models.UnitStatusAvailable)).Error
+}
+
+// AllocateUnits reserves units of the item for an order item: the given
+// unit_ids when provided, otherwise the quantity oldest available units.
+func AllocateUnits(tx *gorm.DB, item_id uuid.UUID, order_item_id uuid.UUID, quantity int, unit_ids []uuid.UUID) ([]models.InventoryUnit, error) {
+	var units []models.InventoryUnit
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("item_id = ? AND status = ?", item_id, models.UnitStatusAvailable)
+	if len(unit_ids) > 0 {
+		query = query.Where("id IN ?", unit_ids)
+		quantity = len(unit_ids)
+	}
+	if err := query.Order("created_at ASC").Limit(quantity).Find(&units).Error; err != nil {
+		return nil, err
+	}
+	if len(units) < quantity {
+		return nil, ErrUnitsUnavailable
+	}
+
+	ids := make([]uuid.UUID, len(units))
+	for i, unit := range units {
+		ids[i] = unit.ID
+	}
+	if err := tx.Model(&models.InventoryUnit{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+		"status":        models.UnitStatusReserved,
+		"order_item_id": order_item_id,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	return units, SyncSerialisedStock(tx, item_id)
+}
+
+// ReleaseUnits puts count of the units reserved for the order item back on
+// sale, most recently allocated first. A count below 1 releases all of them.
+func ReleaseUnits(tx *gorm.DB, item_id uuid.UUID, order_item_id uuid.UUID, count int) error {
+	query := tx.Model(&models.InventoryUnit{}).Select("id").
+		Where("order_item_id = ? AND status = ?", order_item_id, models.UnitStatusReserved).
+		Order("updated_at DESC")
+	if count > 0 {
+		query = query.Limit(count)
+	}
+
+	var ids []uuid.UUID
+	if err := query.Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if count > 0 && len(ids) < count {
+		return fmt.Errorf("only %d units are reserved for order item %s", len(ids), order_item_id)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if err := tx.Model(&models.InventoryUnit{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+		"status":        models.UnitStatusAvailable,
+		"order_item_id": nil,
+	}).Error; err != nil {
+		return err
+	}
+	return SyncSerialisedStock(tx, item_id)
+}
+
+// MarkUnitsSold flags every unit reserved for the given order items as sold.
+func MarkUnitsSold(tx *gorm.DB, order_item_ids []uuid.UUID) error {
+	if len(order_item_ids) == 0 {
+		return nil
+	}
+	return tx.Model(&models.InventoryUnit{}).
+		Where("order_item_id IN ? AND status = ?", order_item_ids, models.UnitStatusReserved).
+		Update("status", models.UnitStatusSold).Error
+}
+
+// ReturnUnits puts every unit held by the order item back on sale, whether it
+// was still reserved or already sold.
+func ReturnUnits(tx *gorm.DB, item_id uuid.UUID, order_item_id uuid.UUID) error {
+	result := tx.Model(&models.InventoryUnit{}).
+		Where("order_item_id = ? AND status IN ?", order_item_id, []string{models.UnitStatusReserved, models.UnitStatusSold}).
+		Updates(map[string]interface{}{
+			"status":        models.UnitStatusAvailable,
+			"order_item_id": nil,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	return SyncSerialisedStock(tx, item_id)
+}
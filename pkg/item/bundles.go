@@ -0,0 +1,192 @@
+package item
+
+import (
+	"errors"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/inventory"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var errBundleInOpenOrders = errors.New("bundle is on orders that are still open")
+
+func GetBundleComponents(c *fiber.Ctx) error {
+	var bundle models.Item
+	if err := db.GetDB().Preload("Components", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at ASC")
+	}).Preload("Components.Component").Where("id = ?", c.Params("id")).First(&bundle).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+	if !bundle.IsBundle {
+		return views.BadRequestWithMessage(c, "item is not a bundle")
+	}
+
+	var componentsTotal money.Amount
+	for _, component := range bundle.Components {
+		if component.Component != nil {
+			componentsTotal += component.Component.Price.Times(component.Quantity)
+		}
+	}
+
+	return views.StatusOK(c, fiber.Map{
+		"bundle_id":        bundle.ID,
+		"components":       bundle.Components,
+		"components_total": componentsTotal,
+		"discount":         bundle.BundleDiscount,
+		"price":            bundle.Price,
+		"stock":            bundle.Stock,
+	})
+}
+
+// SetBundleComponents replaces what a bundle is made of and its discount. The
+// bundle's price and stock are derived from the components again.
+func SetBundleComponents(c *fiber.Ctx) error {
+	bundle_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var req schemas.SetBundleComponentsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	var bundle models.Item
+	if err := db.GetDB().Where("id = ?", bundle_id).First(&bundle).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+	if !bundle.IsBundle {
+		return views.BadRequestWithMessage(c, "item is not a bundle")
+	}
+
+	var components []models.BundleComponent
+	seen := map[uuid.UUID]bool{}
+	for _, componentReq := range req.Components {
+		component_id, err := uuid.Parse(componentReq.ItemID)
+		if err != nil {
+			return views.BadRequest(c)
+		}
+		if component_id == bundle_id || seen[component_id] {
+			return views.BadRequestWithMessage(c, "components must be distinct items other than the bundle")
+		}
+		seen[component_id] = true
+
+		var component models.Item
+		if err := db.GetDB().Where("id = ?", component_id).First(&component).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return views.RecordNotFound(c)
+			}
+			return views.InternalServerError(c, err)
+		}
+		if component.IsBundle || component.IsSerialised {
+			return views.BadRequestWithMessage(c, "bundles and serialised items cannot be bundle components")
+		}
+
+		components = append(components, models.BundleComponent{
+			BundleID:    bundle_id,
+			ComponentID: component_id,
+			Quantity:    componentReq.Quantity,
+		})
+	}
+
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		// orders release, ship and restock a bundle by its components, so
+		// the composition must not change while an open order holds it. The
+		// bundle is locked first, as adding it to an order does.
+		if _, err := inventory.LockItem(tx, bundle_id); err != nil {
+			return err
+		}
+		var openOrders int64
+		if err := tx.Model(&models.OrderItem{}).
+			Joins("JOIN orders ON orders.id = order_items.order_id").
+			Where("order_items.item_id = ? AND order_items.order_item_status NOT IN ?", bundle_id, []string{"cancelled", "expired"}).
+			Where("orders.status NOT IN ?", []string{"cancelled", "delivered"}).
+			Count(&openOrders).Error; err != nil {
+			return err
+		}
+		if openOrders > 0 {
+			return errBundleInOpenOrders
+		}
+
+		if err := tx.Where("bundle_id = ?", bundle_id).Delete(&models.BundleComponent{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&components).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&bundle).Update("bundle_discount", req.Discount).Error; err != nil {
+			return err
+		}
+		if err := SyncBundlePrice(tx, &bundle); err != nil {
+			return err
+		}
+		return inventory.SyncBundleStock(tx, bundle_id, nil)
+	}); err != nil {
+		if errors.Is(err, errBundleInOpenOrders) {
+			return views.BadRequestWithMessage(c, err.Error())
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	return views.StatusOK(c, "bundle components updated")
+}
+
+// BundlePrice is the components' total less the bundle discount, rounded to
+// the paisa.
+func BundlePrice(tx *gorm.DB, bundle *models.Item) (money.Amount, error) {
+	var componentsTotal money.Amount
+	if err := tx.Table("bundle_components").
+		Select("COALESCE(SUM(items.price * bundle_components.quantity), 0)").
+		Joins("JOIN items ON items.id = bundle_components.component_id").
+		Where("bundle_components.bundle_id = ?", bundle.ID).
+		Scan(&componentsTotal).Error; err != nil {
+		return 0, err
+	}
+	return componentsTotal - componentsTotal.Percent(bundle.BundleDiscount), nil
+}
+
+// SyncBundlePrice sets the price of a bundle from its components, recording
+// the change in the price history.
+func SyncBundlePrice(tx *gorm.DB, bundle *models.Item) error {
+	price, err := BundlePrice(tx, bundle)
+	if err != nil {
+		return err
+	}
+	if err := RecordPriceChange(tx, bundle, price, bundle.GST, "bundle", nil); err != nil {
+		return err
+	}
+	bundle.Price = price
+	return tx.Model(&models.Item{}).Where("id = ?", bundle.ID).Update("price", price).Error
+}
+
+// SyncBundlePrices re-prices every bundle the item is a component of. It must
+// be called after the item's price is updated.
+func SyncBundlePrices(tx *gorm.DB, component_id uuid.UUID) error {
+	var bundles []models.Item
+	if err := tx.Where("id IN (?)", tx.Model(&models.BundleComponent{}).Select("bundle_id").Where("component_id = ?", component_id)).
+		Find(&bundles).Error; err != nil {
+		return err
+	}
+	for i := range bundles {
+		if err := SyncBundlePrice(tx, &bundles[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,11 +4,13 @@ import (
 	"errors"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Baalamurgan/coin-selling-backend/api/db"
 	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
 	"github.com/Baalamurgan/coin-selling-backend/api/utils"
 	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/inventory"
 	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -16,6 +18,16 @@ import (
 )
 
 func GetAllItems(c *fiber.Ctx) error {
+	return listItems(c, false)
+}
+
+// GetPublishedItems is the storefront listing: only published items are
+// returned and the status filter is ignored.
+func GetPublishedItems(c *fiber.Ctx) error {
+	return listItems(c, true)
+}
+
+func listItems(c *fiber.Ctx, publishedOnly bool) error {
 	page, err := strconv.Atoi(c.Query("page", "1"))
 	if err != nil || page < 1 {
 		return views.BadRequest(c)
@@ -28,6 +40,8 @@ func GetAllItems(c *fiber.Ctx) error {
 
 	searchQuery := c.Query("search", "")
 	categoryIDs := c.Query("category_ids", "") // category_id1, category_id2, category_id3
+	status := c.Query("status", "")            // draft, published, archived
+	groupVariants := c.Query("group_variants", "true") != "false"
 
 	var parsedCategoryIDs []*uuid.UUID
 	if categoryIDs != "" {
@@ -43,7 +57,7 @@ func GetAllItems(c *fiber.Ctx) error {
 
 	var items []models.Item
 	var total int64
-	dbQuery := db.GetDB().Model(&models.Item{}).Preload("Details")
+	dbQuery := db.GetDB().Model(&models.Item{}).Preload("Details").Preload("Images", orderImages)
 
 	if searchQuery != "" {
 		dbQuery = dbQuery.Where("name ILIKE ? OR description ILIKE ?", "%"+searchQuery+"%", "%"+searchQuery+"%")
@@ -53,6 +67,17 @@ func GetAllItems(c *fiber.Ctx) error {
 		dbQuery = dbQuery.Where("category_id IN ?", parsedCategoryIDs)
 	}
 
+	if publishedOnly {
+		dbQuery = dbQuery.Scopes(Published)
+	} else if status != "" {
+		dbQuery = dbQuery.Where("status IN ?", strings.Split(status, ","))
+	}
+
+	if groupVariants {
+		// variants are listed under their parent product
+		dbQuery = dbQuery.Where("parent_id IS NULL")
+	}
+
 	if err := dbQuery.Count(&total).Error; err != nil {
 		return views.InternalServerError(c, err)
 	}
@@ -60,6 +85,15 @@ func GetAllItems(c *fiber.Ctx) error {
 	if err := dbQuery.Order("updated_at DESC").Scopes(utils.Paginate(page, limit)).Find(&items).Error; err != nil {
 		return views.InternalServerError(c, err)
 	}
+
+	if err := WithSalePrices(items); err != nil {
+		return views.InternalServerError(c, err)
+	}
+	if groupVariants {
+		if err := withVariants(items, publishedOnly); err != nil {
+			return views.InternalServerError(c, err)
+		}
+	}
 	return views.StatusOK(c, fiber.Map{
 		"items": items,
 		"pagination": fiber.Map{
@@ -100,26 +134,36 @@ func GetItemsBySubCategoryID(c *fiber.Ctx) error {
 func GetItemByID(c *fiber.Ctx) error {
 	var item models.Item
 	id := c.Params("id")
-	if err := db.GetDB().Where("id = ?", id).First(&item).Error; err != nil {
+	if err := db.GetDB().Preload("Images", orderImages).Where("id = ?", id).First(&item).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return views.RecordNotFound(c)
 		}
 		return views.InternalServerError(c, err)
 	}
-	return views.StatusOK(c, item)
+
+	items := []models.Item{item}
+	if err := withVariants(items, false); err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, items[0])
 }
 
 func GetItemBySlug(c *fiber.Ctx) error {
 	var item models.Item
 	slug := strings.ReplaceAll(c.Params("slug"), "%26", "&")
 
-	if err := db.GetDB().Model(&models.Item{}).Preload("Details").Where("slug = ?", slug).First(&item).Error; err != nil {
+	if err := db.GetDB().Model(&models.Item{}).Preload("Details").Preload("Images", orderImages).Where("slug = ?", slug).First(&item).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return views.RecordNotFound(c)
 		}
 		return views.InternalServerError(c, err)
 	}
-	return views.StatusOK(c, item)
+
+	items := []models.Item{item}
+	if err := withVariants(items, false); err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, items[0])
 }
 
 func CreateItem(c *fiber.Ctx) error {
@@ -146,7 +190,27 @@ func CreateItem(c *fiber.Ctx) error {
 	newItem.Sold = req.Sold
 	newItem.Price = req.Price
 	newItem.GST = req.GST
+	newItem.HSN = req.HSN
 	newItem.Slug = utils.GenerateItemSlug(req.Name)
+	newItem.IsSerialised = req.IsSerialised
+	newItem.ReorderThreshold = req.ReorderThreshold
+	newItem.IsBundle = req.IsBundle && !req.IsSerialised
+	if newItem.IsBundle {
+		// stock and price of a bundle follow its components once they are set
+		newItem.Stock = 0
+		newItem.Price = 0
+	}
+	if req.IsSerialised {
+		// stock of serialised items is the count of their available units
+		newItem.Stock = 0
+	}
+	newItem.Status = models.ItemStatusDraft
+	newItem.PublishAt = req.PublishAt
+	if req.Status == models.ItemStatusPublished {
+		newItem.Status = models.ItemStatusPublished
+		newItem.PublishedAt = int(time.Now().Unix())
+		newItem.PublishAt = nil
+	}
 
 	for _, itemReq := range req.Details {
 		newItem.Details = append(newItem.Details, models.Detail{
@@ -155,13 +219,53 @@ func CreateItem(c *fiber.Ctx) error {
 		})
 	}
 
-	if err := db.GetDB().Create(&newItem).Error; err != nil {
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if newItem.SKU == "" {
+			sku, err := GenerateSKU(tx, category_id, newItem.Year)
+			if err != nil {
+				return err
+			}
+			newItem.SKU = sku
+		} else if taken, err := SKUTaken(tx, newItem.SKU, nil); err != nil {
+			return err
+		} else if taken {
+			return ErrSKUTaken
+		}
+
+		if err := tx.Create(&newItem).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.ItemPriceHistory{
+			ItemID:      newItem.ID,
+			Price:       newItem.Price,
+			GST:         newItem.GST,
+			Source:      "create",
+			EffectiveAt: int(time.Now().Unix()),
+		}).Error; err != nil {
+			return err
+		}
+		if newItem.IsBundle {
+			return nil
+		}
+		return inventory.RecordOpeningStock(tx, &newItem, "")
+	}); err != nil {
+		if errors.Is(err, ErrSKUTaken) {
+			return views.ConflictWithMessage(c, err.Error())
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.BadRequestWithMessage(c, "category not found")
+		}
 		return views.InternalServerError(c, err)
 	}
 
 	return views.ObjectCreated(c, newItem)
 }
 
+var (
+	errSerialisedBundle       = errors.New("bundles and their components cannot be serialised")
+	errSerialisationWithStock = errors.New("serialisation can only be changed while the item has no stock")
+)
+
 func UpdateItem(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var req schemas.UpdateItemRequest
@@ -172,26 +276,96 @@ func UpdateItem(c *fiber.Ctx) error {
 		return views.InvalidParams(c)
 	}
 
-	var item models.Item
-	if err := db.GetDB().Table("items").Where("id = ?", id).First(&item).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return views.RecordNotFound(c)
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		var item models.Item
+		if err := tx.Table("items").Where("id = ?", id).First(&item).Error; err != nil {
+			return err
 		}
-		return views.InternalServerError(c, err)
-	}
 
-	item.CategoryID = *req.CategoryID
-	item.Name = *req.Name
-	item.Description = *req.Description
-	item.Year = *req.Year
-	item.SKU = *req.SKU
-	item.ImageURL = *req.ImageURL
-	item.Stock = *req.Stock
-	item.Sold = *req.Sold
-	item.Price = *req.Price
-	item.GST = *req.GST
+		// fields left out of the request keep their values, and the price of
+		// a bundle always follows its components
+		price, gst := item.Price, item.GST
+		if req.Price != nil && !item.IsBundle {
+			price = *req.Price
+		}
+		if req.GST != nil {
+			gst = *req.GST
+		}
+		if err := RecordPriceChange(tx, &item, price, gst, "manual", nil); err != nil {
+			return err
+		}
+		item.Price = price
+		item.GST = gst
+
+		if req.CategoryID != nil {
+			item.CategoryID = *req.CategoryID
+		}
+		if req.Name != nil {
+			item.Name = *req.Name
+		}
+		if req.Description != nil {
+			item.Description = *req.Description
+		}
+		if req.Year != nil {
+			item.Year = *req.Year
+		}
+		if req.SKU != nil && *req.SKU != item.SKU {
+			if taken, err := SKUTaken(tx, *req.SKU, &item.ID); err != nil {
+				return err
+			} else if taken {
+				return ErrSKUTaken
+			}
+			item.SKU = *req.SKU
+		}
+		if req.ImageURL != nil {
+			item.ImageURL = *req.ImageURL
+		}
+		if req.HSN != nil {
+			item.HSN = *req.HSN
+		}
+		if req.ReorderThreshold != nil {
+			item.ReorderThreshold = *req.ReorderThreshold
+		}
 
-	if err := db.GetDB().Save(&item).Error; err != nil {
+		if req.IsSerialised != nil && *req.IsSerialised != item.IsSerialised {
+			var componentCount int64
+			if err := tx.Model(&models.BundleComponent{}).Where("component_id = ?", item.ID).Count(&componentCount).Error; err != nil {
+				return err
+			}
+			if item.IsBundle || componentCount > 0 {
+				return errSerialisedBundle
+			}
+
+			var unitCount int64
+			if err := tx.Model(&models.InventoryUnit{}).Where("item_id = ?", item.ID).Count(&unitCount).Error; err != nil {
+				return err
+			}
+			if unitCount > 0 || item.Stock > 0 {
+				return errSerialisationWithStock
+			}
+			item.IsSerialised = *req.IsSerialised
+		}
+
+		// stock and sold only change through the inventory ledger
+		if err := tx.Omit("stock", "sold").Save(&item).Error; err != nil {
+			return err
+		}
+
+		if err := inventory.SyncSerialisedStock(tx, item.ID); err != nil {
+			return err
+		}
+
+		return SyncBundlePrices(tx, item.ID)
+	}); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		if errors.Is(err, ErrSKUTaken) {
+			return views.ConflictWithMessage(c, err.Error())
+		}
+		if errors.Is(err, errSerialisedBundle) || errors.Is(err, errSerialisationWithStock) {
+			return views.BadRequestWithMessage(c, err.Error())
+		}
 		return views.InternalServerError(c, err)
 	}
 
@@ -204,6 +378,39 @@ func DeleteItem(c *fiber.Ctx) error {
 		return views.BadRequest(c)
 	}
 
+	// Order items keep referencing the item, so anything that has been sold
+	// once is archived instead of removed.
+	var orderItemCount int64
+	if err := db.GetDB().Model(&models.OrderItem{}).Where("item_id = ?", id).Count(&orderItemCount).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	if orderItemCount > 0 {
+		result := db.GetDB().Model(&models.Item{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"status":      models.ItemStatusArchived,
+			"publish_at":  nil,
+			"archived_at": time.Now().Unix(),
+		})
+		if result.Error != nil {
+			return views.InternalServerError(c, result.Error)
+		} else if result.RowsAffected == 0 {
+			return views.RecordNotFound(c)
+		}
+		return views.StatusOK(c, "item has order history and was archived")
+	}
+
+	var componentCount int64
+	if err := db.GetDB().Model(&models.BundleComponent{}).Where("component_id = ?", id).Count(&componentCount).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	if componentCount > 0 {
+		return views.BadRequestWithMessage(c, "item is a component of a bundle")
+	}
+
+	var images []models.ItemImage
+	if err := db.GetDB().Where("item_id = ?", id).Find(&images).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+
 	result := db.GetDB().Where("id = ?", id).Delete(&models.Item{})
 	if result.Error != nil {
 		return views.InternalServerError(c, result.Error)
@@ -211,5 +418,11 @@ func DeleteItem(c *fiber.Ctx) error {
 		return views.RecordNotFound(c)
 	}
 
+	deleteItemImageBlobs(c.Context(), images)
+
 	return views.StatusOK(c, "item deleted")
 }
+
+func orderImages(db *gorm.DB) *gorm.DB {
+	return db.Order("position ASC")
+}
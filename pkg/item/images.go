@@ -0,0 +1,351 @@
+package item
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"slices"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/constants"
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/storage"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"gorm.io/gorm"
+)
+
+var (
+	errInvalidImage  = errors.New("invalid image")
+	errImageTooLarge = errors.New("image too large")
+)
+
+// imageFormats gives the content type and file extension stored originals
+// get, by the format name image.Decode reports. What the client claimed for
+// the upload is never used.
+var imageFormats = map[string]struct {
+	contentType string
+	extension   string
+}{
+	"jpeg": {"image/jpeg", ".jpg"},
+	"png":  {"image/png", ".png"},
+	"gif":  {"image/gif", ".gif"},
+	"webp": {"image/webp", ".webp"},
+}
+
+func GetItemImages(c *fiber.Ctx) error {
+	item_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var images []models.ItemImage
+	if err := db.GetDB().Where("item_id = ?", item_id).Order("position ASC").Find(&images).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, images)
+}
+
+// UploadItemImages accepts one or more files in the "images" form field. Each
+// file needs a matching "kind" form value (obverse, reverse, edge,
+// certificate), given in the same order as the files.
+func UploadItemImages(c *fiber.Ctx) error {
+	item_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return views.BadRequestWithMessage(c, "Failed to get files")
+	}
+
+	files := form.File["images"]
+	kinds := form.Value["kind"]
+	if len(files) == 0 {
+		return views.BadRequestWithMessage(c, "no images uploaded")
+	}
+	if len(kinds) != len(files) {
+		return views.BadRequestWithMessage(c, "a kind is required for every image")
+	}
+	for _, kind := range kinds {
+		if !slices.Contains(constants.ITEM_IMAGE_KINDS, kind) {
+			return views.BadRequestWithMessage(c, "invalid image kind: "+kind)
+		}
+	}
+
+	var item models.Item
+	if err := db.GetDB().Where("id = ?", item_id).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	var position int
+	if err := db.GetDB().Model(&models.ItemImage{}).Where("item_id = ?", item_id).
+		Select("COALESCE(MAX(position) + 1, 0)").Scan(&position).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	var images []models.ItemImage
+	for i, file := range files {
+		itemImage, err := storeItemImage(c.Context(), item_id, kinds[i], position+i, file)
+		if err != nil {
+			deleteItemImageBlobs(c.Context(), images)
+			if errors.Is(err, errInvalidImage) {
+				return views.BadRequestWithMessage(c, fmt.Sprintf("%s is not a supported image", file.Filename))
+			}
+			if errors.Is(err, errImageTooLarge) {
+				return views.BadRequestWithMessage(c, fmt.Sprintf("%s is larger than %d pixels", file.Filename, constants.IMAGE_MAX_PIXELS))
+			}
+			return views.InternalServerError(c, err)
+		}
+		images = append(images, *itemImage)
+	}
+
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&images).Error; err != nil {
+			return err
+		}
+		return syncItemImageURL(tx, item_id)
+	}); err != nil {
+		deleteItemImageBlobs(c.Context(), images)
+		return views.InternalServerError(c, err)
+	}
+
+	return views.ObjectCreated(c, images)
+}
+
+func ReorderItemImages(c *fiber.Ctx) error {
+	item_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var req schemas.ReorderItemImagesRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	var images []models.ItemImage
+	if err := db.GetDB().Where("item_id = ?", item_id).Find(&images).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	if len(req.ImageIDs) != len(images) {
+		return views.BadRequestWithMessage(c, "image_ids must list every image of the item")
+	}
+
+	positions := map[uuid.UUID]int{}
+	for i, id := range req.ImageIDs {
+		image_id, err := uuid.Parse(id)
+		if err != nil {
+			return views.BadRequest(c)
+		}
+		positions[image_id] = i
+	}
+	for _, itemImage := range images {
+		if _, ok := positions[itemImage.ID]; !ok {
+			return views.BadRequestWithMessage(c, "image_ids must list every image of the item")
+		}
+	}
+
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		for image_id, position := range positions {
+			if err := tx.Model(&models.ItemImage{}).Where("id = ?", image_id).Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return syncItemImageURL(tx, item_id)
+	}); err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	return views.StatusOK(c, "item images reordered")
+}
+
+func DeleteItemImage(c *fiber.Ctx) error {
+	item_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+	image_id, err := uuid.Parse(c.Params("image_id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var itemImage models.ItemImage
+	if err := db.GetDB().Where("id = ? AND item_id = ?", image_id, item_id).First(&itemImage).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&itemImage).Error; err != nil {
+			return err
+		}
+		return syncItemImageURL(tx, item_id)
+	}); err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	deleteItemImageBlobs(c.Context(), []models.ItemImage{itemImage})
+
+	return views.StatusOK(c, "item image deleted")
+}
+
+// storeItemImage uploads the original file together with a medium and a
+// thumbnail rendition, and returns the (unsaved) row describing them.
+func storeItemImage(ctx context.Context, item_id uuid.UUID, kind string, position int, file *multipart.FileHeader) (*models.ItemImage, error) {
+	fileContent, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer fileContent.Close()
+
+	data, err := io.ReadAll(fileContent)
+	if err != nil {
+		return nil, err
+	}
+
+	// the header is checked first so a small file cannot claim dimensions that
+	// would take gigabytes to decode
+	dimensions, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errInvalidImage
+	}
+	if dimensions.Width <= 0 || dimensions.Height <= 0 {
+		return nil, errInvalidImage
+	}
+	if int64(dimensions.Width)*int64(dimensions.Height) > constants.IMAGE_MAX_PIXELS {
+		return nil, errImageTooLarge
+	}
+
+	src, formatName, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errInvalidImage
+	}
+	format, ok := imageFormats[formatName]
+	if !ok {
+		return nil, errInvalidImage
+	}
+
+	itemImage := models.ItemImage{
+		ID:          uuid.New(),
+		ItemID:      item_id,
+		Kind:        kind,
+		Position:    position,
+		ContentType: format.contentType,
+		Width:       src.Bounds().Dx(),
+		Height:      src.Bounds().Dy(),
+	}
+	prefix := fmt.Sprintf("items/%s/%s", item_id, itemImage.ID)
+	itemImage.OriginalKey = prefix + "/original" + format.extension
+	itemImage.MediumKey = prefix + "/medium.jpg"
+	itemImage.ThumbnailKey = prefix + "/thumbnail.jpg"
+
+	medium, err := encodeRendition(src, constants.IMAGE_MEDIUM_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	thumbnail, err := encodeRendition(src, constants.IMAGE_THUMBNAIL_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	blobStore := storage.GetStore()
+	uploads := []struct {
+		key         string
+		data        []byte
+		contentType string
+	}{
+		{itemImage.OriginalKey, data, itemImage.ContentType},
+		{itemImage.MediumKey, medium, "image/jpeg"},
+		{itemImage.ThumbnailKey, thumbnail, "image/jpeg"},
+	}
+	for i, upload := range uploads {
+		if err := blobStore.Put(ctx, upload.key, bytes.NewReader(upload.data), int64(len(upload.data)), upload.contentType); err != nil {
+			for _, uploaded := range uploads[:i] {
+				if err := blobStore.Delete(ctx, uploaded.key); err != nil {
+					log.Println(err)
+				}
+			}
+			return nil, err
+		}
+	}
+
+	itemImage.OriginalURL = blobStore.URL(itemImage.OriginalKey)
+	itemImage.MediumURL = blobStore.URL(itemImage.MediumKey)
+	itemImage.ThumbnailURL = blobStore.URL(itemImage.ThumbnailKey)
+
+	return &itemImage, nil
+}
+
+// encodeRendition scales src down so that its longest edge is at most size
+// pixels and encodes the result as JPEG. Smaller images are not upscaled.
+func encodeRendition(src image.Image, size int) ([]byte, error) {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			height = max(height*size/width, 1)
+			width = size
+		} else {
+			width = max(width*size/height, 1)
+			height = size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func deleteItemImageBlobs(ctx context.Context, images []models.ItemImage) {
+	blobStore := storage.GetStore()
+	for _, itemImage := range images {
+		for _, key := range []string{itemImage.OriginalKey, itemImage.MediumKey, itemImage.ThumbnailKey} {
+			if err := blobStore.Delete(ctx, key); err != nil {
+				log.Println("Failed to delete blob:", key, err)
+			}
+		}
+	}
+}
+
+// syncItemImageURL keeps Item.ImageURL pointing at the medium rendition of the
+// first image so existing clients keep showing a picture, and clears it once
+// the last image is gone.
+func syncItemImageURL(tx *gorm.DB, item_id uuid.UUID) error {
+	var first models.ItemImage
+	if err := tx.Where("item_id = ?", item_id).Order("position ASC").First(&first).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Model(&models.Item{}).Where("id = ?", item_id).Update("image_url", "").Error
+		}
+		return err
+	}
+	return tx.Model(&models.Item{}).Where("id = ?", item_id).Update("image_url", first.MediumURL).Error
+}
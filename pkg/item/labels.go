@@ -0,0 +1,178 @@
+package item
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"strings"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/config"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/qr"
+	"github.com/go-pdf/fpdf"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// A4 sheet of 3 x 8 labels, 70 x 37 mm each, the common layout for
+// self-adhesive label paper and 2x2 coin flips.
+const (
+	labelColumns = 3
+	labelRows    = 8
+	labelWidth   = 70.0
+	labelHeight  = 37.0
+	labelPadding = 3.0
+	sheetMarginX = 0.0
+	sheetMarginY = 0.5
+)
+
+var errUnprintableSKU = errors.New("sku cannot be printed as a barcode")
+
+// PrintItemLabels renders a PDF sheet of labels for the requested items. Each
+// label carries the item name and SKU, a Code128 barcode of the SKU and a QR
+// code linking to the item on the storefront.
+func PrintItemLabels(c *fiber.Ctx) error {
+	var req schemas.PrintItemLabelsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+	if req.Copies == 0 {
+		req.Copies = 1
+	}
+
+	// an id may be repeated to print more labels of that item
+	var item_ids []uuid.UUID
+	unique_ids := map[uuid.UUID]bool{}
+	for _, id := range req.ItemIDs {
+		item_id, err := uuid.Parse(id)
+		if err != nil {
+			return views.BadRequest(c)
+		}
+		item_ids = append(item_ids, item_id)
+		unique_ids[item_id] = true
+	}
+
+	var items []models.Item
+	if err := db.GetDB().Where("id IN ?", item_ids).Find(&items).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	if len(items) != len(unique_ids) {
+		return views.RecordNotFound(c)
+	}
+
+	// keep the order the labels were asked for
+	itemsByID := map[uuid.UUID]models.Item{}
+	for _, item := range items {
+		itemsByID[item.ID] = item
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+
+	position := 0
+	for _, item_id := range item_ids {
+		item := itemsByID[item_id]
+		for copy := 0; copy < req.Copies; copy++ {
+			if position%(labelColumns*labelRows) == 0 {
+				pdf.AddPage()
+			}
+			slot := position % (labelColumns * labelRows)
+			x := sheetMarginX + float64(slot%labelColumns)*labelWidth
+			y := sheetMarginY + float64(slot/labelColumns)*labelHeight
+			if err := drawItemLabel(pdf, item, x, y, req.WithPrice); err != nil {
+				if errors.Is(err, errUnprintableSKU) {
+					return views.BadRequestWithMessage(c, err.Error())
+				}
+				return views.InternalServerError(c, err)
+			}
+			position++
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, `inline; filename="item-labels.pdf"`)
+	return c.Send(buf.Bytes())
+}
+
+func drawItemLabel(pdf *fpdf.Fpdf, item models.Item, x float64, y float64, withPrice bool) error {
+	qrSize := labelHeight - 2*labelPadding
+	textX := x + labelPadding
+	textWidth := labelWidth - 3*labelPadding - qrSize
+
+	link := strings.TrimRight(config.STOREFRONT_URL, "/") + "/item/" + item.Slug
+	qrCode, err := qr.Encode(link, qr.M, qr.Auto)
+	if err != nil {
+		return err
+	}
+	if err := placeBarcode(pdf, "qr-"+item.ID.String(), qrCode, 200, 200, x+labelWidth-labelPadding-qrSize, y+labelPadding, qrSize, qrSize); err != nil {
+		return err
+	}
+
+	pdf.SetFont("Helvetica", "B", 8)
+	pdf.SetXY(textX, y+labelPadding)
+	pdf.MultiCell(textWidth, 3.5, pdf.UnicodeTranslatorFromDescriptor("")(truncateLabel(item.Name, 60)), "", "L", false)
+
+	pdf.SetFont("Helvetica", "", 7)
+	details := item.SKU
+	if item.Year != 0 {
+		details = fmt.Sprintf("%s  |  %d", item.SKU, item.Year)
+	}
+	if withPrice {
+		details = fmt.Sprintf("%s  |  Rs. %s", details, item.Price)
+	}
+	pdf.SetXY(textX, y+labelHeight-labelPadding-14)
+	pdf.CellFormat(textWidth, 3, details, "", 0, "L", false, 0, "")
+
+	barcodeCode, err := code128.Encode(item.SKU)
+	if err != nil {
+		return fmt.Errorf("%w: %q of %s", errUnprintableSKU, item.SKU, item.Name)
+	}
+	return placeBarcode(pdf, "code128-"+item.ID.String(), barcodeCode, 400, 80, textX, y+labelHeight-labelPadding-10, textWidth, 10)
+}
+
+// placeBarcode scales code to width x height pixels and draws it at x, y in
+// w x h millimetres. Images are registered once per name and reused for
+// repeated copies.
+func placeBarcode(pdf *fpdf.Fpdf, name string, code barcode.Barcode, width int, height int, x float64, y float64, w float64, h float64) error {
+	options := fpdf.ImageOptions{ImageType: "PNG"}
+	if pdf.GetImageInfo(name) == nil {
+		if bounds := code.Bounds(); width < bounds.Dx() {
+			width = bounds.Dx()
+		}
+		scaled, err := barcode.Scale(code, width, height)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, scaled); err != nil {
+			return err
+		}
+		pdf.RegisterImageOptionsReader(name, options, &buf)
+	}
+	pdf.ImageOptions(name, x, y, w, h, false, options, 0, "")
+	return pdf.Error()
+}
+
+func truncateLabel(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length-3]) + "..."
+}
@@ -0,0 +1,275 @@
+package item
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/inventory"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RecordPriceChange appends a history row when price or gst differ from the
+// item's current values. It must be called before the item is updated.
+func RecordPriceChange(tx *gorm.DB, item *models.Item, price money.Amount, gst float64, source string, scheduleID *uuid.UUID) error {
+	if item.Price == price && item.GST == gst {
+		return nil
+	}
+	return tx.Create(&models.ItemPriceHistory{
+		ItemID:        item.ID,
+		PreviousPrice: item.Price,
+		PreviousGST:   item.GST,
+		Price:         price,
+		GST:           gst,
+		Source:        source,
+		ScheduleID:    scheduleID,
+		EffectiveAt:   int(time.Now().Unix()),
+	}).Error
+}
+
+// ActiveSale returns the sale running for the item right now, if any.
+func ActiveSale(tx *gorm.DB, item_id uuid.UUID) (*models.ItemPriceSchedule, error) {
+	now := time.Now().Unix()
+	var sale models.ItemPriceSchedule
+	if err := tx.Where("item_id = ? AND kind = ? AND status = ? AND starts_at <= ? AND ends_at > ?",
+		item_id, models.PriceScheduleKindSale, models.PriceScheduleStatusScheduled, now, now).
+		Order("price ASC").First(&sale).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &sale, nil
+}
+
+// EffectivePrice is the unit price an order is billed at: the active sale
+// price when there is one, the item price otherwise.
+func EffectivePrice(tx *gorm.DB, item *models.Item) (money.Amount, error) {
+	sale, err := ActiveSale(tx, item.ID)
+	if err != nil {
+		return 0, err
+	}
+	if sale != nil {
+		return sale.Price, nil
+	}
+	return item.Price, nil
+}
+
+// WithSalePrices fills Item.SalePrice for every item with a running sale.
+func WithSalePrices(items []models.Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	itemIDs := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		itemIDs[i] = item.ID
+	}
+
+	now := time.Now().Unix()
+	var sales []models.ItemPriceSchedule
+	if err := db.GetDB().Where("item_id IN ? AND kind = ? AND status = ? AND starts_at <= ? AND ends_at > ?",
+		itemIDs, models.PriceScheduleKindSale, models.PriceScheduleStatusScheduled, now, now).
+		Find(&sales).Error; err != nil {
+		return err
+	}
+
+	salePrices := map[uuid.UUID]money.Amount{}
+	for _, sale := range sales {
+		if price, ok := salePrices[sale.ItemID]; !ok || sale.Price < price {
+			salePrices[sale.ItemID] = sale.Price
+		}
+	}
+	for i := range items {
+		if price, ok := salePrices[items[i].ID]; ok {
+			items[i].SalePrice = &price
+		}
+	}
+	return nil
+}
+
+func GetItemPriceTimeline(c *fiber.Ctx) error {
+	item_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var item models.Item
+	if err := db.GetDB().Where("id = ?", item_id).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	var history []models.ItemPriceHistory
+	if err := db.GetDB().Where("item_id = ?", item_id).Order("effective_at ASC").Find(&history).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	var schedules []models.ItemPriceSchedule
+	if err := db.GetDB().Where("item_id = ?", item_id).Order("starts_at ASC").Find(&schedules).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	effectivePrice, err := EffectivePrice(db.GetDB(), &item)
+	if err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	return views.StatusOK(c, fiber.Map{
+		"item_id":         item.ID,
+		"price":           item.Price,
+		"gst":             item.GST,
+		"effective_price": effectivePrice,
+		"history":         history,
+		"schedules":       schedules,
+	})
+}
+
+func ScheduleItemPrice(c *fiber.Ctx) error {
+	item_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var req schemas.ScheduleItemPriceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	if req.Kind == models.PriceScheduleKindSale {
+		if req.EndsAt == nil || *req.EndsAt <= req.StartsAt {
+			return views.BadRequestWithMessage(c, "a sale needs an ends_at after starts_at")
+		}
+		if req.GST != nil {
+			return views.BadRequestWithMessage(c, "a sale cannot change the gst rate")
+		}
+	} else if req.EndsAt != nil {
+		return views.BadRequestWithMessage(c, "a price change cannot have an ends_at")
+	}
+
+	var item models.Item
+	if err := db.GetDB().Where("id = ?", item_id).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+	if item.IsBundle {
+		return views.BadRequestWithMessage(c, "the price of a bundle follows its components")
+	}
+
+	schedule := models.ItemPriceSchedule{
+		ItemID:   item_id,
+		Kind:     req.Kind,
+		Price:    req.Price,
+		GST:      req.GST,
+		StartsAt: req.StartsAt,
+		EndsAt:   req.EndsAt,
+		Status:   models.PriceScheduleStatusScheduled,
+	}
+	if err := db.GetDB().Create(&schedule).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	return views.ObjectCreated(c, schedule)
+}
+
+func CancelItemPriceSchedule(c *fiber.Ctx) error {
+	item_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+	schedule_id, err := uuid.Parse(c.Params("schedule_id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	result := db.GetDB().Model(&models.ItemPriceSchedule{}).
+		Where("id = ? AND item_id = ? AND status = ?", schedule_id, item_id, models.PriceScheduleStatusScheduled).
+		Update("status", models.PriceScheduleStatusCancelled)
+	if result.Error != nil {
+		return views.InternalServerError(c, result.Error)
+	} else if result.RowsAffected == 0 {
+		return views.RecordNotFound(c)
+	}
+
+	return views.StatusOK(c, "price schedule cancelled")
+}
+
+// ApplyScheduledPriceChanges moves every due price change onto its item. Each
+// schedule is locked and read again before it is applied, so one cancelled in
+// the meantime, or applied by another instance, is skipped.
+func ApplyScheduledPriceChanges() (int, error) {
+	var schedules []models.ItemPriceSchedule
+	if err := db.GetDB().
+		Where("kind = ? AND status = ? AND starts_at <= ?", models.PriceScheduleKindChange, models.PriceScheduleStatusScheduled, time.Now().Unix()).
+		Order("starts_at ASC").Find(&schedules).Error; err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, due := range schedules {
+		skipped := false
+		if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+			var schedule models.ItemPriceSchedule
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("id = ? AND status = ?", due.ID, models.PriceScheduleStatusScheduled).
+				First(&schedule).Error; err != nil {
+				return err
+			}
+			item, err := inventory.LockItem(tx, schedule.ItemID)
+			if err != nil {
+				return err
+			}
+			if item.IsBundle {
+				// the item became a bundle after the change was scheduled,
+				// and a bundle's price follows its components
+				skipped = true
+				return tx.Model(&schedule).Update("status", models.PriceScheduleStatusCancelled).Error
+			}
+
+			gst := item.GST
+			if schedule.GST != nil {
+				gst = *schedule.GST
+			}
+			if err := RecordPriceChange(tx, item, schedule.Price, gst, "scheduled", &schedule.ID); err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Item{}).Where("id = ?", item.ID).Updates(map[string]interface{}{
+				"price": schedule.Price,
+				"gst":   gst,
+			}).Error; err != nil {
+				return err
+			}
+			if err := SyncBundlePrices(tx, item.ID); err != nil {
+				return err
+			}
+			return tx.Model(&schedule).Updates(map[string]interface{}{
+				"status":     models.PriceScheduleStatusApplied,
+				"applied_at": time.Now().Unix(),
+			}).Error
+		}); errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		} else if err != nil {
+			log.Println("Failed to apply price schedule:", due.ID, err)
+			continue
+		}
+		if !skipped {
+			applied++
+		}
+	}
+	return applied, nil
+}
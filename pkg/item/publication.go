@@ -0,0 +1,170 @@
+package item
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Published limits a query to items visible on the storefront.
+func Published(db *gorm.DB) *gorm.DB {
+	return db.Where("status = ?", models.ItemStatusPublished)
+}
+
+func GetPublishedItemBySlug(c *fiber.Ctx) error {
+	var item models.Item
+	slug := strings.ReplaceAll(c.Params("slug"), "%26", "&")
+
+	if err := db.GetDB().Model(&models.Item{}).Preload("Details").Preload("Images", orderImages).Scopes(Published).Where("slug = ?", slug).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	items := []models.Item{item}
+	if err := WithSalePrices(items); err != nil {
+		return views.InternalServerError(c, err)
+	}
+	if err := withVariants(items, true); err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, items[0])
+}
+
+// PublishItem publishes a draft right away, or schedules it when publish_at
+// is in the future.
+func PublishItem(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var req schemas.PublishItemRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return views.InvalidParams(c)
+		}
+	}
+
+	var item models.Item
+	if err := db.GetDB().Where("id = ?", id).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	if item.Status == models.ItemStatusPublished {
+		return views.BadRequestWithMessage(c, "item is already published")
+	}
+
+	now := time.Now().Unix()
+	updates := map[string]interface{}{}
+	if req.PublishAt != nil && int64(*req.PublishAt) > now {
+		updates["status"] = models.ItemStatusDraft
+		updates["publish_at"] = *req.PublishAt
+	} else {
+		updates["status"] = models.ItemStatusPublished
+		updates["publish_at"] = nil
+		updates["published_at"] = now
+	}
+	updates["archived_at"] = 0
+
+	if err := db.GetDB().Model(&models.Item{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	if updates["status"] == models.ItemStatusDraft {
+		return views.StatusOK(c, "item publish scheduled")
+	}
+	return views.StatusOK(c, "item published")
+}
+
+// UnpublishItem takes an item off the storefront and back to draft, dropping
+// any pending schedule.
+func UnpublishItem(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	result := db.GetDB().Model(&models.Item{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":      models.ItemStatusDraft,
+		"publish_at":  nil,
+		"archived_at": 0,
+	})
+	if result.Error != nil {
+		return views.InternalServerError(c, result.Error)
+	} else if result.RowsAffected == 0 {
+		return views.RecordNotFound(c)
+	}
+
+	return views.StatusOK(c, "item unpublished")
+}
+
+func ArchiveItem(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	result := db.GetDB().Model(&models.Item{}).Where("id = ? AND status <> ?", id, models.ItemStatusArchived).Updates(map[string]interface{}{
+		"status":      models.ItemStatusArchived,
+		"publish_at":  nil,
+		"archived_at": time.Now().Unix(),
+	})
+	if result.Error != nil {
+		return views.InternalServerError(c, result.Error)
+	} else if result.RowsAffected == 0 {
+		return views.RecordNotFound(c)
+	}
+
+	return views.StatusOK(c, "item archived")
+}
+
+// PublishScheduledItems publishes every draft whose publish_at has passed.
+func PublishScheduledItems() (int64, error) {
+	now := time.Now().Unix()
+	result := db.GetDB().Model(&models.Item{}).
+		Where("status = ? AND publish_at IS NOT NULL AND publish_at <= ?", models.ItemStatusDraft, now).
+		Updates(map[string]interface{}{
+			"status":       models.ItemStatusPublished,
+			"published_at": gorm.Expr("publish_at"),
+			"publish_at":   nil,
+		})
+	return result.RowsAffected, result.Error
+}
+
+// StartScheduler runs the item background jobs (scheduled publishing and
+// scheduled price changes) every interval for the lifetime of the process.
+func StartScheduler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			published, err := PublishScheduledItems()
+			if err != nil {
+				log.Println("Scheduled publish failed:", err)
+			} else if published > 0 {
+				log.Println("Published scheduled items:", published)
+			}
+
+			applied, err := ApplyScheduledPriceChanges()
+			if err != nil {
+				log.Println("Scheduled price changes failed:", err)
+			} else if applied > 0 {
+				log.Println("Applied scheduled price changes:", applied)
+			}
+		}
+	}()
+}
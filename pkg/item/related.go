@@ -0,0 +1,288 @@
+package item
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/constants"
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func GetRelatedItems(c *fiber.Ctx) error {
+	var related []models.RelatedItem
+	if err := db.GetDB().Preload("RelatedItem").Where("item_id = ?", c.Params("id")).
+		Order("position ASC").Find(&related).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, related)
+}
+
+// SetRelatedItems replaces the curated related items of an item, keeping the
+// order they are given in.
+func SetRelatedItems(c *fiber.Ctx) error {
+	item_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var req schemas.SetRelatedItemsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+
+	if err := db.GetDB().Where("id = ?", item_id).First(&models.Item{}).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	var related []models.RelatedItem
+	seen := map[uuid.UUID]bool{}
+	for position, id := range req.ItemIDs {
+		related_item_id, err := uuid.Parse(id)
+		if err != nil {
+			return views.BadRequest(c)
+		}
+		if related_item_id == item_id || seen[related_item_id] {
+			return views.BadRequestWithMessage(c, "related items must be distinct items other than the item")
+		}
+		seen[related_item_id] = true
+		related = append(related, models.RelatedItem{
+			ItemID:        item_id,
+			RelatedItemID: related_item_id,
+			Position:      position,
+		})
+	}
+
+	if len(related) > 0 {
+		var count int64
+		if err := db.GetDB().Model(&models.Item{}).Where("id IN ?", keys(seen)).Count(&count).Error; err != nil {
+			return views.InternalServerError(c, err)
+		}
+		if int(count) != len(related) {
+			return views.RecordNotFound(c)
+		}
+	}
+
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("item_id = ?", item_id).Delete(&models.RelatedItem{}).Error; err != nil {
+			return err
+		}
+		if len(related) == 0 {
+			return nil
+		}
+		return tx.Create(&related).Error
+	}); err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	return views.StatusOK(c, "related items updated")
+}
+
+// GetItemRecommendations returns what to show next to a published item: the
+// curated related items, then items from the same category, items of the
+// same ruler or a nearby year, and items frequently bought together with it.
+// An item only appears in the first section it qualifies for.
+func GetItemRecommendations(c *fiber.Ctx) error {
+	limit, err := strconv.Atoi(c.Query("limit", "8"))
+	if err != nil || limit < 1 {
+		return views.BadRequest(c)
+	}
+	limit = min(limit, constants.RECOMMENDATION_MAX_LIMIT)
+
+	var item models.Item
+	if err := db.GetDB().Preload("Details").Scopes(Published).Where("id = ?", c.Params("id")).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	// never recommend the item itself or another grade of it
+	exclude := map[uuid.UUID]bool{item.ID: true}
+	familyID := item.ID
+	if item.ParentID != nil {
+		familyID = *item.ParentID
+		exclude[familyID] = true
+	}
+	var familyIDs []uuid.UUID
+	if err := db.GetDB().Model(&models.Item{}).Where("parent_id = ?", familyID).Pluck("id", &familyIDs).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	for _, id := range familyIDs {
+		exclude[id] = true
+	}
+
+	related, err := curatedRecommendations(item.ID, exclude, limit)
+	if err != nil {
+		return views.InternalServerError(c, err)
+	}
+	sameCategory, err := recommend(db.GetDB().Where("category_id = ?", item.CategoryID).Order("sold DESC"), exclude, limit)
+	if err != nil {
+		return views.InternalServerError(c, err)
+	}
+	similar, err := similarRecommendations(&item, exclude, limit)
+	if err != nil {
+		return views.InternalServerError(c, err)
+	}
+	boughtTogether, err := boughtTogetherRecommendations(item.ID, exclude, limit)
+	if err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	return views.StatusOK(c, fiber.Map{
+		"item_id":         item.ID,
+		"related":         related,
+		"same_category":   sameCategory,
+		"similar":         similar,
+		"bought_together": boughtTogether,
+	})
+}
+
+func curatedRecommendations(item_id uuid.UUID, exclude map[uuid.UUID]bool, limit int) ([]models.Item, error) {
+	var relatedIDs []uuid.UUID
+	if err := db.GetDB().Model(&models.RelatedItem{}).Where("item_id = ?", item_id).
+		Order("position ASC").Pluck("related_item_id", &relatedIDs).Error; err != nil {
+		return nil, err
+	}
+	if len(relatedIDs) == 0 {
+		return []models.Item{}, nil
+	}
+
+	items, err := recommendable(db.GetDB().Where("id IN ?", relatedIDs), exclude, len(relatedIDs))
+	if err != nil {
+		return nil, err
+	}
+	items = orderByIDs(items, relatedIDs, limit)
+	excludeItems(exclude, items)
+	return items, nil
+}
+
+func similarRecommendations(item *models.Item, exclude map[uuid.UUID]bool, limit int) ([]models.Item, error) {
+	var rulers []string
+	for _, detail := range item.Details {
+		for _, attribute := range constants.RULER_ATTRIBUTES {
+			if strings.EqualFold(detail.Attribute, attribute) && detail.Value != "" {
+				rulers = append(rulers, strings.ToLower(detail.Value))
+			}
+		}
+	}
+	if len(rulers) == 0 && item.Year == 0 {
+		return []models.Item{}, nil
+	}
+
+	conditions := db.GetDB()
+	if len(rulers) > 0 {
+		conditions = conditions.Where("id IN (?)", db.GetDB().Model(&models.Detail{}).Select("item_id").
+			Where("LOWER(attribute) IN ? AND LOWER(value) IN ?", constants.RULER_ATTRIBUTES, rulers))
+	}
+	if item.Year != 0 {
+		yearRange := db.GetDB().Where("year BETWEEN ? AND ?", item.Year-constants.RECOMMENDATION_YEAR_RANGE, item.Year+constants.RECOMMENDATION_YEAR_RANGE)
+		if len(rulers) > 0 {
+			conditions = conditions.Or(yearRange)
+		} else {
+			conditions = yearRange
+		}
+	}
+
+	// closest years first
+	return recommend(db.GetDB().Where(conditions).Order(clause.OrderBy{
+		Expression: clause.Expr{SQL: "ABS(year - ?) ASC", Vars: []interface{}{item.Year}},
+	}), exclude, limit)
+}
+
+// boughtTogetherRecommendations ranks items by how many confirmed orders
+// they share with the item.
+func boughtTogetherRecommendations(item_id uuid.UUID, exclude map[uuid.UUID]bool, limit int) ([]models.Item, error) {
+	var itemIDs []uuid.UUID
+	if err := db.GetDB().Table("order_items AS other").
+		Select("other.item_id").
+		Joins("JOIN order_items AS this ON this.order_id = other.order_id AND this.item_id = ?", item_id).
+		Joins("JOIN orders ON orders.id = other.order_id").
+		Where("other.item_id <> ?", item_id).
+		Where("orders.status NOT IN ?", []string{"pending", "cancelled"}).
+		Where("other.order_item_status NOT IN ? AND this.order_item_status NOT IN ?",
+			[]string{"cancelled", "expired"}, []string{"cancelled", "expired"}).
+		Group("other.item_id").
+		Order("COUNT(DISTINCT other.order_id) DESC").
+		Limit(limit+len(exclude)).
+		Pluck("other.item_id", &itemIDs).Error; err != nil {
+		return nil, err
+	}
+	if len(itemIDs) == 0 {
+		return []models.Item{}, nil
+	}
+
+	items, err := recommendable(db.GetDB().Where("id IN ?", itemIDs), exclude, len(itemIDs))
+	if err != nil {
+		return nil, err
+	}
+	items = orderByIDs(items, itemIDs, limit)
+	excludeItems(exclude, items)
+	return items, nil
+}
+
+// recommend runs a query for published, in stock items not excluded yet, and
+// excludes the items it returns from later sections.
+func recommend(dbQuery *gorm.DB, exclude map[uuid.UUID]bool, limit int) ([]models.Item, error) {
+	items, err := recommendable(dbQuery, exclude, limit)
+	if err != nil {
+		return nil, err
+	}
+	excludeItems(exclude, items)
+	return items, nil
+}
+
+// recommendable runs a query for published, in stock items not excluded yet.
+// Unlike recommend it leaves exclude alone, for sections that pick their items
+// from what it returns.
+func recommendable(dbQuery *gorm.DB, exclude map[uuid.UUID]bool, limit int) ([]models.Item, error) {
+	items := []models.Item{}
+	if err := dbQuery.Preload("Images", orderImages).Scopes(Published).
+		Where("id NOT IN ?", keys(exclude)).
+		Where("stock > 0").
+		Limit(limit).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	if err := WithSalePrices(items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func excludeItems(exclude map[uuid.UUID]bool, items []models.Item) {
+	for _, item := range items {
+		exclude[item.ID] = true
+	}
+}
+
+func orderByIDs(items []models.Item, ids []uuid.UUID, limit int) []models.Item {
+	byID := map[uuid.UUID]models.Item{}
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+	ordered := []models.Item{}
+	for _, id := range ids {
+		if item, ok := byID[id]; ok && len(ordered) < limit {
+			ordered = append(ordered, item)
+		}
+	}
+	return ordered
+}
+
+func keys(set map[uuid.UUID]bool) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,158 @@
+package item
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/config"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrSKUTaken = errors.New("sku already exists")
+
+var skuSequenceToken = regexp.MustCompile(`\{SEQ(?::(\d+))?\}`)
+
+// maxSKUAttempts bounds how many sequence numbers are skipped when they
+// collide with SKUs that were entered by hand.
+const maxSKUAttempts = 100
+
+// GenerateSKU renders config.SKU_PATTERN for an item of the category and
+// year with the next free sequence number. Sequences are kept per prefix and
+// year, and numbers whose SKU already exists are skipped.
+func GenerateSKU(tx *gorm.DB, category_id uuid.UUID, year int) (string, error) {
+	prefix, err := categorySKUPrefix(tx, category_id)
+	if err != nil {
+		return "", err
+	}
+	if year == 0 {
+		year = time.Now().Year()
+	}
+	scope := prefix + "-" + strconv.Itoa(year)
+
+	for attempt := 0; attempt < maxSKUAttempts; attempt++ {
+		sequence, err := nextSKUSequence(tx, scope)
+		if err != nil {
+			return "", err
+		}
+		sku := renderSKU(prefix, year, sequence)
+		taken, err := SKUTaken(tx, sku, nil)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return sku, nil
+		}
+	}
+	return "", fmt.Errorf("no free sku for %s after %d attempts", scope, maxSKUAttempts)
+}
+
+// SKUTaken reports whether another item already uses sku.
+func SKUTaken(tx *gorm.DB, sku string, exclude_id *uuid.UUID) (bool, error) {
+	dbQuery := tx.Model(&models.Item{}).Where("sku = ?", sku)
+	if exclude_id != nil {
+		dbQuery = dbQuery.Where("id <> ?", *exclude_id)
+	}
+	var count int64
+	if err := dbQuery.Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func nextSKUSequence(tx *gorm.DB, scope string) (int, error) {
+	sequence := models.SKUSequence{Scope: scope, Value: 1}
+	if err := tx.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "scope"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"value": gorm.Expr("sku_sequences.value + 1")}),
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "value"}}},
+	).Create(&sequence).Error; err != nil {
+		return 0, err
+	}
+	return sequence.Value, nil
+}
+
+func renderSKU(prefix string, year int, sequence int) string {
+	sku := strings.ReplaceAll(config.SKU_PATTERN, "{PREFIX}", prefix)
+	sku = strings.ReplaceAll(sku, "{YEAR}", strconv.Itoa(year))
+	return skuSequenceToken.ReplaceAllStringFunc(sku, func(token string) string {
+		width := skuSequenceToken.FindStringSubmatch(token)[1]
+		if width == "" {
+			return strconv.Itoa(sequence)
+		}
+		return fmt.Sprintf("%0"+width+"d", sequence)
+	})
+}
+
+func categorySKUPrefix(tx *gorm.DB, category_id uuid.UUID) (string, error) {
+	var category models.Category
+	if err := tx.Where("id = ?", category_id).First(&category).Error; err != nil {
+		return "", err
+	}
+	if category.SKUPrefix != "" {
+		return category.SKUPrefix, nil
+	}
+
+	// first letters of the category name, e.g. "British India" -> "BRI"
+	var prefix []rune
+	for _, r := range strings.ToUpper(category.Name) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			prefix = append(prefix, r)
+		}
+		if len(prefix) == 3 {
+			break
+		}
+	}
+	if len(prefix) == 0 {
+		return "ITM", nil
+	}
+	return string(prefix), nil
+}
+
+// PreviewSKU shows the SKU the next item of a category would get, without
+// using up a sequence number.
+func PreviewSKU(c *fiber.Ctx) error {
+	category_id, err := uuid.Parse(c.Query("category_id"))
+	if err != nil {
+		return views.BadRequestWithMessage(c, "category id required")
+	}
+	year, err := strconv.Atoi(c.Query("year", strconv.Itoa(time.Now().Year())))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var sku string
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		generated, err := GenerateSKU(tx, category_id, year)
+		if err != nil {
+			return err
+		}
+		sku = generated
+		// roll back so the preview does not consume the sequence
+		return errSKUPreview
+	}); err != nil && !errors.Is(err, errSKUPreview) {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	return views.StatusOK(c, fiber.Map{
+		"sku":     sku,
+		"pattern": config.SKU_PATTERN,
+	})
+}
+
+var errSKUPreview = errors.New("sku preview")
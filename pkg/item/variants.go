@@ -0,0 +1,320 @@
+package item
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/inventory"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrVariantRequired = errors.New("item has variants, a variant must be selected")
+	ErrVariantNotFound = errors.New("variant not found")
+)
+
+// ResolveVariant returns the item an order line is for: the selected variant
+// when item is a parent product, item itself otherwise. Parents with variants
+// cannot be ordered without picking one.
+func ResolveVariant(tx *gorm.DB, item *models.Item, variant_id string, grade string) (*models.Item, error) {
+	if variant_id == "" && grade == "" {
+		var variantCount int64
+		if err := tx.Model(&models.Item{}).Where("parent_id = ?", item.ID).Count(&variantCount).Error; err != nil {
+			return nil, err
+		}
+		if variantCount > 0 {
+			return nil, ErrVariantRequired
+		}
+		return item, nil
+	}
+
+	dbQuery := tx.Where("parent_id = ?", item.ID)
+	if variant_id != "" {
+		parsedVariantID, err := uuid.Parse(variant_id)
+		if err != nil {
+			return nil, ErrVariantNotFound
+		}
+		dbQuery = dbQuery.Where("id = ?", parsedVariantID)
+	} else {
+		dbQuery = dbQuery.Where("LOWER(grade) = LOWER(?)", grade)
+	}
+
+	var variant models.Item
+	if err := dbQuery.First(&variant).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrVariantNotFound
+		}
+		return nil, err
+	}
+	return &variant, nil
+}
+
+// withVariants attaches the variants of every parent product in items, with
+// their sale prices, and summarises them in PriceRange.
+func withVariants(items []models.Item, publishedOnly bool) error {
+	if len(items) == 0 {
+		return nil
+	}
+	parentIDs := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		parentIDs[i] = item.ID
+	}
+
+	dbQuery := db.GetDB().Preload("Images", orderImages).Where("parent_id IN ?", parentIDs)
+	if publishedOnly {
+		dbQuery = dbQuery.Scopes(Published)
+	}
+	var variants []models.Item
+	if err := dbQuery.Order("price ASC").Find(&variants).Error; err != nil {
+		return err
+	}
+	if err := WithSalePrices(variants); err != nil {
+		return err
+	}
+
+	byParent := map[uuid.UUID][]models.Item{}
+	for _, variant := range variants {
+		byParent[*variant.ParentID] = append(byParent[*variant.ParentID], variant)
+	}
+
+	for i := range items {
+		itemVariants := byParent[items[i].ID]
+		if len(itemVariants) == 0 {
+			continue
+		}
+		priceRange := models.PriceRange{Min: math.MaxInt64}
+		for _, variant := range itemVariants {
+			price := variant.Price
+			if variant.SalePrice != nil {
+				price = *variant.SalePrice
+			}
+			priceRange.Min = money.Min(priceRange.Min, price)
+			priceRange.Max = money.Max(priceRange.Max, price)
+			priceRange.Stock += variant.Stock
+		}
+		items[i].Variants = itemVariants
+		items[i].PriceRange = &priceRange
+	}
+	return nil
+}
+
+func GetItemVariants(c *fiber.Ctx) error {
+	var variants []models.Item
+	if err := db.GetDB().Preload("Images", orderImages).Where("parent_id = ?", c.Params("id")).
+		Order("price ASC").Find(&variants).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	if err := WithSalePrices(variants); err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, variants)
+}
+
+// CreateItemVariant adds a grade of a parent product as its own item, with
+// its own SKU, price and stock.
+func CreateItemVariant(c *fiber.Ctx) error {
+	parent_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var req schemas.CreateItemVariantRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	var parent models.Item
+	if err := db.GetDB().Where("id = ?", parent_id).First(&parent).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+	if parent.ParentID != nil {
+		return views.BadRequestWithMessage(c, "variants cannot have variants")
+	}
+	if parent.IsBundle || parent.IsSerialised {
+		return views.BadRequestWithMessage(c, "bundles and serialised items cannot have variants")
+	}
+
+	grade := strings.TrimSpace(req.Grade)
+	if taken, err := gradeTaken(db.GetDB(), parent_id, grade, nil); err != nil {
+		return views.InternalServerError(c, err)
+	} else if taken {
+		return views.ConflictWithMessage(c, "parent already has a variant of this grade")
+	}
+
+	variant := models.Item{
+		CategoryID:  parent.CategoryID,
+		ParentID:    &parent.ID,
+		Grade:       grade,
+		Name:        parent.Name + " (" + grade + ")",
+		Description: parent.Description,
+		Year:        parent.Year,
+		SKU:         req.SKU,
+		ImageURL:    req.ImageURL,
+		Stock:       req.Stock,
+		Price:       req.Price,
+		GST:         parent.GST,
+		HSN:         parent.HSN,
+		Slug:        parent.Slug + "-" + utils.GenerateItemSlug(grade),
+		Status:      parent.Status,
+		PublishAt:   parent.PublishAt,
+		PublishedAt: parent.PublishedAt,
+	}
+	if req.Description != "" {
+		variant.Description = req.Description
+	}
+	if req.GST != nil {
+		variant.GST = *req.GST
+	}
+	for _, detail := range req.Details {
+		variant.Details = append(variant.Details, models.Detail{
+			Attribute: detail.Attribute,
+			Value:     detail.Value,
+		})
+	}
+
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if variant.SKU == "" {
+			sku, err := GenerateSKU(tx, variant.CategoryID, variant.Year)
+			if err != nil {
+				return err
+			}
+			variant.SKU = sku
+		} else if taken, err := SKUTaken(tx, variant.SKU, nil); err != nil {
+			return err
+		} else if taken {
+			return ErrSKUTaken
+		}
+
+		if err := tx.Create(&variant).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.ItemPriceHistory{
+			ItemID:      variant.ID,
+			Price:       variant.Price,
+			GST:         variant.GST,
+			Source:      "create",
+			EffectiveAt: int(time.Now().Unix()),
+		}).Error; err != nil {
+			return err
+		}
+		return inventory.RecordOpeningStock(tx, &variant, "")
+	}); err != nil {
+		if errors.Is(err, ErrSKUTaken) {
+			return views.ConflictWithMessage(c, err.Error())
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	return views.ObjectCreated(c, variant)
+}
+
+// SetItemParent groups an existing item under a parent product as one of its
+// variants, or detaches it again when parent_id is empty.
+func SetItemParent(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var req schemas.SetItemParentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	var item models.Item
+	if err := db.GetDB().Where("id = ?", id).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	if req.ParentID == "" {
+		if err := db.GetDB().Model(&item).Updates(map[string]interface{}{
+			"parent_id": nil,
+			"grade":     "",
+		}).Error; err != nil {
+			return views.InternalServerError(c, err)
+		}
+		return views.StatusOK(c, "variant detached")
+	}
+
+	parent_id, err := uuid.Parse(req.ParentID)
+	if err != nil {
+		return views.BadRequest(c)
+	}
+	grade := strings.TrimSpace(req.Grade)
+	if grade == "" {
+		return views.BadRequestWithMessage(c, "grade required")
+	}
+	if parent_id == id {
+		return views.BadRequestWithMessage(c, "item cannot be its own parent")
+	}
+
+	var variantCount int64
+	if err := db.GetDB().Model(&models.Item{}).Where("parent_id = ?", id).Count(&variantCount).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	if variantCount > 0 {
+		return views.BadRequestWithMessage(c, "item has variants of its own")
+	}
+
+	var parent models.Item
+	if err := db.GetDB().Where("id = ?", parent_id).First(&parent).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+	if parent.ParentID != nil {
+		return views.BadRequestWithMessage(c, "variants cannot have variants")
+	}
+	if parent.IsBundle || parent.IsSerialised {
+		return views.BadRequestWithMessage(c, "bundles and serialised items cannot have variants")
+	}
+
+	if taken, err := gradeTaken(db.GetDB(), parent_id, grade, &id); err != nil {
+		return views.InternalServerError(c, err)
+	} else if taken {
+		return views.ConflictWithMessage(c, "parent already has a variant of this grade")
+	}
+
+	if err := db.GetDB().Model(&item).Updates(map[string]interface{}{
+		"parent_id": parent_id,
+		"grade":     grade,
+	}).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, "variant attached")
+}
+
+func gradeTaken(tx *gorm.DB, parent_id uuid.UUID, grade string, exclude_id *uuid.UUID) (bool, error) {
+	dbQuery := tx.Model(&models.Item{}).Where("parent_id = ? AND LOWER(grade) = LOWER(?)", parent_id, grade)
+	if exclude_id != nil {
+		dbQuery = dbQuery.Where("id <> ?", *exclude_id)
+	}
+	var count int64
+	if err := dbQuery.Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
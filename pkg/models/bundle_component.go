@@ -0,0 +1,15 @@
+package models
+
+import "github.com/google/uuid"
+
+// BundleComponent is one of the items a bundle (a year set or mint set) is
+// made of, with how many of it go into one bundle.
+type BundleComponent struct {
+	ID          uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	BundleID    uuid.UUID `gorm:"uniqueIndex:idx_bundle_component;type:uuid;not null" json:"bundle_id"`
+	ComponentID uuid.UUID `gorm:"uniqueIndex:idx_bundle_component;index;type:uuid;not null" json:"component_id"`
+	Component   *Item     `gorm:"foreignKey:ComponentID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;" json:"component,omitempty"`
+	Quantity    int       `gorm:"not null;default:1" json:"quantity"`
+	CreatedAt   int       `json:"created_at"`
+	UpdatedAt   int       `json:"updated_at"`
+}
@@ -8,6 +8,7 @@ type Category struct {
 	Description      string     `gorm:"type:text" json:"description"`
 	ParentCategoryID *uuid.UUID `gorm:"type:uuid" json:"parent_category_id"` // Nullable to allow root categories
 	Slug             string     `gorm:"type:text" json:"slug"`
+	SKUPrefix        string     `gorm:"size:10" json:"sku_prefix"` // used by generated SKUs, derived from the name when empty
 	Items            []Item     `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"items"`
 	CreatedAt        int        `json:"created_at"`
 	UpdatedAt        int        `json:"updated_at"`
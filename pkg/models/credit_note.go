@@ -0,0 +1,46 @@
+package models
+
+import (
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/google/uuid"
+)
+
+// CreditNote is the tax document issued for a refund, reducing the value of
+// the original supply. Numbers run in their own series per financial year.
+type CreditNote struct {
+	ID           uuid.UUID        `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Number       string           `gorm:"type:varchar(30);uniqueIndex;not null" json:"number"`
+	OrderID      uuid.UUID        `gorm:"index;type:uuid;not null" json:"order_id"`
+	RefundID     uuid.UUID        `gorm:"uniqueIndex;type:uuid;not null" json:"refund_id"`
+	Reason       string           `gorm:"type:varchar(20)" json:"reason"`
+	SellerName   string           `json:"seller_name"`
+	SellerGSTIN  string           `gorm:"type:varchar(15)" json:"seller_gstin"`
+	SellerState  string           `json:"seller_state"`
+	BuyerName    string           `json:"buyer_name"`
+	BuyerAddress string           `gorm:"type:text" json:"buyer_address"`
+	BuyerState   string           `json:"buyer_state"` // place of supply
+	Currency     money.Currency   `gorm:"type:varchar(3);not null;default:'INR'" json:"currency"`
+	TaxableValue money.Amount     `gorm:"type:bigint" json:"taxable_value"`
+	CGST         money.Amount     `gorm:"type:bigint" json:"cgst"`
+	SGST         money.Amount     `gorm:"type:bigint" json:"sgst"`
+	IGST         money.Amount     `gorm:"type:bigint" json:"igst"`
+	Total        money.Amount     `gorm:"type:bigint" json:"total"`
+	Lines        []CreditNoteLine `gorm:"foreignKey:CreditNoteID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"lines"`
+	IssuedAt     int              `gorm:"index" json:"issued_at"`
+	CreatedAt    int              `json:"created_at"`
+}
+
+type CreditNoteLine struct {
+	ID           uuid.UUID    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	CreditNoteID uuid.UUID    `gorm:"index;type:uuid;not null" json:"credit_note_id"`
+	OrderItemID  *uuid.UUID   `gorm:"type:uuid" json:"order_item_id"`
+	Description  string       `json:"description"`
+	SKU          string       `json:"sku"`
+	HSN          string       `gorm:"size:8" json:"hsn"`
+	GSTRate      float64      `gorm:"type:decimal(5,2)" json:"gst_rate"`
+	TaxableValue money.Amount `gorm:"type:bigint" json:"taxable_value"`
+	CGST         money.Amount `gorm:"type:bigint" json:"cgst"`
+	SGST         money.Amount `gorm:"type:bigint" json:"sgst"`
+	IGST         money.Amount `gorm:"type:bigint" json:"igst"`
+	Total        money.Amount `gorm:"type:bigint" json:"total"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// DocumentSequence holds the last number handed out in a series of tax
+// documents, such as credit notes of one financial year.
+type DocumentSequence struct {
+	Series    string `gorm:"primaryKey;size:50" json:"series"`
+	Value     int    `gorm:"not null;default:0" json:"value"`
+	UpdatedAt int    `json:"updated_at"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/google/uuid"
+)
+
+const (
+	UnitStatusAvailable = "available"
+	UnitStatusReserved  = "reserved"
+	UnitStatusSold      = "sold"
+)
+
+// InventoryUnit is a single physical coin of a serialised item.
+type InventoryUnit struct {
+	ID                uuid.UUID    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ItemID            uuid.UUID    `gorm:"index;type:uuid" json:"item_id"`
+	SerialNumber      string       `gorm:"size:100;not null;uniqueIndex" json:"serial_number"`
+	CertificateNumber string       `gorm:"size:100" json:"certificate_number"`
+	Grade             string       `gorm:"size:50" json:"grade"`
+	AcquisitionCost   money.Amount `gorm:"type:bigint;not null;default:0" json:"acquisition_cost"`
+	AcquiredAt        int          `json:"acquired_at"`
+	Location          string       `gorm:"size:255" json:"location"`
+	Status            string       `gorm:"type:varchar(20);default:'available';index" json:"status"` // available, reserved, sold
+	OrderItemID       *uuid.UUID   `gorm:"index;type:uuid" json:"order_item_id"`
+	Notes             string       `gorm:"type:text" json:"notes"`
+	CreatedAt         int          `json:"created_at"`
+	UpdatedAt         int          `json:"updated_at"`
+}
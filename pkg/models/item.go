@@ -1,21 +1,53 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/google/uuid"
+)
+
+const (
+	ItemStatusDraft     = "draft"
+	ItemStatusPublished = "published"
+	ItemStatusArchived  = "archived"
+)
 
 type Item struct {
-	ID          uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
-	CategoryID  uuid.UUID `gorm:"index;type:uuid" json:"category_id"`
-	Name        string    `gorm:"size:255;not null" json:"name"`
-	Description string    `gorm:"type:text" json:"description"`
-	Year        int       `json:"year"`
-	SKU         string    `gorm:"size:100;not null;unique" json:"sku"`
-	ImageURL    string    `gorm:"size:512" json:"image_url"`
-	Stock       int       `gorm:"not null;default:0" json:"stock"`
-	Sold        int       `gorm:"not null;default:0" json:"sold"`
-	Price       float64   `gorm:"not null" json:"price"`
-	GST         float64   `gorm:"not null" json:"gst"`
-	Details     []Detail  `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"details"`
-	Slug        string    `gorm:"not null" json:"slug"`
-	CreatedAt   int       `json:"created_at"`
-	UpdatedAt   int       `json:"updated_at"`
+	ID               uuid.UUID         `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	CategoryID       uuid.UUID         `gorm:"index;type:uuid" json:"category_id"`
+	Name             string            `gorm:"size:255;not null" json:"name"`
+	Description      string            `gorm:"type:text" json:"description"`
+	Year             int               `json:"year"`
+	SKU              string            `gorm:"size:100;not null;unique" json:"sku"`
+	ImageURL         string            `gorm:"size:512" json:"image_url"`
+	Stock            int               `gorm:"not null;default:0" json:"stock"` // count of available units for serialised items, complete sets for bundles
+	IsSerialised     bool              `gorm:"not null;default:false" json:"is_serialised"`
+	IsBundle         bool              `gorm:"not null;default:false" json:"is_bundle"`
+	BundleDiscount   float64           `gorm:"not null;default:0" json:"bundle_discount"` // percent off the components' total
+	Components       []BundleComponent `gorm:"foreignKey:BundleID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"components,omitempty"`
+	Sold             int               `gorm:"not null;default:0" json:"sold"`
+	ReorderThreshold int               `gorm:"not null;default:0" json:"reorder_threshold"` // 0 disables low-stock alerts
+	Price            money.Amount      `gorm:"type:bigint;not null" json:"price"`
+	GST              float64           `gorm:"not null" json:"gst"`
+	HSN              string            `gorm:"size:8" json:"hsn"` // HSN code printed on tax invoices
+	Details          []Detail          `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"details"`
+	Images           []ItemImage       `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"images"`
+	ParentID         *uuid.UUID        `gorm:"uniqueIndex:idx_item_parent_grade;type:uuid" json:"parent_id"` // set on the variants of a parent product
+	Grade            string            `gorm:"uniqueIndex:idx_item_parent_grade;size:50" json:"grade"`       // condition of a variant, e.g. VF, XF, UNC
+	Variants         []Item            `gorm:"foreignKey:ParentID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"variants,omitempty"`
+	PriceRange       *PriceRange       `gorm:"-" json:"price_range,omitempty"` // set on parent products from their variants
+	Slug             string            `gorm:"not null" json:"slug"`
+	Status           string            `gorm:"type:varchar(20);not null;default:'published';index" json:"status"` // draft, published, archived
+	PublishAt        *int              `json:"publish_at"`                                                        // scheduled publish time for drafts
+	PublishedAt      int               `json:"published_at"`
+	ArchivedAt       int               `json:"archived_at"`
+	SalePrice        *money.Amount     `gorm:"-" json:"sale_price,omitempty"` // set from the active sale, if any
+	CreatedAt        int               `json:"created_at"`
+	UpdatedAt        int               `json:"updated_at"`
+}
+
+// PriceRange summarises the variants of a parent product for listings.
+type PriceRange struct {
+	Min   money.Amount `json:"min"`
+	Max   money.Amount `json:"max"`
+	Stock int          `json:"stock"`
 }
@@ -0,0 +1,21 @@
+package models
+
+import "github.com/google/uuid"
+
+type ItemImage struct {
+	ID           uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ItemID       uuid.UUID `gorm:"index;type:uuid" json:"item_id"`
+	Kind         string    `gorm:"type:varchar(20);not null" json:"kind"` // obverse, reverse, edge, certificate
+	Position     int       `gorm:"not null;default:0" json:"position"`
+	ContentType  string    `gorm:"size:100" json:"content_type"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	OriginalKey  string    `gorm:"size:512" json:"-"`
+	MediumKey    string    `gorm:"size:512" json:"-"`
+	ThumbnailKey string    `gorm:"size:512" json:"-"`
+	OriginalURL  string    `gorm:"size:512" json:"original_url"`
+	MediumURL    string    `gorm:"size:512" json:"medium_url"`
+	ThumbnailURL string    `gorm:"size:512" json:"thumbnail_url"`
+	CreatedAt    int       `json:"created_at"`
+	UpdatedAt    int       `json:"updated_at"`
+}
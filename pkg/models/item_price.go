@@ -0,0 +1,47 @@
+package models
+
+import (
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/google/uuid"
+)
+
+const (
+	PriceScheduleKindChange = "price_change"
+	PriceScheduleKindSale   = "sale"
+
+	PriceScheduleStatusScheduled = "scheduled"
+	PriceScheduleStatusApplied   = "applied"
+	PriceScheduleStatusCancelled = "cancelled"
+)
+
+// ItemPriceHistory is an append-only record of every change to an item's
+// price or GST rate.
+type ItemPriceHistory struct {
+	ID            uuid.UUID    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ItemID        uuid.UUID    `gorm:"index;type:uuid" json:"item_id"`
+	PreviousPrice money.Amount `gorm:"type:bigint" json:"previous_price"`
+	PreviousGST   float64      `json:"previous_gst"`
+	Price         money.Amount `gorm:"type:bigint;not null" json:"price"`
+	GST           float64      `gorm:"not null" json:"gst"`
+	Source        string       `gorm:"type:varchar(20)" json:"source"` // create, manual, scheduled, import
+	ScheduleID    *uuid.UUID   `gorm:"type:uuid" json:"schedule_id"`
+	EffectiveAt   int          `gorm:"index" json:"effective_at"`
+	CreatedAt     int          `json:"created_at"`
+}
+
+// ItemPriceSchedule is either a future price change, applied to the item once
+// StartsAt passes, or a sale price that overrides the item price between
+// StartsAt and EndsAt without touching it.
+type ItemPriceSchedule struct {
+	ID        uuid.UUID    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ItemID    uuid.UUID    `gorm:"index;type:uuid" json:"item_id"`
+	Kind      string       `gorm:"type:varchar(20);not null" json:"kind"` // price_change, sale
+	Price     money.Amount `gorm:"type:bigint;not null" json:"price"`
+	GST       *float64     `json:"gst"` // price_change only, keeps the current rate when nil
+	StartsAt  int          `gorm:"not null;index" json:"starts_at"`
+	EndsAt    *int         `json:"ends_at"`                                            // sale only
+	Status    string       `gorm:"type:varchar(20);default:'scheduled'" json:"status"` // scheduled, applied, cancelled
+	AppliedAt int          `json:"applied_at"`
+	CreatedAt int          `json:"created_at"`
+	UpdatedAt int          `json:"updated_at"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import "github.com/google/uuid"
+
+const (
+	TransferStatusInTransit = "in_transit"
+	TransferStatusReceived  = "received"
+	TransferStatusCancelled = "cancelled"
+)
+
+type Location struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Name      string    `gorm:"size:255;not null" json:"name"`
+	Code      string    `gorm:"size:50;not null;uniqueIndex" json:"code"`
+	Kind      string    `gorm:"type:varchar(20);not null" json:"kind"` // shop, vault, warehouse
+	Address   string    `gorm:"type:text" json:"address"`
+	IsActive  bool      `gorm:"not null;default:true" json:"is_active"`
+	CreatedAt int       `json:"created_at"`
+	UpdatedAt int       `json:"updated_at"`
+}
+
+// StockLevel is the physical quantity of an item on hand at a location.
+// Items without any stock level are not tracked per location.
+type StockLevel struct {
+	ID         uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ItemID     uuid.UUID `gorm:"uniqueIndex:idx_stock_level_item_location;type:uuid;not null" json:"item_id"`
+	LocationID uuid.UUID `gorm:"uniqueIndex:idx_stock_level_item_location;type:uuid;not null" json:"location_id"`
+	Location   *Location `gorm:"constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;" json:"location,omitempty"`
+	Quantity   int       `gorm:"not null;default:0" json:"quantity"`
+	CreatedAt  int       `json:"created_at"`
+	UpdatedAt  int       `json:"updated_at"`
+}
+
+// StockTransfer moves stock between two locations. The quantity leaves the
+// source when the transfer is created and reaches the destination once it is
+// received.
+type StockTransfer struct {
+	ID             uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ItemID         uuid.UUID  `gorm:"index;type:uuid;not null" json:"item_id"`
+	FromLocationID uuid.UUID  `gorm:"type:uuid;not null" json:"from_location_id"`
+	ToLocationID   uuid.UUID  `gorm:"type:uuid;not null" json:"to_location_id"`
+	Quantity       int        `gorm:"not null" json:"quantity"`
+	Status         string     `gorm:"type:varchar(20);default:'in_transit';index" json:"status"` // in_transit, received, cancelled
+	ActorID        *uuid.UUID `gorm:"type:uuid" json:"actor_id"`
+	Note           string     `gorm:"type:text" json:"note"`
+	ReceivedAt     int        `json:"received_at"`
+	CreatedAt      int        `json:"created_at"`
+	UpdatedAt      int        `json:"updated_at"`
+}
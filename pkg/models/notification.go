@@ -0,0 +1,25 @@
+package models
+
+import "github.com/google/uuid"
+
+const (
+	NotificationStatusQueued = "queued"
+	NotificationStatusSent   = "sent"
+	NotificationStatusFailed = "failed"
+)
+
+// Notification is an outgoing message waiting in the outbox until the
+// dispatcher hands it to the configured notifier.
+type Notification struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Channel   string    `gorm:"type:varchar(20);default:'email'" json:"channel"`
+	Recipient string    `gorm:"size:255;not null" json:"recipient"`
+	Subject   string    `gorm:"size:255" json:"subject"`
+	Body      string    `gorm:"type:text" json:"body"`
+	Status    string    `gorm:"type:varchar(20);default:'queued';index" json:"status"` // queued, sent, failed
+	Attempts  int       `gorm:"default:0" json:"attempts"`
+	LastError string    `gorm:"type:text" json:"last_error"`
+	SentAt    int       `json:"sent_at"`
+	CreatedAt int       `json:"created_at"`
+	UpdatedAt int       `json:"updated_at"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+const (
+	OrderEventTransition      = "transition"
+	OrderEventItemAdded       = "item_added"
+	OrderEventQuantityChanged = "quantity_changed"
+	OrderEventItemRemoved     = "item_removed"
+	OrderEventItemExpired     = "item_expired"
+	OrderEventItemCancelled   = "item_cancelled"
+	OrderEventPriceOverride   = "price_override"
+	OrderEventPaymentReceived = "payment_received"
+	OrderEventPaymentFailed   = "payment_failed"
+	OrderEventRefundIssued    = "refund_issued"
+	OrderEventRefundFailed    = "refund_failed"
+	OrderEventNote            = "note"
+)
+
+// OrderEvent is an append-only entry in an order's timeline. Events are never
+// updated or deleted.
+type OrderEvent struct {
+	ID          uuid.UUID      `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	OrderID     uuid.UUID      `gorm:"index;type:uuid;not null" json:"order_id"`
+	OrderItemID *uuid.UUID     `gorm:"type:uuid" json:"order_item_id"`
+	ActorID     *uuid.UUID     `gorm:"type:uuid" json:"actor_id"` // empty for system events such as expiry
+	Actor       *User          `gorm:"foreignKey:ActorID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"actor,omitempty"`
+	Type        string         `gorm:"type:varchar(30);not null;index" json:"type"` // transition, item_added, quantity_changed, item_removed, item_expired, item_cancelled, price_override, payment_received, payment_failed, payment_refunded, note
+	FromStatus  string         `gorm:"type:varchar(20)" json:"from_status,omitempty"`
+	ToStatus    string         `gorm:"type:varchar(20)" json:"to_status,omitempty"`
+	Data        datatypes.JSON `gorm:"type:jsonb" json:"data"`
+	Note        string         `gorm:"type:text" json:"note"`
+	CreatedAt   int            `gorm:"index" json:"created_at"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
 	"github.com/google/uuid"
 	"gorm.io/datatypes"
 )
@@ -9,10 +10,10 @@ type OrderItem struct {
 	ID                 uuid.UUID      `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
 	OrderID            uuid.UUID      `gorm:"index;type:uuid" json:"order_id"`
 	ItemID             uuid.UUID      `gorm:"type:uuid" json:"item_id"`
-	BillableAmount     float64        `gorm:"type:decimal(10,2);not null" json:"billable_amount"`
-	BillableAmountPaid float64        `gorm:"type:decimal(10,2);default:0.0" json:"billable_amount_paid"`
+	BillableAmount     money.Amount   `gorm:"type:bigint;not null" json:"billable_amount"`
+	BillableAmountPaid money.Amount   `gorm:"type:bigint;not null;default:0" json:"billable_amount_paid"`
 	Quantity           int            `gorm:"type:int;default:1" json:"quantity"`
-	OrderItemStatus    string         `gorm:"type:varchar(20);default:'pending'" json:"order_item_status"` // pending, booked, cancelled
+	OrderItemStatus    string         `gorm:"type:varchar(20);default:'pending'" json:"order_item_status"` // pending, booked, cancelled, expired; cancelled items no longer hold stock
 	MetaData           datatypes.JSON `gorm:"type:jsonb" json:"metadata"`
 	CreatedAt          int            `json:"created_at"`
 	UpdatedAt          int            `json:"updated_at"`
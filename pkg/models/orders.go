@@ -1,18 +1,25 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/google/uuid"
+)
 
 type Orders struct {
-	ID                 uuid.UUID   `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
-	UserID             uuid.UUID   `gorm:"type:uuid" json:"user_id"`
-	OrderItems         []OrderItem `gorm:"foreignKey:OrderID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"order_items"`
-	BillableAmount     float64     `gorm:"type:decimal(10,2);default:0.0" json:"billable_amount"`
-	BillableAmountPaid float64     `gorm:"type:decimal(10,2);default:0.0" json:"billable_amount_paid"`
-	ShippingID         uuid.UUID   `gorm:"type:uuid" json:"shipping_id"`
-	DeliveryID         uuid.UUID   `gorm:"type:uuid" json:"delivery_id"`
-	Status             string      `gorm:"type:varchar(20);default:'pending'" json:"status"` //  pending, booked, paid, shipped, delivered, cancelled
-	StatusDate         int         `json:"status_date"`
-	CancellationReason string      `gorm:"type:text" json:"cancellation_reason"`
-	CreatedAt          int         `json:"created_at"`
-	UpdatedAt          int         `json:"updated_at"`
+	ID                   uuid.UUID      `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID               uuid.UUID      `gorm:"type:uuid" json:"user_id"`
+	OrderItems           []OrderItem    `gorm:"foreignKey:OrderID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"order_items"`
+	BillableAmount       money.Amount   `gorm:"type:bigint;not null;default:0" json:"billable_amount"`
+	BillableAmountPaid   money.Amount   `gorm:"type:bigint;not null;default:0" json:"billable_amount_paid"` // sum of the order's payments
+	Currency             money.Currency `gorm:"type:varchar(3);not null;default:'INR'" json:"currency"`     // currency of every amount on the order
+	ShippingID           uuid.UUID      `gorm:"type:uuid" json:"shipping_id"`
+	DeliveryID           uuid.UUID      `gorm:"type:uuid" json:"delivery_id"`
+	FulfilmentLocationID *uuid.UUID     `gorm:"type:uuid" json:"fulfilment_location_id"`
+	Status               string         `gorm:"type:varchar(20);default:'pending'" json:"status"` //  pending, booked, partially_paid, paid, shipped, delivered, cancelled
+	StatusDate           int            `json:"status_date"`
+	CancellationReason   string         `gorm:"type:text" json:"cancellation_reason"`
+	CancelledFrom        string         `gorm:"type:varchar(20)" json:"cancelled_from"` // status held before cancelling, restored by RestoreOrder
+	StockReleased        bool           `gorm:"default:false" json:"stock_released"`    // set while a cancelled order's stock is back on sale
+	CreatedAt            int            `json:"created_at"`
+	UpdatedAt            int            `json:"updated_at"`
 }
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/google/uuid"
+)
+
+const (
+	PaymentMethodCash         = "cash"
+	PaymentMethodUPI          = "upi"
+	PaymentMethodBankTransfer = "bank_transfer"
+	PaymentMethodCard         = "card"
+	PaymentMethodOnline       = "online" // other gateway methods, such as wallets
+)
+
+// Payment is money received against an order. The order's amount paid is the
+// sum of its payments, allocated to its items oldest first.
+type Payment struct {
+	ID                uuid.UUID    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	OrderID           uuid.UUID    `gorm:"index;type:uuid;not null" json:"order_id"`
+	Amount            money.Amount `gorm:"type:bigint;not null" json:"amount"`
+	Method            string       `gorm:"type:varchar(20);not null" json:"method"` // cash, upi, bank_transfer, card, online
+	Reference         string       `gorm:"type:varchar(100)" json:"reference"`      // UPI transaction id, cheque or card slip number
+	Provider          string       `gorm:"type:varchar(20)" json:"provider"`        // payment gateway, empty for payments recorded by hand
+	ProviderPaymentID string       `gorm:"type:varchar(100);index:idx_payment_provider_payment,unique,where:provider_payment_id <> ''" json:"provider_payment_id"`
+	ReceivedAt        int          `gorm:"index" json:"received_at"`
+	RecordedBy        uuid.UUID    `gorm:"type:uuid" json:"recorded_by"`
+	Note              string       `gorm:"type:text" json:"note"`
+	CreatedAt         int          `json:"created_at"`
+	UpdatedAt         int          `json:"updated_at"`
+}
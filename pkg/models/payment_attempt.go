@@ -0,0 +1,29 @@
+package models
+
+import (
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/google/uuid"
+)
+
+const (
+	PaymentAttemptStatusCreated  = "created"
+	PaymentAttemptStatusCaptured = "captured"
+	PaymentAttemptStatusFailed   = "failed"
+)
+
+// PaymentAttempt is a checkout opened at a payment gateway for the balance of
+// an order. It links the gateway's order to ours until the payment is
+// captured or fails.
+type PaymentAttempt struct {
+	ID                uuid.UUID      `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	OrderID           uuid.UUID      `gorm:"index;type:uuid;not null" json:"order_id"`
+	Provider          string         `gorm:"type:varchar(20);not null" json:"provider"`
+	ProviderOrderID   string         `gorm:"type:varchar(100);uniqueIndex;not null" json:"provider_order_id"`
+	ProviderPaymentID string         `gorm:"type:varchar(100)" json:"provider_payment_id"`
+	Amount            money.Amount   `gorm:"type:bigint;not null" json:"amount"`
+	Currency          money.Currency `gorm:"type:varchar(3);not null" json:"currency"`
+	Status            string         `gorm:"type:varchar(20);default:'created';index" json:"status"` // created, captured, failed
+	FailureReason     string         `gorm:"type:text" json:"failure_reason"`
+	CreatedAt         int            `json:"created_at"`
+	UpdatedAt         int            `json:"updated_at"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/google/uuid"
+)
+
+const (
+	RefundStatusPending   = "pending"
+	RefundStatusProcessed = "processed"
+	RefundStatusFailed    = "failed"
+)
+
+const (
+	RefundReasonCancelled   = "cancelled"
+	RefundReasonReturned    = "returned"
+	RefundReasonDamaged     = "damaged"
+	RefundReasonGoodwill    = "goodwill"
+	RefundReasonOverpayment = "overpayment"
+	RefundReasonOther       = "other"
+)
+
+// Refund is money paid back against one of an order's payments. Refunds of
+// gateway payments go back through the gateway and stay pending until it
+// confirms them. Failed refunds no longer count against the payment.
+type Refund struct {
+	ID               uuid.UUID    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	OrderID          uuid.UUID    `gorm:"index;type:uuid;not null" json:"order_id"`
+	PaymentID        uuid.UUID    `gorm:"index;type:uuid;not null" json:"payment_id"`
+	OrderItemID      *uuid.UUID   `gorm:"type:uuid" json:"order_item_id"` // set when the refund is for a single item
+	Amount           money.Amount `gorm:"type:bigint;not null" json:"amount"`
+	Reason           string       `gorm:"type:varchar(20);not null" json:"reason"` // cancelled, returned, damaged, goodwill, overpayment, other
+	Note             string       `gorm:"type:text" json:"note"`
+	AdjustsTotal     bool         `gorm:"default:false" json:"adjusts_total"` // the refund lowers what the order is worth, rather than returning money for goods no longer sold
+	Method           string       `gorm:"type:varchar(20);not null" json:"method"`
+	Provider         string       `gorm:"type:varchar(20)" json:"provider"`
+	ProviderRefundID string       `gorm:"type:varchar(100);index:idx_refund_provider_refund,unique,where:provider_refund_id <> ''" json:"provider_refund_id"`
+	Status           string       `gorm:"type:varchar(20);default:'pending';index" json:"status"` // pending, processed, failed
+	RecordedBy       *uuid.UUID   `gorm:"type:uuid" json:"recorded_by"`                           // empty for refunds started at the gateway
+	ProcessedAt      int          `json:"processed_at"`
+	CreatedAt        int          `json:"created_at"`
+	UpdatedAt        int          `json:"updated_at"`
+}
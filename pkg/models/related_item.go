@@ -0,0 +1,15 @@
+package models
+
+import "github.com/google/uuid"
+
+// RelatedItem is a manually curated link from an item to another item shown
+// alongside it.
+type RelatedItem struct {
+	ID            uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ItemID        uuid.UUID `gorm:"uniqueIndex:idx_related_item;type:uuid;not null" json:"item_id"`
+	RelatedItemID uuid.UUID `gorm:"uniqueIndex:idx_related_item;type:uuid;not null" json:"related_item_id"`
+	RelatedItem   *Item     `gorm:"foreignKey:RelatedItemID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"related_item,omitempty"`
+	Position      int       `gorm:"not null;default:0" json:"position"`
+	CreatedAt     int       `json:"created_at"`
+	UpdatedAt     int       `json:"updated_at"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// SKUSequence holds the last sequence number handed out for a generated SKU
+// scope, such as a category prefix and year.
+type SKUSequence struct {
+	Scope     string `gorm:"primaryKey;size:100" json:"scope"`
+	Value     int    `gorm:"not null;default:0" json:"value"`
+	UpdatedAt int    `json:"updated_at"`
+}
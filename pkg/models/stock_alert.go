@@ -0,0 +1,26 @@
+package models
+
+import "github.com/google/uuid"
+
+const (
+	StockAlertStatusOpen         = "open"
+	StockAlertStatusAcknowledged = "acknowledged"
+	StockAlertStatusResolved     = "resolved"
+)
+
+// StockAlert is raised when an item's stock falls to its reorder threshold
+// and resolved once the stock is above the threshold again.
+type StockAlert struct {
+	ID             uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ItemID         uuid.UUID  `gorm:"index;type:uuid;not null" json:"item_id"`
+	Item           *Item      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"item,omitempty"`
+	Threshold      int        `gorm:"not null" json:"threshold"`
+	Stock          int        `gorm:"not null" json:"stock"`                               // stock when the alert was raised
+	Status         string     `gorm:"type:varchar(20);default:'open';index" json:"status"` // open, acknowledged, resolved
+	OrderID        *uuid.UUID `gorm:"type:uuid" json:"order_id"`
+	AcknowledgedBy *uuid.UUID `gorm:"type:uuid" json:"acknowledged_by"`
+	AcknowledgedAt int        `json:"acknowledged_at"`
+	ResolvedAt     int        `json:"resolved_at"`
+	CreatedAt      int        `json:"created_at"`
+	UpdatedAt      int        `json:"updated_at"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import "github.com/google/uuid"
+
+const (
+	StockReasonOpening    = "opening"
+	StockReasonSale       = "sale"
+	StockReasonReserve    = "reserve"
+	StockReasonRelease    = "release"
+	StockReasonReturn     = "return"
+	StockReasonRestock    = "restock"
+	StockReasonDamage     = "damage"
+	StockReasonCorrection = "correction"
+)
+
+// StockMovement is an append-only ledger entry. The sum of Quantity over an
+// item's movements is its stock.
+type StockMovement struct {
+	ID          uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ItemID      uuid.UUID  `gorm:"index;type:uuid;not null" json:"item_id"`
+	Quantity    int        `gorm:"not null" json:"quantity"` // signed, negative when stock leaves
+	StockAfter  int        `json:"stock_after"`
+	Reason      string     `gorm:"type:varchar(20);not null" json:"reason"` // opening, sale, reserve, release, return, restock, damage, correction
+	ActorID     *uuid.UUID `gorm:"type:uuid" json:"actor_id"`
+	Note        string     `gorm:"type:text" json:"note"`
+	LocationID  *uuid.UUID `gorm:"type:uuid" json:"location_id"` // set when the movement changes a location's stock level
+	OrderID     *uuid.UUID `gorm:"index;type:uuid" json:"order_id"`
+	OrderItemID *uuid.UUID `gorm:"type:uuid" json:"order_item_id"`
+	CreatedAt   int        `gorm:"index" json:"created_at"`
+}
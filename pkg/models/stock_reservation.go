@@ -0,0 +1,24 @@
+package models
+
+import "github.com/google/uuid"
+
+const (
+	ReservationStatusActive    = "active"
+	ReservationStatusReleased  = "released"
+	ReservationStatusExpired   = "expired"
+	ReservationStatusConverted = "converted"
+)
+
+// StockReservation holds stock for an item of a pending order until it is
+// confirmed or ExpiresAt passes.
+type StockReservation struct {
+	ID          uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ItemID      uuid.UUID `gorm:"index;type:uuid;not null" json:"item_id"`
+	OrderID     uuid.UUID `gorm:"index;type:uuid;not null" json:"order_id"`
+	OrderItemID uuid.UUID `gorm:"uniqueIndex;type:uuid;not null" json:"order_item_id"`
+	Quantity    int       `gorm:"not null" json:"quantity"`
+	ExpiresAt   int       `gorm:"index;not null" json:"expires_at"`
+	Status      string    `gorm:"type:varchar(20);default:'active';index" json:"status"` // active, released, expired, converted
+	CreatedAt   int       `json:"created_at"`
+	UpdatedAt   int       `json:"updated_at"`
+}
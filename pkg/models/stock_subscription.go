@@ -0,0 +1,22 @@
+package models
+
+import "github.com/google/uuid"
+
+const (
+	SubscriptionStatusActive    = "active"
+	SubscriptionStatusNotified  = "notified"
+	SubscriptionStatusCancelled = "cancelled"
+)
+
+// StockSubscription is a customer's request to be emailed when an out of
+// stock item is replenished.
+type StockSubscription struct {
+	ID         uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ItemID     uuid.UUID  `gorm:"index;type:uuid;not null" json:"item_id"`
+	UserID     *uuid.UUID `gorm:"type:uuid" json:"user_id"`
+	Email      string     `gorm:"size:255;not null" json:"email"`
+	Status     string     `gorm:"type:varchar(20);default:'active';index" json:"status"` // active, notified, cancelled
+	NotifiedAt int        `json:"notified_at"`
+	CreatedAt  int        `json:"created_at"`
+	UpdatedAt  int        `json:"updated_at"`
+}
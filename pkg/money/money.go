@@ -0,0 +1,161 @@
+// Package money holds amounts as integer minor units, paise for rupees, so
+// that adding up lines and totals never drifts the way floats do.
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type Currency string
+
+// INR is the currency the store sells in. Every amount without a currency of
+// its own is in it.
+const INR Currency = "INR"
+
+// Amount is a sum of money in minor units. It is stored as a bigint and
+// written to and read from JSON as a decimal in major units, such as 1234.50,
+// so clients keep working in rupees.
+type Amount int64
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// FromFloat converts major units to an Amount, rounding half away from zero
+// to the paisa. It is meant for values that were floats to begin with.
+func FromFloat(major float64) Amount {
+	return Amount(math.Round(major * 100))
+}
+
+// Parse reads a decimal in major units, such as "1234.5". It refuses more
+// than two decimal places rather than round them silently.
+func Parse(value string) (Amount, error) {
+	value = strings.TrimSpace(value)
+	negative := false
+	if value != "" && (value[0] == '-' || value[0] == '+') {
+		// a single sign only; one left over is refused below
+		negative = value[0] == '-'
+		value = value[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(value, ".")
+	if whole == "" && fraction == "" || len(fraction) > 2 {
+		return 0, ErrInvalidAmount
+	}
+	for len(fraction) < 2 {
+		fraction += "0"
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	units, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil || strings.ContainsAny(whole+fraction, "+-") {
+		return 0, ErrInvalidAmount
+	}
+	if negative {
+		units = -units
+	}
+	return Amount(units), nil
+}
+
+// Float is the amount in major units, for the few places that print or send
+// it as a float.
+func (a Amount) Float() float64 {
+	return float64(a) / 100
+}
+
+// String formats the amount in major units with two decimals.
+func (a Amount) String() string {
+	sign := ""
+	units := int64(a)
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, units/100, units%100)
+}
+
+// Times is the amount for quantity of something priced at a.
+func (a Amount) Times(quantity int) Amount {
+	return a * Amount(quantity)
+}
+
+// Percent is rate percent of a, rounded half away from zero to the paisa.
+func (a Amount) Percent(rate float64) Amount {
+	return a.Ratio(int64(math.Round(rate*100)), 10000)
+}
+
+// Ratio is a scaled by numerator/denominator, rounded half away from zero.
+func (a Amount) Ratio(numerator int64, denominator int64) Amount {
+	product := int64(a) * numerator
+	if (product < 0) != (denominator < 0) {
+		return Amount(-((-product + denominator/2) / denominator))
+	}
+	return Amount((product + denominator/2) / denominator)
+}
+
+func Max(a Amount, b Amount) Amount {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func Min(a Amount, b Amount) Amount {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	if value == "null" || value == "" {
+		return nil
+	}
+	parsed, err := Parse(value)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+func (a Amount) Value() (driver.Value, error) {
+	return int64(a), nil
+}
+
+// Scan reads minor units. Sums come back from postgres as numeric text.
+func (a *Amount) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*a = 0
+	case int64:
+		*a = Amount(v)
+	case float64:
+		*a = Amount(math.Round(v))
+	case []byte:
+		return a.scanText(string(v))
+	case string:
+		return a.scanText(v)
+	default:
+		return fmt.Errorf("money: cannot scan %T", value)
+	}
+	return nil
+}
+
+func (a *Amount) scanText(value string) error {
+	units, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	*a = Amount(math.Round(units))
+	return nil
+}
@@ -0,0 +1,201 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Amount
+		err   bool
+	}{
+		{value: "1234.5", want: 123450},
+		{value: "1234.50", want: 123450},
+		{value: " 12 ", want: 1200},
+		{value: "0", want: 0},
+		{value: ".5", want: 50},
+		{value: "1.", want: 100},
+		{value: "-.05", want: -5},
+		{value: "+3.25", want: 325},
+		{value: "-3.25", want: -325},
+		{value: "-0", want: 0},
+		{value: "92233720368547758.07", want: 9223372036854775807},
+		{value: "", err: true},
+		{value: ".", err: true},
+		{value: "-", err: true},
+		{value: "+", err: true},
+		{value: "--1", err: true},
+		{value: "+-1", err: true},
+		{value: "-+1", err: true},
+		{value: "1.-5", err: true},
+		{value: "1.+5", err: true},
+		{value: "1.005", err: true},
+		{value: "1.999", err: true},
+		{value: "0.0001", err: true},
+		{value: "1,000.00", err: true},
+		{value: "1e3", err: true},
+		{value: "abc", err: true},
+		{value: "92233720368547758.08", err: true},
+		{value: "99999999999999999999", err: true},
+	}
+	for _, test := range tests {
+		got, err := Parse(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("Parse(%q) = %d, want an error", test.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", test.value, err)
+		} else if got != test.want {
+			t.Errorf("Parse(%q) = %d, want %d", test.value, got, test.want)
+		}
+	}
+}
+
+func TestRatio(t *testing.T) {
+	tests := []struct {
+		amount      Amount
+		numerator   int64
+		denominator int64
+		want        Amount
+	}{
+		{amount: 10, numerator: 1, denominator: 3, want: 3},
+		{amount: 20, numerator: 1, denominator: 3, want: 7},
+		{amount: 5, numerator: 1, denominator: 2, want: 3},
+		{amount: -5, numerator: 1, denominator: 2, want: -3},
+		{amount: 5, numerator: -1, denominator: 2, want: -3},
+		{amount: 5, numerator: 1, denominator: -2, want: -3},
+		{amount: -5, numerator: 1, denominator: -2, want: 3},
+		{amount: -7, numerator: 1, denominator: 2, want: -4},
+		{amount: -10, numerator: 1, denominator: 3, want: -3},
+		{amount: -20, numerator: 1, denominator: 3, want: -7},
+		{amount: 0, numerator: 1, denominator: 3, want: 0},
+	}
+	for _, test := range tests {
+		if got := test.amount.Ratio(test.numerator, test.denominator); got != test.want {
+			t.Errorf("Amount(%d).Ratio(%d, %d) = %d, want %d", test.amount, test.numerator, test.denominator, got, test.want)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		rate   float64
+		want   Amount
+	}{
+		{amount: 10000, rate: 18, want: 1800},
+		{amount: 150, rate: 5, want: 8},
+		{amount: -150, rate: 5, want: -8},
+		{amount: 150, rate: -5, want: -8},
+		{amount: 10, rate: 2.5, want: 0},
+		{amount: 20, rate: 2.5, want: 1},
+		{amount: -20, rate: 2.5, want: -1},
+		{amount: 12345, rate: 0.25, want: 31},
+		{amount: -12345, rate: 0.25, want: -31},
+		{amount: 100, rate: 0, want: 0},
+	}
+	for _, test := range tests {
+		if got := test.amount.Percent(test.rate); got != test.want {
+			t.Errorf("Amount(%d).Percent(%v) = %d, want %d", test.amount, test.rate, got, test.want)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  Amount
+		err   bool
+	}{
+		{value: nil, want: 0},
+		{value: int64(12345), want: 12345},
+		{value: float64(12.5), want: 13},
+		{value: "12345", want: 12345},
+		{value: []byte("12345"), want: 12345},
+		{value: []byte("-12345"), want: -12345},
+		{value: []byte("12345.000000"), want: 12345},
+		{value: []byte("0.5"), want: 1},
+		{value: []byte("-0.5"), want: -1},
+		{value: []byte("abc"), err: true},
+		{value: true, err: true},
+	}
+	for _, test := range tests {
+		amount := Amount(99)
+		err := amount.Scan(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("Scan(%#v) = %d, want an error", test.value, amount)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Scan(%#v) failed: %v", test.value, err)
+		} else if amount != test.want {
+			t.Errorf("Scan(%#v) = %d, want %d", test.value, amount, test.want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		json   string
+	}{
+		{amount: 0, json: "0.00"},
+		{amount: 5, json: "0.05"},
+		{amount: -5, json: "-0.05"},
+		{amount: 123450, json: "1234.50"},
+		{amount: -123456, json: "-1234.56"},
+	}
+	for _, test := range tests {
+		data, err := json.Marshal(test.amount)
+		if err != nil {
+			t.Errorf("marshalling %d failed: %v", test.amount, err)
+			continue
+		}
+		if string(data) != test.json {
+			t.Errorf("Amount(%d) marshalled to %s, want %s", test.amount, data, test.json)
+		}
+		var amount Amount
+		if err := json.Unmarshal(data, &amount); err != nil {
+			t.Errorf("unmarshalling %s failed: %v", data, err)
+		} else if amount != test.amount {
+			t.Errorf("%s unmarshalled to %d, want %d", data, amount, test.amount)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json string
+		want Amount
+		err  bool
+	}{
+		{json: `12.5`, want: 1250},
+		{json: `"12.5"`, want: 1250},
+		{json: `null`, want: 99},
+		{json: `""`, want: 99},
+		{json: `1.005`, err: true},
+		{json: `"abc"`, err: true},
+	}
+	for _, test := range tests {
+		amount := Amount(99)
+		err := json.Unmarshal([]byte(test.json), &amount)
+		if test.err {
+			if err == nil {
+				t.Errorf("unmarshalling %s gave %d, want an error", test.json, amount)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unmarshalling %s failed: %v", test.json, err)
+		} else if amount != test.want {
+			t.Errorf("%s unmarshalled to %d, want %d", test.json, amount, test.want)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package orders
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/inventory"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func liveOrderItems(tx *gorm.DB, order_id uuid.UUID) ([]models.OrderItem, error) {
+	var orderItems []models.OrderItem
+	err := tx.Where("order_id = ? AND order_item_status NOT IN ?", order_id, []string{"cancelled", "expired"}).
+		Order("created_at ASC").Find(&orderItems).Error
+	return orderItems, err
+}
+
+// restockOrderItem puts the stock held by a live order item back on sale. A
+// pending item gives up its reservation; a booked one is taken back as a
+// return. Units of serialised items are released first, so their stock is
+// re-derived after they are back.
+func restockOrderItem(tx *gorm.DB, order *models.Orders, orderItem *models.OrderItem) error {
+	if err := inventory.ReturnUnits(tx, orderItem.ItemID, orderItem.ID); err != nil {
+		return err
+	}
+	if OrderStatus(order.Status) == StatusPending {
+		released, err := inventory.ReleaseReservation(tx, orderItem.ID, models.ReservationStatusReleased)
+		if err != nil || released {
+			return err
+		}
+	}
+	return inventory.RecordSale(tx, orderItem.ItemID, -orderItem.Quantity, order.ID, orderItem.ID)
+}
+
+// releaseOrderStock is the side effect of cancelling: every live item of the
+// order goes back into stock, and a shipped order's goods go back into the
+// location they shipped from.
+func releaseOrderStock(ctx *transitionContext) error {
+	orderItems, err := liveOrderItems(ctx.Tx, ctx.Order.ID)
+	if err != nil {
+		return err
+	}
+
+	if OrderStatus(ctx.Order.Status) == StatusShipped {
+		if err := inventory.ReturnOrderGoods(ctx.Tx, ctx.Order.ID, ctx.Order.FulfilmentLocationID); err != nil {
+			return err
+		}
+	}
+
+	for i := range orderItems {
+		if _, err := inventory.LockItem(ctx.Tx, orderItems[i].ItemID); err != nil {
+			return err
+		}
+		if err := restockOrderItem(ctx.Tx, ctx.Order, &orderItems[i]); err != nil {
+			return err
+		}
+	}
+
+	ctx.Updates["cancelled_from"] = ctx.Order.Status
+	ctx.Updates["stock_released"] = true
+	return nil
+}
+
+// stockAvailable refuses to restore an order whose items are no longer in
+// stock, naming each of them. Orders cancelled before their stock was
+// released still hold it and always pass.
+func stockAvailable(ctx *transitionContext) error {
+	if !ctx.Order.StockReleased {
+		return nil
+	}
+
+	orderItems, err := liveOrderItems(ctx.Tx, ctx.Order.ID)
+	if err != nil {
+		return err
+	}
+
+	requested := map[uuid.UUID]int{}
+	for _, orderItem := range orderItems {
+		requested[orderItem.ItemID] += orderItem.Quantity
+	}
+	if len(requested) == 0 {
+		return nil
+	}
+
+	var items []models.Item
+	if err := ctx.Tx.Where("id IN ?", keys(requested)).Find(&items).Error; err != nil {
+		return err
+	}
+
+	var unavailable []string
+	for _, item := range items {
+		if item.Stock < requested[item.ID] {
+			unavailable = append(unavailable, fmt.Sprintf("%s (%d requested, %d in stock)", itemLabel(&item), requested[item.ID], item.Stock))
+		}
+	}
+	if len(unavailable) > 0 {
+		sort.Strings(unavailable)
+		return &TransitionError{Message: "items are no longer available: " + strings.Join(unavailable, ", ")}
+	}
+	return nil
+}
+
+// retakeOrderStock is the side effect of restoring: the order's items take
+// their stock again the way they held it before cancelling. Pending items
+// get fresh reservations, booked ones are sold again, and a shipped order's
+// goods leave its fulfilment location again.
+func retakeOrderStock(ctx *transitionContext) error {
+	ctx.Updates["cancelled_from"] = ""
+	ctx.Updates["cancellation_reason"] = ""
+	if !ctx.Order.StockReleased {
+		return nil
+	}
+
+	orderItems, err := liveOrderItems(ctx.Tx, ctx.Order.ID)
+	if err != nil {
+		return err
+	}
+
+	for i := range orderItems {
+		if _, err := inventory.LockItem(ctx.Tx, orderItems[i].ItemID); err != nil {
+			return err
+		}
+	}
+	// checked again now the items are locked
+	if err := stockAvailable(ctx); err != nil {
+		return err
+	}
+
+	to := restoredStatus(ctx.Order)
+	var orderItemIDs []uuid.UUID
+	for _, orderItem := range orderItems {
+		var isSerialised bool
+		if err := ctx.Tx.Model(&models.Item{}).Where("id = ?", orderItem.ItemID).Pluck("is_serialised", &isSerialised).Error; err != nil {
+			return err
+		}
+		if isSerialised {
+			if _, err := inventory.AllocateUnits(ctx.Tx, orderItem.ItemID, orderItem.ID, orderItem.Quantity, nil); err != nil {
+				return err
+			}
+		}
+
+		if to == StatusPending {
+			if err := inventory.Reserve(ctx.Tx, orderItem.ItemID, ctx.Order.ID, orderItem.ID, orderItem.Quantity); err != nil {
+				return err
+			}
+		} else if err := inventory.RecordSale(ctx.Tx, orderItem.ItemID, orderItem.Quantity, ctx.Order.ID, orderItem.ID); err != nil {
+			return err
+		}
+		orderItemIDs = append(orderItemIDs, orderItem.ID)
+	}
+
+	if to == StatusPaid || to == StatusShipped {
+		if err := inventory.MarkUnitsSold(ctx.Tx, orderItemIDs); err != nil {
+			return err
+		}
+	}
+	if to == StatusShipped {
+		if err := inventory.FulfilOrder(ctx.Tx, ctx.Order.ID, ctx.Order.FulfilmentLocationID); err != nil {
+			return err
+		}
+	}
+
+	ctx.Updates["stock_released"] = false
+	return nil
+}
+
+func itemLabel(item *models.Item) string {
+	if item.SKU != "" {
+		return item.SKU + " " + item.Name
+	}
+	return item.Name
+}
+
+func keys(m map[uuid.UUID]int) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// CancelOrderItem cancels a single item of an order that has not shipped yet.
+// Its stock goes back on sale and it no longer counts towards the order
+// total, but it stays on the order for the record.
+func CancelOrderItem(c *fiber.Ctx) error {
+	var req schemas.CancelOrderItemRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	order_id, err := uuid.Parse(c.Params("order_id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+	order_item_id, err := uuid.Parse(c.Params("order_item_id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+	user_id, err := uuid.Parse(req.UserID)
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var orderItem *models.OrderItem
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		order, err := lockOrder(tx, order_id)
+		if err != nil {
+			return err
+		}
+		switch OrderStatus(order.Status) {
+		case StatusPending, StatusBooked, StatusPartiallyPaid, StatusPaid:
+		default:
+			return &TransitionError{Message: fmt.Sprintf("cannot cancel items of an order that is %s", order.Status)}
+		}
+
+		orderItem, err = lockOrderItem(tx, order_item_id)
+		if err != nil {
+			return err
+		}
+		if orderItem.OrderID != order.ID {
+			return gorm.ErrRecordNotFound
+		}
+		switch orderItem.OrderItemStatus {
+		case "cancelled":
+			return ErrOrderItemCancelled
+		case "expired":
+			return ErrReservationExpired
+		}
+
+		if _, err := inventory.LockItem(tx, orderItem.ItemID); err != nil {
+			return err
+		}
+		if err := restockOrderItem(tx, order, orderItem); err != nil {
+			return err
+		}
+		if err := tx.Model(orderItem).Update("order_item_status", "cancelled").Error; err != nil {
+			return err
+		}
+		if err := recalculateOrderTotal(tx, order.ID); err != nil {
+			return err
+		}
+		return recordEvent(tx, models.OrderEvent{
+			OrderID:     order.ID,
+			OrderItemID: &orderItem.ID,
+			ActorID:     &user_id,
+			Type:        models.OrderEventItemCancelled,
+			Note:        req.CancellationReason,
+		}, map[string]interface{}{
+			"item_id":         orderItem.ItemID,
+			"quantity":        orderItem.Quantity,
+			"billable_amount": orderItem.BillableAmount,
+		})
+	}); err != nil {
+		return orderChangeFailed(c, err)
+	}
+
+	return views.StatusOK(c, orderItem)
+}
@@ -0,0 +1,254 @@
+package orders
+
+import (
+	"errors"
+	"log"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/gateway"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// paymentMethod maps a gateway's name for how the customer paid to ours.
+func paymentMethod(method string) string {
+	switch method {
+	case "upi":
+		return models.PaymentMethodUPI
+	case "card":
+		return models.PaymentMethodCard
+	case "netbanking":
+		return models.PaymentMethodBankTransfer
+	default:
+		return models.PaymentMethodOnline
+	}
+}
+
+func lockPaymentAttempt(tx *gorm.DB, provider_order_id string) (*models.PaymentAttempt, error) {
+	var attempt models.PaymentAttempt
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("provider = ? AND provider_order_id = ?", gateway.GetProvider().Name(), provider_order_id).
+		First(&attempt).Error; err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// captureGatewayPayment records a payment captured at the gateway against
+// the order of its attempt. The client callback and the webhook both report
+// the same payment, so whichever arrives second finds it recorded already.
+func captureGatewayPayment(tx *gorm.DB, provider_order_id string, provider_payment_id string, amount money.Amount, method string) error {
+	attempt, err := lockPaymentAttempt(tx, provider_order_id)
+	if err != nil {
+		return err
+	}
+	order, err := lockOrder(tx, attempt.OrderID)
+	if err != nil {
+		return err
+	}
+
+	var existing models.Payment
+	err = tx.Where("provider = ? AND provider_payment_id = ?", attempt.Provider, provider_payment_id).First(&existing).Error
+	if err == nil {
+		// the callback does not say how the customer paid, the webhook does
+		if method != "" && existing.Method == models.PaymentMethodOnline {
+			return tx.Model(&existing).Update("method", paymentMethod(method)).Error
+		}
+		return nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if amount <= 0 {
+		amount = attempt.Amount
+	}
+	if err := recordPayment(tx, order, &models.Payment{
+		Amount:            amount,
+		Method:            paymentMethod(method),
+		Reference:         provider_payment_id,
+		Provider:          attempt.Provider,
+		ProviderPaymentID: provider_payment_id,
+		RecordedBy:        order.UserID,
+	}, true); err != nil {
+		return err
+	}
+
+	return tx.Model(attempt).Updates(map[string]interface{}{
+		"status":              models.PaymentAttemptStatusCaptured,
+		"provider_payment_id": provider_payment_id,
+	}).Error
+}
+
+// CreateCheckout opens a payment at the gateway for the balance of a booked
+// order. The response carries what the client needs to start the checkout.
+func CreateCheckout(c *fiber.Ctx) error {
+	order_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var order models.Orders
+	if err := db.GetDB().Where("id = ?", order_id).First(&order).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	if status := OrderStatus(order.Status); status != StatusBooked && status != StatusPartiallyPaid {
+		return views.BadRequestWithMessage(c, "order is not awaiting payment")
+	}
+	balance := order.BillableAmount - order.BillableAmountPaid
+	if balance <= 0 {
+		return views.BadRequestWithMessage(c, "order has no balance to pay")
+	}
+
+	provider := gateway.GetProvider()
+	paymentOrder, err := provider.CreateOrder(c.Context(), balance, order.Currency, order.ID.String())
+	if err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	attempt := models.PaymentAttempt{
+		OrderID:         order.ID,
+		Provider:        provider.Name(),
+		ProviderOrderID: paymentOrder.ProviderOrderID,
+		Amount:          paymentOrder.Amount,
+		Currency:        paymentOrder.Currency,
+		Status:          models.PaymentAttemptStatusCreated,
+	}
+	if err := db.GetDB().Create(&attempt).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	return views.ObjectCreated(c, paymentOrder)
+}
+
+// VerifyCheckout takes the signed callback the client receives from the
+// gateway once the customer has paid, and records the payment.
+func VerifyCheckout(c *fiber.Ctx) error {
+	var req schemas.VerifyCheckoutRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	order_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	if err := gateway.GetProvider().VerifyCallback(gateway.Callback{
+		ProviderOrderID:   req.ProviderOrderID,
+		ProviderPaymentID: req.ProviderPaymentID,
+		Signature:         req.Signature,
+	}); err != nil {
+		return views.BadRequestWithMessage(c, err.Error())
+	}
+
+	var attempt models.PaymentAttempt
+	if err := db.GetDB().Where("order_id = ? AND provider_order_id = ?", order_id, req.ProviderOrderID).First(&attempt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		return captureGatewayPayment(tx, req.ProviderOrderID, req.ProviderPaymentID, 0, "")
+	}); err != nil {
+		return transitionFailed(c, err)
+	}
+
+	summary, err := paymentSummary(db.GetDB(), order_id)
+	if err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, summary)
+}
+
+// PaymentWebhook receives the gateway's notifications about payments. Only
+// requests signed with the webhook secret are acted on. Events for payments
+// that are not ours are acknowledged and ignored, so the gateway stops
+// retrying them.
+func PaymentWebhook(c *fiber.Ctx) error {
+	provider := gateway.GetProvider()
+	event, err := provider.ParseWebhook(c.Body(), c.Get(provider.SignatureHeader()))
+	if err != nil {
+		if errors.Is(err, gateway.ErrInvalidSignature) {
+			return views.BadRequestWithMessage(c, err.Error())
+		}
+		return views.InvalidParams(c)
+	}
+
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		switch event.Type {
+		case gateway.EventPaymentCaptured:
+			return captureGatewayPayment(tx, event.ProviderOrderID, event.ProviderPaymentID, event.Amount, event.Method)
+		case gateway.EventPaymentFailed:
+			return closePaymentAttempt(tx, event, models.PaymentAttemptStatusFailed, models.OrderEventPaymentFailed)
+		case gateway.EventPaymentRefunded:
+			return gatewayRefundProcessed(tx, event)
+		case gateway.EventRefundFailed:
+			return gatewayRefundFailed(tx, event)
+		}
+		return nil
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Println("Ignoring payment webhook for unknown order:", event.Type, event.ProviderOrderID)
+		return views.StatusOK(c, "ignored")
+	} else if err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, "processed")
+}
+
+// closePaymentAttempt records that a gateway payment failed on the attempt
+// and the order's timeline.
+func closePaymentAttempt(tx *gorm.DB, event *gateway.Event, status string, eventType string) error {
+	attempt, err := lockPaymentAttempt(tx, event.ProviderOrderID)
+	if err != nil {
+		return err
+	}
+	// a failed retry must not undo a payment the customer made in the end
+	if status == models.PaymentAttemptStatusFailed && attempt.Status == models.PaymentAttemptStatusCaptured {
+		return nil
+	}
+
+	if err := tx.Model(attempt).Updates(map[string]interface{}{
+		"status":              status,
+		"provider_payment_id": event.ProviderPaymentID,
+		"failure_reason":      event.FailureReason,
+	}).Error; err != nil {
+		return err
+	}
+	return recordEvent(tx, models.OrderEvent{
+		OrderID: attempt.OrderID,
+		Type:    eventType,
+		Note:    event.FailureReason,
+	}, map[string]interface{}{
+		"provider":            attempt.Provider,
+		"provider_order_id":   attempt.ProviderOrderID,
+		"provider_payment_id": event.ProviderPaymentID,
+		"amount":              event.Amount,
+	})
+}
+
+// FakeCheckoutPayment completes a checkout with the fake provider, returning
+// the signed callback a real gateway would hand the client.
+func FakeCheckoutPayment(c *fiber.Ctx) error {
+	provider, ok := gateway.GetProvider().(*gateway.FakeProvider)
+	if !ok {
+		return views.RecordNotFound(c)
+	}
+	return views.StatusOK(c, provider.Sign(c.Params("provider_order_id")))
+}
@@ -0,0 +1,252 @@
+package orders_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/migrations"
+	"github.com/Baalamurgan/coin-selling-backend/config"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/orders"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// These tests change orders from many goroutines at once against a real
+// database and check that stock and totals still add up. They need a scratch
+// Postgres database, named by TEST_DB_URI, and are best run with -race:
+//
+//	TEST_DB_URI="host=localhost user=postgres dbname=coins_test sslmode=disable" go test -race ./pkg/orders/
+
+const workers = 20
+
+func TestMain(m *testing.M) {
+	if uri := os.Getenv("TEST_DB_URI"); uri != "" {
+		config.DB_URI = uri
+		migrations.Migrate()
+	}
+	os.Exit(m.Run())
+}
+
+func requireDB(t *testing.T) {
+	t.Helper()
+	if config.DB_URI == "" {
+		t.Skip("TEST_DB_URI is not set")
+	}
+}
+
+func newApp() *fiber.App {
+	app := fiber.New()
+	app.Patch("/order/:id/cancel", orders.CancelOrder)
+	app.Post("/order/item/add", orders.AddItemToOrder)
+	app.Patch("/order/item/update-quantity", orders.UpdateOrderItemQuantity)
+	app.Post("/order/:id/refunds", orders.CreateRefund)
+	return app
+}
+
+// send makes a request to app and returns its status code. It is called from
+// the workers' goroutines, so failures are reported with t.Error.
+func send(t *testing.T, app *fiber.App, method string, path string, body interface{}) int {
+	t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	request := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	request.Header.Set("Content-Type", "application/json")
+	response, err := app.Test(request, -1)
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	if response.StatusCode >= fiber.StatusInternalServerError {
+		t.Errorf("%s %s failed with %d", method, path, response.StatusCode)
+	}
+	return response.StatusCode
+}
+
+func newItem(t *testing.T, stock int) models.Item {
+	t.Helper()
+	item := models.Item{
+		Name:   "Concurrency test coin",
+		SKU:    "TEST-" + uuid.NewString(),
+		Slug:   "concurrency-test-coin",
+		Stock:  stock,
+		Price:  money.FromFloat(100),
+		GST:    3,
+		Status: models.ItemStatusPublished,
+	}
+	if err := db.GetDB().Create(&item).Error; err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+func newOrder(t *testing.T) models.Orders {
+	t.Helper()
+	order := models.Orders{Currency: money.INR}
+	if err := db.GetDB().Create(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+func newUser(t *testing.T) models.User {
+	t.Helper()
+	user := models.User{Username: "concurrency-test", Email: uuid.NewString() + "@example.com"}
+	if err := db.GetDB().Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// checkStock asserts that the item's stock and the quantities its live order
+// items hold add up to what it started with, and that each order's total is
+// the sum of its live lines.
+func checkStock(t *testing.T, item_id uuid.UUID, initial int) {
+	t.Helper()
+	var item models.Item
+	if err := db.GetDB().First(&item, item_id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if item.Stock < 0 {
+		t.Fatalf("stock went negative: %d", item.Stock)
+	}
+
+	var held int
+	if err := db.GetDB().Model(&models.OrderItem{}).Select("COALESCE(SUM(quantity), 0)").
+		Where("item_id = ? AND order_item_status NOT IN ?", item_id, []string{"cancelled", "expired"}).
+		Joins("JOIN orders ON orders.id = order_items.order_id AND orders.status <> ?", "cancelled").
+		Scan(&held).Error; err != nil {
+		t.Fatal(err)
+	}
+	if item.Stock+held != initial {
+		t.Fatalf("stock %d and %d held by orders do not add up to %d", item.Stock, held, initial)
+	}
+
+	var mismatched int64
+	if err := db.GetDB().Raw(`SELECT COUNT(*) FROM orders o WHERE o.id IN (
+			SELECT order_id FROM order_items WHERE item_id = ?)
+		AND o.billable_amount <> (SELECT COALESCE(SUM(billable_amount), 0) FROM order_items oi
+			WHERE oi.order_id = o.id AND oi.order_item_status NOT IN ('cancelled', 'expired'))`, item_id).
+		Scan(&mismatched).Error; err != nil {
+		t.Fatal(err)
+	}
+	if mismatched > 0 {
+		t.Fatalf("%d orders have a total that is not the sum of their lines", mismatched)
+	}
+}
+
+func TestParallelAddsToOneOrder(t *testing.T) {
+	requireDB(t)
+	app := newApp()
+	item := newItem(t, 5)
+	order := newOrder(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			send(t, app, "POST", "/order/item/add", map[string]interface{}{
+				"order_id": order.ID,
+				"item_id":  item.ID,
+				"quantity": 1,
+			})
+		}()
+	}
+	wg.Wait()
+
+	checkStock(t, item.ID, 5)
+}
+
+func TestParallelAddsAcrossOrders(t *testing.T) {
+	requireDB(t)
+	app := newApp()
+	item := newItem(t, 5)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		order := newOrder(t)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			send(t, app, "POST", "/order/item/add", map[string]interface{}{
+				"order_id": order.ID,
+				"item_id":  item.ID,
+				"quantity": 1,
+			})
+		}()
+	}
+	wg.Wait()
+
+	checkStock(t, item.ID, 5)
+}
+
+func TestParallelQuantityChangesAndCancel(t *testing.T) {
+	requireDB(t)
+	app := newApp()
+	item := newItem(t, 10)
+	order := newOrder(t)
+	user := newUser(t)
+
+	if status := send(t, app, "POST", "/order/item/add", map[string]interface{}{
+		"order_id": order.ID,
+		"item_id":  item.ID,
+		"quantity": 1,
+	}); status != fiber.StatusOK && status != fiber.StatusCreated {
+		t.Fatalf("adding the item failed with %d", status)
+	}
+	var orderItem models.OrderItem
+	if err := db.GetDB().Where("order_id = ?", order.ID).First(&orderItem).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		quantity := i%8 + 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			send(t, app, "PATCH", "/order/item/update-quantity", map[string]interface{}{
+				"order_item_id": orderItem.ID,
+				"quantity":      quantity,
+			})
+		}()
+		if i == workers/2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				send(t, app, "PATCH", "/order/"+order.ID.String()+"/cancel", map[string]interface{}{
+					"user_id":             user.ID,
+					"cancellation_reason": "concurrency test",
+				})
+			}()
+		}
+	}
+	wg.Wait()
+
+	if err := db.GetDB().First(&order, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "cancelled" {
+		t.Fatalf("order is %s, want cancelled", order.Status)
+	}
+	// a cancelled order holds nothing, so every unit is back in stock
+	checkStock(t, item.ID, 10)
+
+	if status := send(t, app, "PATCH", "/order/item/update-quantity", map[string]interface{}{
+		"order_item_id": orderItem.ID,
+		"quantity":      2,
+	}); status != fiber.StatusBadRequest {
+		t.Fatalf("changing the quantity on a cancelled order returned %d, want 400", status)
+	}
+	checkStock(t, item.ID, 10)
+}
@@ -7,13 +7,16 @@ import (
 	"log"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/Baalamurgan/coin-selling-backend/api/db"
 	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
 	"github.com/Baalamurgan/coin-selling-backend/api/utils"
 	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/inventory"
+	itemPkg "github.com/Baalamurgan/coin-selling-backend/pkg/item"
 	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/pricing"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/datatypes"
@@ -127,7 +130,7 @@ func CreateOrder(c *fiber.Ctx) error {
 	}
 
 	orderDBQuery := db.GetDB().Model(&models.Orders{})
-	newOrder := models.Orders{}
+	newOrder := models.Orders{Currency: money.INR}
 
 	if req.UserID != "" {
 		user_id, err := uuid.Parse(req.UserID)
@@ -180,7 +183,19 @@ func AddItemToOrder(c *fiber.Ctx) error {
 		return views.InvalidParams(c)
 	}
 
+	var unitIDs []uuid.UUID
+	for _, unitID := range req.UnitIDs {
+		parsedUnitID, err := uuid.Parse(unitID)
+		if err != nil {
+			return views.BadRequest(c)
+		}
+		unitIDs = append(unitIDs, parsedUnitID)
+	}
+
 	quantity := req.Quantity
+	if len(unitIDs) > 0 {
+		quantity = len(unitIDs)
+	}
 	if quantity < 1 {
 		return views.BadRequest(c)
 	}
@@ -195,76 +210,135 @@ func AddItemToOrder(c *fiber.Ctx) error {
 		return views.BadRequest(c)
 	}
 
-	var item models.Item
-	if err := db.GetDB().First(&item, item_id).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return views.RecordNotFound(c)
+	var orderItem models.OrderItem
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		// the order is locked before its items, like every other change to it
+		order, err := lockOrder(tx, order_id)
+		if err != nil {
+			return err
+		}
+		if OrderStatus(order.Status) != StatusPending {
+			return ErrOrderConfirmed
 		}
-		return views.InternalServerError(c, err)
-	}
 
-	if quantity > item.Stock {
-		return views.BadRequestWithMessage(c, "requested quantity exceeds available stock")
-	}
+		var parent models.Item
+		if err := tx.First(&parent, item_id).Error; err != nil {
+			return err
+		}
+		variant, err := itemPkg.ResolveVariant(tx, &parent, req.VariantID, req.Grade)
+		if err != nil {
+			return err
+		}
 
-	var order models.Orders
-	if err := db.GetDB().
-		Model(&models.Orders{}).
-		Where("id = ?", order_id).First(&order).Error; err != nil {
-		return views.InternalServerError(c, err)
-	}
+		item, err := inventory.LockItem(tx, variant.ID)
+		if err != nil {
+			return err
+		}
 
-	if strings.Compare(order.Status, "pending") != 0 {
-		return views.BadRequestWithMessage(c, "order confirmed already")
-	}
+		if item.Status != models.ItemStatusPublished {
+			return ErrItemNotForSale
+		}
+		if len(unitIDs) > 0 && !item.IsSerialised {
+			return ErrItemNotSerialised
+		}
+		if quantity > item.Stock {
+			return ErrQuantityExceedsStock
+		}
 
-	itemBillableAmount := item.Price*float64(quantity) + item.Price*float64(quantity)*float64((item.GST/100))
+		line, err := pricing.ForItem(tx, item, quantity, "")
+		if err != nil {
+			return err
+		}
 
-	metadata, _ := json.Marshal(map[string]interface{}{
-		"category_id": item.CategoryID,
-		"name":        item.Name,
-		"description": item.Description,
-		"year":        item.Year,
-		"sku":         item.SKU,
-		"image_url":   item.ImageURL,
-		"stock":       item.Stock,
-		"sold":        item.Sold,
-		"price":       item.Price,
-		"gst":         item.GST,
-		"details":     item.Details,
-	})
+		itemMetadata := map[string]interface{}{
+			"category_id": item.CategoryID,
+			"name":        item.Name,
+			"description": item.Description,
+			"year":        item.Year,
+			"sku":         item.SKU,
+			"image_url":   item.ImageURL,
+			"stock":       item.Stock,
+			"sold":        item.Sold,
+			"hsn":         item.HSN,
+			"details":     item.Details,
+		}
+		if item.ParentID != nil {
+			itemMetadata["parent_id"] = item.ParentID
+			itemMetadata["grade"] = item.Grade
+		}
 
-	orderItem := models.OrderItem{
-		OrderID:            order_id,
-		ItemID:             item_id,
-		BillableAmount:     itemBillableAmount,
-		BillableAmountPaid: 0,
-		Quantity:           quantity,
-		OrderItemStatus:    "pending",
-		MetaData:           datatypes.JSON(metadata),
-	}
+		if item.IsBundle {
+			var components []models.BundleComponent
+			if err := tx.Preload("Component").Where("bundle_id = ?", item.ID).Find(&components).Error; err != nil {
+				return err
+			}
+			var bundleComponents []map[string]interface{}
+			for _, component := range components {
+				if component.Component == nil {
+					continue
+				}
+				bundleComponents = append(bundleComponents, map[string]interface{}{
+					"item_id":  component.ComponentID,
+					"sku":      component.Component.SKU,
+					"name":     component.Component.Name,
+					"quantity": component.Quantity,
+				})
+			}
+			itemMetadata["components"] = bundleComponents
+			itemMetadata["bundle_discount"] = item.BundleDiscount
+		}
+		for key, value := range line.Metadata() {
+			itemMetadata[key] = value
+		}
 
-	if err := db.GetDB().Model(&models.OrderItem{}).Create(&orderItem).Error; err != nil {
-		return views.InternalServerError(c, err)
-	}
+		orderItem = models.OrderItem{
+			ID:                 uuid.New(),
+			OrderID:            order_id,
+			ItemID:             item.ID,
+			BillableAmount:     line.Total,
+			BillableAmountPaid: 0,
+			Quantity:           quantity,
+			OrderItemStatus:    "pending",
+		}
 
-	result := db.GetDB().Model(&models.Orders{}).Where("id = ?", order_id).Updates(map[string]interface{}{
-		"billable_amount": order.BillableAmount + itemBillableAmount,
-	})
-	if result.Error != nil {
-		return views.InternalServerError(c, result.Error)
-	} else if result.RowsAffected == 0 {
-		return views.RecordNotFound(c)
-	}
+		if item.IsSerialised {
+			units, err := inventory.AllocateUnits(tx, item.ID, orderItem.ID, quantity, unitIDs)
+			if err != nil {
+				return err
+			}
+			var serialNumbers []string
+			for _, unit := range units {
+				serialNumbers = append(serialNumbers, unit.SerialNumber)
+			}
+			itemMetadata["serial_numbers"] = serialNumbers
+		}
 
-	itemResult := db.GetDB().Model(&models.Item{}).Where("id = ?", item.ID).Updates(map[string]interface{}{
-		"sold":  gorm.Expr("sold + ?", orderItem.Quantity),
-		"stock": gorm.Expr("stock - ?", orderItem.Quantity),
-	})
-	if itemResult.Error != nil {
-		log.Println(itemResult.Error)
-	} else if itemResult.RowsAffected == 0 {
-		log.Println("Stock update failed for item:", orderItem.ItemID)
+		metadata, _ := json.Marshal(itemMetadata)
+		orderItem.MetaData = datatypes.JSON(metadata)
+		if err := tx.Model(&models.OrderItem{}).Create(&orderItem).Error; err != nil {
+			return err
+		}
+		if err := inventory.Reserve(tx, item.ID, order_id, orderItem.ID, quantity); err != nil {
+			return err
+		}
+		if err := recalculateOrderTotal(tx, order_id); err != nil {
+			return err
+		}
+		return recordEvent(tx, models.OrderEvent{
+			OrderID:     order_id,
+			OrderItemID: &orderItem.ID,
+			ActorID:     actorID(req.UserID),
+			Type:        models.OrderEventItemAdded,
+		}, map[string]interface{}{
+			"item_id":         item.ID,
+			"sku":             item.SKU,
+			"quantity":        quantity,
+			"price":           line.TierPrice,
+			"price_tier":      line.Tier,
+			"billable_amount": line.Total,
+		})
+	}); err != nil {
+		return orderChangeFailed(c, err)
 	}
 
 	return views.StatusOK(c, orderItem)
@@ -289,44 +363,81 @@ func UpdateOrderItemQuantity(c *fiber.Ctx) error {
 		return views.BadRequestWithMessage(c, "quantity must be at least 1")
 	}
 
-	var orderItem models.OrderItem
-	if err := db.GetDB().First(&orderItem, order_item_id).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return views.RecordNotFound(c)
-		}
+	var order_id uuid.UUID
+	if err := db.GetDB().Model(&models.OrderItem{}).Where("id = ?", order_item_id).Pluck("order_id", &order_id).Error; err != nil {
 		return views.InternalServerError(c, err)
+	} else if order_id == uuid.Nil {
+		return views.RecordNotFound(c)
 	}
 
-	var item models.Item
-	if err := db.GetDB().First(&item, orderItem.ItemID).Error; err != nil {
-		return views.InternalServerError(c, err)
-	}
+	var orderItem *models.OrderItem
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		order, err := lockOrder(tx, order_id)
+		if err != nil {
+			return err
+		}
+		if status := OrderStatus(order.Status); status != StatusPending && status != StatusBooked {
+			return ErrOrderNotEditable
+		}
+		orderItem, err = lockOrderItem(tx, order_item_id)
+		if err != nil {
+			return err
+		}
+		if orderItem.OrderItemStatus == "expired" {
+			return ErrReservationExpired
+		} else if orderItem.OrderItemStatus == "cancelled" {
+			return ErrOrderItemCancelled
+		}
 
-	availableStock := item.Stock + orderItem.Quantity
-	if newQuantity > availableStock {
-		return views.BadRequestWithMessage(c, "requested quantity exceeds available stock")
-	}
+		item, err := inventory.LockItem(tx, orderItem.ItemID)
+		if err != nil {
+			return err
+		}
 
-	newBillableAmount := item.Price*float64(newQuantity) + item.Price*float64(newQuantity)*float64((item.GST/100))
-	diffBillableAmount := newBillableAmount - orderItem.BillableAmount
-	diff := newQuantity - orderItem.Quantity
+		availableStock := item.Stock + orderItem.Quantity
+		if newQuantity > availableStock {
+			return ErrQuantityExceedsStock
+		}
 
-	if err := db.GetDB().Model(&orderItem).Updates(map[string]interface{}{
-		"quantity":        newQuantity,
-		"billable_amount": newBillableAmount,
-	}).Error; err != nil {
-		return views.InternalServerError(c, err)
-	}
+		// the line keeps the price it was sold at
+		line, err := pricing.ForOrderItem(tx, orderItem, newQuantity, nil, "")
+		if err != nil {
+			return err
+		}
+		newBillableAmount := line.Total
+		diff := newQuantity - orderItem.Quantity
 
-	if err := db.GetDB().Model(&models.Orders{}).Where("id = ?", orderItem.OrderID).Update("billable_amount", gorm.Expr("billable_amount + ?", diffBillableAmount)).Error; err != nil {
-		return views.InternalServerError(c, err)
-	}
+		if item.IsSerialised {
+			if err := adjustUnitAllocation(tx, item, orderItem, diff); err != nil {
+				return err
+			}
+		}
+		if err := adjustOrderItemStock(tx, order, orderItem, diff); err != nil {
+			return err
+		}
+		if err := recordEvent(tx, models.OrderEvent{
+			OrderID:     order.ID,
+			OrderItemID: &orderItem.ID,
+			ActorID:     actorID(req.UserID),
+			Type:        models.OrderEventQuantityChanged,
+		}, map[string]interface{}{
+			"item_id":         orderItem.ItemID,
+			"from_quantity":   orderItem.Quantity,
+			"to_quantity":     newQuantity,
+			"billable_amount": newBillableAmount,
+		}); err != nil {
+			return err
+		}
 
-	if err := db.GetDB().Model(&models.Item{}).Where("id = ?", item.ID).Updates(map[string]interface{}{
-		"stock": gorm.Expr("stock - ?", diff),
-		"sold":  gorm.Expr("sold + ?", diff),
-	}).Error; err != nil {
-		log.Println(err)
+		if err := tx.Model(orderItem).Updates(map[string]interface{}{
+			"quantity":        newQuantity,
+			"billable_amount": newBillableAmount,
+		}).Error; err != nil {
+			return err
+		}
+		return recalculateOrderTotal(tx, order.ID)
+	}); err != nil {
+		return orderChangeFailed(c, err)
 	}
 
 	return views.StatusOK(c, orderItem)
@@ -343,87 +454,131 @@ func EditOrder(c *fiber.Ctx) error {
 		return views.BadRequest(c)
 	}
 
-	var order models.Orders
-	if err := db.GetDB().First(&order, order_id).Error; err != nil {
-		return views.RecordNotFound(c)
-	}
-
-	if order.Status != "pending" && order.Status != "booked" {
-		return views.BadRequestWithMessage(c, "Cannot edit a paid order")
-	}
-
-	tx := db.GetDB().Begin()
-
-	var totalBillableAmount float64 = 0
-
+	var orderItemIDs []uuid.UUID
 	for _, item := range req.OrderItems {
 		order_item_id, err := uuid.Parse(item.OrderItemID)
 		if err != nil {
 			return views.BadRequest(c)
 		}
+		orderItemIDs = append(orderItemIDs, order_item_id)
+	}
 
-		var orderItem models.OrderItem
-		if err := tx.First(&orderItem, order_item_id).Error; err != nil {
-			tx.Rollback()
-			return views.RecordNotFound(c)
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		order, err := lockOrder(tx, order_id)
+		if err != nil {
+			return err
 		}
 
-		var product models.Item
-		if err := tx.First(&product, orderItem.ItemID).Error; err != nil {
-			tx.Rollback()
-			return views.RecordNotFound(c)
+		if status := OrderStatus(order.Status); status != StatusPending && status != StatusBooked {
+			return ErrOrderNotEditable
 		}
 
-		updateData := map[string]interface{}{}
-
-		if item.Quantity != nil {
-			if *item.Quantity < 1 {
-				tx.Rollback()
-				return views.BadRequestWithMessage(c, "quantity cannot be less than 1")
+		for i, item := range req.OrderItems {
+			orderItem, err := lockOrderItem(tx, orderItemIDs[i])
+			if err != nil {
+				return err
+			}
+			if orderItem.OrderID != order.ID {
+				return ErrOrderItemNotOnOrder
+			}
+			if orderItem.OrderItemStatus == "expired" {
+				return ErrReservationExpired
+			} else if orderItem.OrderItemStatus == "cancelled" {
+				return ErrOrderItemCancelled
 			}
 
-			diff := *item.Quantity - orderItem.Quantity
-
-			if product.Stock < diff {
-				tx.Rollback()
-				return views.BadRequestWithMessage(c, "not enough stock available")
+			product, err := inventory.LockItem(tx, orderItem.ItemID)
+			if err != nil {
+				return err
 			}
 
-			tx.Model(&models.Item{}).Where("id = ?", product.ID).
-				Updates(map[string]interface{}{
-					"sold":  gorm.Expr("sold + ?", diff),
-					"stock": gorm.Expr("stock - ?", diff),
-				})
+			if item.Quantity == nil && item.PricePerItem == nil {
+				continue
+			}
+			if item.PricePerItem != nil && *item.PricePerItem < 0 {
+				return ErrInvalidPrice
+			}
 
-			updateData["quantity"] = *item.Quantity
-		}
+			updateData := map[string]interface{}{}
+			quantity := orderItem.Quantity
+
+			if item.Quantity != nil {
+				if *item.Quantity < 1 {
+					return ErrQuantityBelowOne
+				}
+
+				diff := *item.Quantity - orderItem.Quantity
+				if product.Stock < diff {
+					return inventory.ErrInsufficientStock
+				}
+
+				if product.IsSerialised {
+					if err := adjustUnitAllocation(tx, product, orderItem, diff); err != nil {
+						return err
+					}
+				}
+				if err := adjustOrderItemStock(tx, order, orderItem, diff); err != nil {
+					return err
+				}
+
+				quantity = *item.Quantity
+				updateData["quantity"] = quantity
+
+				if err := recordEvent(tx, models.OrderEvent{
+					OrderID:     order.ID,
+					OrderItemID: &orderItem.ID,
+					ActorID:     actorID(req.UserID),
+					Type:        models.OrderEventQuantityChanged,
+				}, map[string]interface{}{
+					"item_id":       orderItem.ItemID,
+					"from_quantity": orderItem.Quantity,
+					"to_quantity":   quantity,
+				}); err != nil {
+					return err
+				}
+			}
 
-		if item.PricePerItem != nil {
-			newBillableAmount := *item.PricePerItem * float64(*item.Quantity)
-			updateData["billable_amount"] = newBillableAmount * (1 + (product.GST / 100))
-		}
+			// the line keeps the price it was sold at unless it is overridden
+			line, err := pricing.ForOrderItem(tx, orderItem, quantity, item.PricePerItem, "")
+			if err != nil {
+				return err
+			}
+			updateData["billable_amount"] = line.Total
+
+			if item.PricePerItem != nil {
+				metadata, err := withPricing(orderItem.MetaData, line)
+				if err != nil {
+					return err
+				}
+				updateData["meta_data"] = metadata
+
+				if err := recordEvent(tx, models.OrderEvent{
+					OrderID:     order.ID,
+					OrderItemID: &orderItem.ID,
+					ActorID:     actorID(req.UserID),
+					Type:        models.OrderEventPriceOverride,
+				}, map[string]interface{}{
+					"item_id":              orderItem.ItemID,
+					"price_per_item":       *item.PricePerItem,
+					"from_billable_amount": orderItem.BillableAmount,
+					"to_billable_amount":   line.Total,
+				}); err != nil {
+					return err
+				}
+			}
 
-		if len(updateData) > 0 {
 			if err := tx.Model(&models.OrderItem{}).
 				Where("id = ?", orderItem.ID).
 				Updates(updateData).Error; err != nil {
-				tx.Rollback()
-				return views.InternalServerError(c, err)
+				return err
 			}
 		}
 
-		totalBillableAmount += orderItem.BillableAmount
+		return recalculateOrderTotal(tx, order.ID)
+	}); err != nil {
+		return orderChangeFailed(c, err)
 	}
 
-	if err := tx.Model(&models.Orders{}).
-		Where("id = ?", order_id).
-		Update("billable_amount", totalBillableAmount).Error; err != nil {
-		tx.Rollback()
-		return views.InternalServerError(c, err)
-	}
-
-	tx.Commit()
-
 	return views.StatusOK(c, "order updated successfully")
 }
 
@@ -437,49 +592,59 @@ func DeleteOrderItemFromOrder(c *fiber.Ctx) error {
 		return views.BadRequest(c)
 	}
 
-	orderDBQuery := db.GetDB().Model(&models.Orders{}).Where("id = ?", order_id)
-
-	var order models.Orders
-	if err := orderDBQuery.First(&order).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return views.RecordNotFound(c)
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		order, err := lockOrder(tx, order_id)
+		if err != nil {
+			return err
+		}
+		if OrderStatus(order.Status) != StatusPending {
+			return ErrOrderConfirmed
 		}
-		return views.InternalServerError(c, err)
-	}
 
-	if strings.Compare(order.Status, "pending") != 0 {
-		return views.BadRequestWithMessage(c, "order confirmed already")
-	}
+		orderItem, err := lockOrderItem(tx, order_item_id)
+		if err != nil {
+			return err
+		}
+		if orderItem.OrderID != order.ID {
+			return gorm.ErrRecordNotFound
+		}
 
-	var orderItem models.OrderItem
-	if err := db.GetDB().Model(&models.OrderItem{}).First(&orderItem, order_item_id).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return views.RecordNotFound(c)
+		item, err := inventory.LockItem(tx, orderItem.ItemID)
+		if err != nil {
+			return err
 		}
-		return views.InternalServerError(c, err)
-	}
 
-	if err := db.GetDB().Model(&models.Orders{}).Where("id = ?", order_id).Updates(map[string]interface{}{
-		"billable_amount": order.BillableAmount - orderItem.BillableAmount,
-	}).Error; err != nil {
-		return views.InternalServerError(c, err)
-	}
+		if item.IsSerialised {
+			if err := inventory.ReleaseUnits(tx, item.ID, orderItem.ID, 0); err != nil {
+				return err
+			}
+		}
 
-	result := db.GetDB().Where("order_id = ? AND id = ?", order_id, order_item_id).Delete(&models.OrderItem{})
-	if result.Error != nil {
-		return views.InternalServerError(c, result.Error)
-	} else if result.RowsAffected == 0 {
-		return views.RecordNotFound(c)
-	}
+		result := tx.Where("order_id = ? AND id = ?", order_id, order_item_id).Delete(&models.OrderItem{})
+		if result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
 
-	itemResult := db.GetDB().Model(&models.Item{}).Where("id = ?", orderItem.ItemID).Updates(map[string]interface{}{
-		"sold":  gorm.Expr("sold - ?", orderItem.Quantity),
-		"stock": gorm.Expr("stock + ?", orderItem.Quantity),
-	})
-	if itemResult.Error != nil {
-		log.Println(itemResult.Error)
-	} else if itemResult.RowsAffected == 0 {
-		log.Println("Stock update failed for item:", orderItem.ItemID)
+		if err := releaseOrderItemStock(tx, order, orderItem); err != nil {
+			return err
+		}
+		if err := recalculateOrderTotal(tx, order_id); err != nil {
+			return err
+		}
+		return recordEvent(tx, models.OrderEvent{
+			OrderID:     order_id,
+			OrderItemID: &orderItem.ID,
+			ActorID:     actorID(c.Query("user_id")),
+			Type:        models.OrderEventItemRemoved,
+		}, map[string]interface{}{
+			"item_id":         orderItem.ItemID,
+			"quantity":        orderItem.Quantity,
+			"billable_amount": orderItem.BillableAmount,
+		})
+	}); err != nil {
+		return orderChangeFailed(c, err)
 	}
 
 	return views.StatusOK(c, "order item deleted")
@@ -511,31 +676,14 @@ func ConfirmOrder(c *fiber.Ctx) error {
 		return views.InternalServerError(c, err)
 	}
 
-	var order models.Orders
-	if err := db.GetDB().Where("id = ?", order_id).Preload("OrderItems").Find(&order).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return views.RecordNotFound(c)
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		order, err := lockOrder(tx, order_id)
+		if err != nil {
+			return err
 		}
-		return views.InternalServerError(c, err)
-	}
-
-	if strings.Compare(order.Status, "cancelled") == 0 {
-		return views.BadRequestWithMessage(c, "order has already been cancelled")
-	}
-
-	if len(order.OrderItems) <= 0 {
-		return views.BadRequestWithMessage(c, "order invalid")
-	}
-
-	result := db.GetDB().Model(&models.Orders{}).Where("id = ?", order_id).Updates(map[string]interface{}{
-		"status":      "booked",
-		"user_id":     user_id,
-		"status_date": time.Now().Unix(),
-	})
-	if result.Error != nil {
-		return views.InternalServerError(c, result.Error)
-	} else if result.RowsAffected == 0 {
-		return views.RecordNotFound(c)
+		return Fire(tx, order, ActionConfirm, user_id, nil)
+	}); err != nil {
+		return transitionFailed(c, err)
 	}
 
 	return views.StatusOK(c, "order confirmed")
@@ -567,37 +715,39 @@ func MarkOrderAsPaid(c *fiber.Ctx) error {
 		return views.InternalServerError(c, err)
 	}
 
-	var order models.Orders
-	if err := db.GetDB().Where("id = ?", order_id).Find(&order).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return views.RecordNotFound(c)
-		}
-		return views.InternalServerError(c, err)
+	if req.BillableAmountPaid <= 0 {
+		return views.BadRequestWithMessage(c, ErrInvalidPaymentAmount.Error())
 	}
 
-	if strings.Compare(order.Status, "cancelled") == 0 {
-		return views.BadRequestWithMessage(c, "order has already been cancelled")
+	method := req.Method
+	if method == "" {
+		method = models.PaymentMethodCash
 	}
-
-	if strings.Compare(order.Status, "booked") != 0 {
-		return views.BadRequestWithMessage(c, "order must be confirmed before payment")
+	payment := models.Payment{
+		Amount:     req.BillableAmountPaid,
+		Method:     method,
+		Reference:  req.Reference,
+		RecordedBy: user_id,
 	}
 
-	if req.BillableAmountPaid < 0 {
-		return views.BadRequestWithMessage(c, "invalid payment amount")
+	var status string
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		order, err := lockOrder(tx, order_id)
+		if err != nil {
+			return err
+		}
+		if err := recordPayment(tx, order, &payment, false); err != nil {
+			return err
+		}
+		status = order.Status
+		return nil
+	}); err != nil {
+		return transitionFailed(c, err)
 	}
 
-	result := db.GetDB().Model(&models.Orders{}).Where("id = ?", order_id).Updates(map[string]interface{}{
-		"status":               "paid",
-		"billable_amount_paid": req.BillableAmountPaid,
-		"status_date":          time.Now().Unix(),
-	})
-	if result.Error != nil {
-		return views.InternalServerError(c, result.Error)
-	} else if result.RowsAffected == 0 {
-		return views.RecordNotFound(c)
+	if OrderStatus(status) == StatusPartiallyPaid {
+		return views.StatusOK(c, "order partially paid")
 	}
-
 	return views.StatusOK(c, "order paid")
 }
 
@@ -627,20 +777,23 @@ func MarkOrderAsShipped(c *fiber.Ctx) error {
 		return views.InternalServerError(c, err)
 	}
 
-	var order models.Orders
-	if err := db.GetDB().Where("id = ?", order_id).First(&order).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return views.RecordNotFound(c)
+	var location_id *uuid.UUID
+	if req.LocationID != "" {
+		parsedLocationID, err := uuid.Parse(req.LocationID)
+		if err != nil {
+			return views.BadRequest(c)
 		}
-		return views.InternalServerError(c, err)
-	}
-
-	if strings.Compare(order.Status, "cancelled") == 0 {
-		return views.BadRequestWithMessage(c, "order has already been cancelled")
-	}
-
-	if strings.Compare(order.Status, "paid") != 0 {
-		return views.BadRequestWithMessage(c, "order has not been paid yet")
+		var location models.Location
+		if err := db.GetDB().Where("id = ?", parsedLocationID).First(&location).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return views.RecordNotFound(c)
+			}
+			return views.InternalServerError(c, err)
+		}
+		if !location.IsActive {
+			return views.BadRequestWithMessage(c, inventory.ErrLocationInactive.Error())
+		}
+		location_id = &parsedLocationID
 	}
 
 	var shippingDetails models.ShippingDetails
@@ -650,19 +803,28 @@ func MarkOrderAsShipped(c *fiber.Ctx) error {
 	shippingDetails.ShippingID = req.ShippingID
 	shippingDetails.ShippingDate = req.ShippingDate
 
-	if err := db.GetDB().Create(&shippingDetails).Error; err != nil {
-		return views.InternalServerError(c, err)
-	}
-
-	result := db.GetDB().Model(&models.Orders{}).Where("id = ?", order_id).Updates(map[string]interface{}{
-		"status":      "shipped",
-		"shipping_id": shippingDetails.ID,
-		"status_date": time.Now().Unix(),
-	})
-	if result.Error != nil {
-		return views.InternalServerError(c, result.Error)
-	} else if result.RowsAffected == 0 {
-		return views.RecordNotFound(c)
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		order, err := lockOrder(tx, order_id)
+		if err != nil {
+			return err
+		}
+		return Fire(tx, order, ActionShip, user_id, func(tx *gorm.DB, updates map[string]interface{}) error {
+			// the goods physically leave the fulfilment location when shipped
+			if err := inventory.FulfilOrder(tx, order_id, location_id); err != nil {
+				return err
+			}
+			if err := tx.Create(&shippingDetails).Error; err != nil {
+				return err
+			}
+			updates["shipping_id"] = shippingDetails.ID
+			updates["fulfilment_location_id"] = location_id
+			return nil
+		})
+	}); err != nil {
+		if errors.Is(err, inventory.ErrLocationRequired) || errors.Is(err, inventory.ErrInsufficientLocationStock) {
+			return views.BadRequestWithMessage(c, err.Error())
+		}
+		return transitionFailed(c, err)
 	}
 
 	return views.StatusOK(c, "order shipped")
@@ -694,33 +856,39 @@ func CancelOrder(c *fiber.Ctx) error {
 		return views.InternalServerError(c, err)
 	}
 
-	var order models.Orders
-	if err := db.GetDB().Where("id = ?", order_id).First(&order).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return views.RecordNotFound(c)
+	var refunds []models.Refund
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		order, err := lockOrder(tx, order_id)
+		if err != nil {
+			return err
 		}
-		return views.InternalServerError(c, err)
-	}
-
-	if strings.Compare(order.Status, "cancelled") == 0 {
-		return views.BadRequestWithMessage(c, "order has already been cancelled")
-	}
-
-	if strings.Compare(order.Status, "delivered") == 0 {
-		return views.BadRequestWithMessage(c, "delivered orders cannot be cancelled")
+		if err := Fire(tx, order, ActionCancel, user_id, func(tx *gorm.DB, updates map[string]interface{}) error {
+			updates["cancellation_reason"] = req.CancellationReason
+			return nil
+		}); err != nil {
+			return err
+		}
+		if !req.Refund {
+			return nil
+		}
+		if order, err = lockOrder(tx, order_id); err != nil {
+			return err
+		}
+		refunds, err = refundCancelledOrder(tx, order, user_id, req.CancellationReason)
+		return err
+	}); err != nil {
+		return transitionFailed(c, err)
 	}
 
-	result := db.GetDB().Model(&models.Orders{}).Where("id = ?", order_id).Updates(map[string]interface{}{
-		"status":              "cancelled",
-		"cancellation_reason": req.CancellationReason,
-		"status_date":         time.Now().Unix(),
-	})
-	if result.Error != nil {
-		return views.InternalServerError(c, result.Error)
-	} else if result.RowsAffected == 0 {
-		return views.RecordNotFound(c)
+	// the order stays cancelled if the gateway turns a refund down; the
+	// failure is on the order's timeline for the refund to be retried
+	if err := submitRefunds(c.Context(), refunds); err != nil {
+		log.Println("Refunding cancelled order", order_id, "failed:", err)
+		return views.StatusOK(c, fiber.Map{
+			"message":      "order cancelled",
+			"refund_error": err.Error(),
+		})
 	}
-
 	return views.StatusOK(c, "order cancelled")
 }
 
@@ -750,22 +918,6 @@ func MarkOrderAsDelivered(c *fiber.Ctx) error {
 		return views.InternalServerError(c, err)
 	}
 
-	var order models.Orders
-	if err := db.GetDB().Where("id = ?", order_id).First(&order).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return views.RecordNotFound(c)
-		}
-		return views.InternalServerError(c, err)
-	}
-
-	if strings.Compare(order.Status, "cancelled") == 0 {
-		return views.BadRequestWithMessage(c, "order has already been cancelled")
-	}
-
-	if strings.Compare(order.Status, "shipped") != 0 {
-		return views.BadRequestWithMessage(c, "order has not been shipped yet")
-	}
-
 	var deliveryDetails models.DeliveryDetails
 	deliveryDetails.OrderID = order_id
 	deliveryDetails.UserID = user_id
@@ -773,19 +925,20 @@ func MarkOrderAsDelivered(c *fiber.Ctx) error {
 	deliveryDetails.DeliveryID = req.DeliveryID
 	deliveryDetails.DeliveryDate = req.DeliveryDate
 
-	if err := db.GetDB().Create(&deliveryDetails).Error; err != nil {
-		return views.InternalServerError(c, err)
-	}
-
-	result := db.GetDB().Model(&models.Orders{}).Where("id = ?", order_id).Updates(map[string]interface{}{
-		"status":      "delivered",
-		"delivery_id": deliveryDetails.ID,
-		"status_date": time.Now().Unix(),
-	})
-	if result.Error != nil {
-		return views.InternalServerError(c, result.Error)
-	} else if result.RowsAffected == 0 {
-		return views.RecordNotFound(c)
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		order, err := lockOrder(tx, order_id)
+		if err != nil {
+			return err
+		}
+		return Fire(tx, order, ActionDeliver, user_id, func(tx *gorm.DB, updates map[string]interface{}) error {
+			if err := tx.Create(&deliveryDetails).Error; err != nil {
+				return err
+			}
+			updates["delivery_id"] = deliveryDetails.ID
+			return nil
+		})
+	}); err != nil {
+		return transitionFailed(c, err)
 	}
 
 	return views.StatusOK(c, "order delivered")
@@ -817,6 +970,27 @@ func RestoreOrder(c *fiber.Ctx) error {
 		return views.InternalServerError(c, err)
 	}
 
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		order, err := lockOrder(tx, order_id)
+		if err != nil {
+			return err
+		}
+		return Fire(tx, order, ActionRestore, user_id, nil)
+	}); err != nil {
+		return transitionFailed(c, err)
+	}
+
+	return views.StatusOK(c, "order restored")
+}
+
+// GetOrderTransitions lists the actions that can move the order on from its
+// current status, and whether their guards allow them right now.
+func GetOrderTransitions(c *fiber.Ctx) error {
+	order_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
 	var order models.Orders
 	if err := db.GetDB().Where("id = ?", order_id).First(&order).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -825,19 +999,27 @@ func RestoreOrder(c *fiber.Ctx) error {
 		return views.InternalServerError(c, err)
 	}
 
-	if strings.Compare(order.Status, "cancelled") != 0 {
-		return views.BadRequestWithMessage(c, "order is not cancelled")
+	available, err := availableTransitions(db.GetDB(), &order)
+	if err != nil {
+		return views.InternalServerError(c, err)
 	}
-
-	result := db.GetDB().Model(&models.Orders{}).Where("id = ?", order_id).Updates(map[string]interface{}{
-		"status":      "pending",
-		"status_date": time.Now().Unix(),
+	return views.StatusOK(c, fiber.Map{
+		"order_id":    order.ID,
+		"status":      order.Status,
+		"transitions": available,
 	})
-	if result.Error != nil {
-		return views.InternalServerError(c, result.Error)
-	} else if result.RowsAffected == 0 {
+}
+
+func transitionFailed(c *fiber.Ctx, err error) error {
+	var transitionErr *TransitionError
+	if errors.As(err, &transitionErr) {
+		return views.BadRequestWithMessage(c, transitionErr.Message)
+	}
+	if errors.Is(err, inventory.ErrInsufficientStock) || errors.Is(err, inventory.ErrUnitsUnavailable) {
+		return views.BadRequestWithMessage(c, err.Error())
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return views.RecordNotFound(c)
 	}
-
-	return views.StatusOK(c, "order restored")
+	return views.InternalServerError(c, err)
 }
@@ -0,0 +1,103 @@
+package orders
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// recordEvent appends an event to the order's timeline. It is called in the
+// transaction of the change it describes, so the timeline never shows a
+// change that was rolled back.
+func recordEvent(tx *gorm.DB, event models.OrderEvent, data map[string]interface{}) error {
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		event.Data = datatypes.JSON(encoded)
+	}
+	return tx.Create(&event).Error
+}
+
+// actorID parses the optional id of the user making a change. Changes made
+// without one are recorded without an actor.
+func actorID(user_id string) *uuid.UUID {
+	parsedUserID, err := uuid.Parse(user_id)
+	if err != nil {
+		return nil
+	}
+	return &parsedUserID
+}
+
+func GetOrderTimeline(c *fiber.Ctx) error {
+	order_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	if err := db.GetDB().Where("id = ?", order_id).First(&models.Orders{}).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	dbQuery := db.GetDB().Preload("Actor").Where("order_id = ?", order_id)
+	if eventType := c.Query("type", ""); eventType != "" {
+		dbQuery = dbQuery.Where("type = ?", eventType)
+	}
+
+	var events []models.OrderEvent
+	if err := dbQuery.Order("created_at ASC").Find(&events).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, events)
+}
+
+func AddOrderNote(c *fiber.Ctx) error {
+	var req schemas.AddOrderNoteRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	order_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	user_id, err := uuid.Parse(req.UserID)
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	if err := db.GetDB().Where("id = ?", order_id).First(&models.Orders{}).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	event := models.OrderEvent{
+		OrderID: order_id,
+		ActorID: &user_id,
+		Type:    models.OrderEventNote,
+		Note:    req.Note,
+	}
+	if err := db.GetDB().Create(&event).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.ObjectCreated(c, event)
+}
@@ -0,0 +1,166 @@
+package orders
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/billing"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// itemDetails is how an order item was described and taxed when it was
+// added, as kept in its metadata.
+type itemDetails struct {
+	Name string  `json:"name"`
+	SKU  string  `json:"sku"`
+	HSN  string  `json:"hsn"`
+	GST  float64 `json:"gst"`
+}
+
+// soldAs reads the details an order item was sold with. Items added before
+// HSN codes were kept take theirs from the item.
+func soldAs(tx *gorm.DB, orderItem *models.OrderItem) (*itemDetails, error) {
+	var details itemDetails
+	_ = json.Unmarshal(orderItem.MetaData, &details)
+	if details.HSN != "" {
+		return &details, nil
+	}
+
+	var item models.Item
+	if err := tx.Select("name", "sku", "hsn", "gst").Where("id = ?", orderItem.ItemID).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &details, nil
+		}
+		return nil, err
+	}
+	details.HSN = item.HSN
+	if details.Name == "" {
+		details.Name = item.Name
+		details.SKU = item.SKU
+		details.GST = item.GST
+	}
+	return &details, nil
+}
+
+// orderBuyer loads the user an order is billed to. Orders of deleted users
+// are billed to no one.
+func orderBuyer(tx *gorm.DB, order *models.Orders) (*models.User, error) {
+	var buyer models.User
+	if err := tx.Where("id = ?", order.UserID).First(&buyer).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return &buyer, nil
+}
+
+func buyerAddress(user *models.User) string {
+	var parts []string
+	for _, part := range []string{user.AddressLine1, user.AddressLine2, user.AddressLine3, user.State, user.Pin} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// issueInvoice issues the tax invoice for an order that has just been paid,
+// one line per live order item. An order keeps the invoice it was first
+// issued, so paying it again after a restore does not issue another.
+func issueInvoice(tx *gorm.DB, order *models.Orders) error {
+	var count int64
+	if err := tx.Model(&models.Invoice{}).Where("order_id = ?", order.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	buyer, err := orderBuyer(tx, order)
+	if err != nil {
+		return err
+	}
+	invoice := &models.Invoice{
+		OrderID:      order.ID,
+		Currency:     order.Currency,
+		BuyerName:    buyer.Username,
+		BuyerAddress: buyerAddress(buyer),
+		BuyerState:   buyer.State,
+	}
+
+	orderItems, err := liveOrderItems(tx, order.ID)
+	if err != nil {
+		return err
+	}
+	for i := range orderItems {
+		details, err := soldAs(tx, &orderItems[i])
+		if err != nil {
+			return err
+		}
+		invoice.Lines = append(invoice.Lines, models.InvoiceLine{
+			OrderItemID: &orderItems[i].ID,
+			Description: details.Name,
+			SKU:         details.SKU,
+			HSN:         details.HSN,
+			Quantity:    orderItems[i].Quantity,
+			GSTRate:     details.GST,
+			Total:       orderItems[i].BillableAmount,
+		})
+	}
+	return billing.IssueInvoice(tx, invoice)
+}
+
+// BackfillInvoices issues invoices for orders that were paid before invoices
+// were issued.
+func BackfillInvoices(tx *gorm.DB) error {
+	var orders []models.Orders
+	if err := tx.Where("status IN ?", []OrderStatus{StatusPaid, StatusShipped, StatusDelivered}).
+		Where("NOT EXISTS (SELECT 1 FROM invoices WHERE invoices.order_id = orders.id)").
+		Order("status_date ASC").Find(&orders).Error; err != nil {
+		return err
+	}
+	for i := range orders {
+		if err := tx.Transaction(func(tx *gorm.DB) error {
+			return issueInvoice(tx, &orders[i])
+		}); err != nil {
+			return err
+		}
+	}
+	if len(orders) > 0 {
+		log.Println("Issued invoices for", len(orders), "paid orders")
+	}
+	return nil
+}
+
+func GetOrderInvoice(c *fiber.Ctx) error {
+	order_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var invoice models.Invoice
+	if err := db.GetDB().Preload("Lines").Where("order_id = ?", order_id).First(&invoice).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	if c.Query("format", "") != "pdf" {
+		return views.StatusOK(c, invoice)
+	}
+
+	pdf, err := billing.RenderInvoice(&invoice)
+	if err != nil {
+		return views.InternalServerError(c, err)
+	}
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`inline; filename="%s.pdf"`, strings.ReplaceAll(invoice.Number, "/", "-")))
+	return c.Send(pdf)
+}
@@ -0,0 +1,244 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidPaymentAmount = errors.New("invalid payment amount")
+
+// PaymentSummary is the state of an order's account.
+type PaymentSummary struct {
+	BillableAmount money.Amount     `json:"billable_amount"`
+	AmountPaid     money.Amount     `json:"amount_paid"`
+	Balance        money.Amount     `json:"balance"`  // still to be paid
+	Overpaid       money.Amount     `json:"overpaid"` // paid beyond the billable amount, owed back to the customer
+	Refunded       money.Amount     `json:"refunded"`
+	Payments       []models.Payment `json:"payments"`
+	Refunds        []models.Refund  `json:"refunds"`
+}
+
+func paymentSummary(tx *gorm.DB, order_id uuid.UUID) (*PaymentSummary, error) {
+	var order models.Orders
+	if err := tx.Where("id = ?", order_id).First(&order).Error; err != nil {
+		return nil, err
+	}
+
+	summary := PaymentSummary{BillableAmount: order.BillableAmount, AmountPaid: order.BillableAmountPaid}
+	if err := tx.Where("order_id = ?", order_id).Order("received_at ASC").Find(&summary.Payments).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("order_id = ?", order_id).Order("created_at ASC").Find(&summary.Refunds).Error; err != nil {
+		return nil, err
+	}
+	for _, refund := range summary.Refunds {
+		if refund.Status != models.RefundStatusFailed {
+			summary.Refunded += refund.Amount
+		}
+	}
+	summary.Balance = money.Max(order.BillableAmount-order.BillableAmountPaid, 0)
+	summary.Overpaid = money.Max(order.BillableAmountPaid-order.BillableAmount, 0)
+	return &summary, nil
+}
+
+// allocatePayments sets the amount paid of an order to the sum of its
+// payments less what has been refunded, and spreads it over its live items,
+// oldest first, each up to its billable amount. Anything left over is an
+// overpayment and stays on the order only. Cancelled and expired items hold
+// no payment.
+func allocatePayments(tx *gorm.DB, order_id uuid.UUID) (money.Amount, error) {
+	var received, refunded money.Amount
+	if err := tx.Model(&models.Payment{}).Select("COALESCE(SUM(amount), 0)").
+		Where("order_id = ?", order_id).Scan(&received).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Model(&models.Refund{}).Select("COALESCE(SUM(amount), 0)").
+		Where("order_id = ? AND status <> ?", order_id, models.RefundStatusFailed).Scan(&refunded).Error; err != nil {
+		return 0, err
+	}
+	paid := received - refunded
+
+	if err := tx.Model(&models.OrderItem{}).Where("order_id = ?", order_id).
+		Update("billable_amount_paid", 0).Error; err != nil {
+		return 0, err
+	}
+
+	orderItems, err := liveOrderItems(tx, order_id)
+	if err != nil {
+		return 0, err
+	}
+	remaining := paid
+	for _, orderItem := range orderItems {
+		if remaining <= 0 {
+			break
+		}
+		allocated := money.Min(remaining, orderItem.BillableAmount)
+		if err := tx.Model(&models.OrderItem{}).Where("id = ?", orderItem.ID).
+			Update("billable_amount_paid", allocated).Error; err != nil {
+			return 0, err
+		}
+		remaining -= allocated
+	}
+
+	return paid, tx.Model(&models.Orders{}).Where("id = ?", order_id).Update("billable_amount_paid", paid).Error
+}
+
+// recordPayment adds a payment to an order locked by the caller and moves it
+// to paid once the balance is cleared, or partially paid before that. A
+// payment beyond the balance is refused unless allowOverpayment is set.
+// Payments taken at a gateway have already been received, so they are
+// recorded whatever the state of the order.
+func recordPayment(tx *gorm.DB, order *models.Orders, payment *models.Payment, allowOverpayment bool) error {
+	status := OrderStatus(order.Status)
+	if payment.Amount <= 0 {
+		return ErrInvalidPaymentAmount
+	}
+	if payment.Provider == "" {
+		if status != StatusBooked && status != StatusPartiallyPaid && !(status == StatusPaid && allowOverpayment) {
+			return &TransitionError{Message: fmt.Sprintf("cannot record a payment on an order that is %s", status)}
+		}
+
+		balance := order.BillableAmount - order.BillableAmountPaid
+		if payment.Amount > balance && !allowOverpayment {
+			return &TransitionError{Message: fmt.Sprintf("payment exceeds the balance of %s", money.Max(balance, 0))}
+		}
+	}
+
+	payment.OrderID = order.ID
+	if payment.ReceivedAt == 0 {
+		payment.ReceivedAt = int(time.Now().Unix())
+	}
+	if err := tx.Create(payment).Error; err != nil {
+		return err
+	}
+
+	paid, err := allocatePayments(tx, order.ID)
+	if err != nil {
+		return err
+	}
+	order.BillableAmountPaid = paid
+
+	if err := recordEvent(tx, models.OrderEvent{
+		OrderID: order.ID,
+		ActorID: &payment.RecordedBy,
+		Type:    models.OrderEventPaymentReceived,
+		Note:    payment.Note,
+	}, map[string]interface{}{
+		"payment_id": payment.ID,
+		"amount":     payment.Amount,
+		"method":     payment.Method,
+		"reference":  payment.Reference,
+		"provider":   payment.Provider,
+		"paid":       paid,
+	}); err != nil {
+		return err
+	}
+
+	if status != StatusBooked && status != StatusPartiallyPaid {
+		return nil
+	}
+	action := ActionPartPay
+	if paid >= order.BillableAmount {
+		action = ActionPay
+	} else if status == StatusPartiallyPaid {
+		// still short, the status stays as it is
+		return nil
+	}
+	return Fire(tx, order, action, payment.RecordedBy, nil)
+}
+
+// BackfillPayments gives every order that was marked as paid before payments
+// were recorded a single payment for the amount it had, so its amount paid
+// survives being recomputed from payments.
+func BackfillPayments(tx *gorm.DB) error {
+	return tx.Exec(`INSERT INTO payments (order_id, amount, method, received_at, recorded_by, note, created_at, updated_at)
+		SELECT orders.id, orders.billable_amount_paid, ?, orders.status_date, orders.user_id, 'backfilled from amount paid',
+			EXTRACT(EPOCH FROM NOW())::bigint, EXTRACT(EPOCH FROM NOW())::bigint
+		FROM orders
+		WHERE orders.billable_amount_paid > 0
+		AND NOT EXISTS (SELECT 1 FROM payments WHERE payments.order_id = orders.id)`,
+		models.PaymentMethodCash).Error
+}
+
+func GetOrderPayments(c *fiber.Ctx) error {
+	order_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	summary, err := paymentSummary(db.GetDB(), order_id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, summary)
+}
+
+func RecordPayment(c *fiber.Ctx) error {
+	var req schemas.RecordPaymentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	order_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	user_id, err := uuid.Parse(req.UserID)
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	if err := db.GetDB().Where("id = ?", user_id).First(&models.User{}).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	payment := models.Payment{
+		Amount:     req.Amount,
+		Method:     req.Method,
+		Reference:  req.Reference,
+		ReceivedAt: req.ReceivedAt,
+		RecordedBy: user_id,
+		Note:       req.Note,
+	}
+
+	var summary *PaymentSummary
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		order, err := lockOrder(tx, order_id)
+		if err != nil {
+			return err
+		}
+		if err := recordPayment(tx, order, &payment, req.AllowOverpayment); err != nil {
+			return err
+		}
+		summary, err = paymentSummary(tx, order_id)
+		return err
+	}); err != nil {
+		if errors.Is(err, ErrInvalidPaymentAmount) {
+			return views.BadRequestWithMessage(c, err.Error())
+		}
+		return transitionFailed(c, err)
+	}
+
+	return views.ObjectCreated(c, summary)
+}
@@ -0,0 +1,106 @@
+package orders
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/pricing"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// withPricing writes how a line was priced into an order item's metadata,
+// keeping the rest of it.
+func withPricing(metadata datatypes.JSON, line *pricing.Line) (datatypes.JSON, error) {
+	fields := map[string]interface{}{}
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &fields); err != nil {
+			return nil, err
+		}
+	}
+	for key, value := range line.Metadata() {
+		fields[key] = value
+	}
+	updated, err := json.Marshal(fields)
+	return datatypes.JSON(updated), err
+}
+
+// quoteOrder prices the live items of an order, with GST split for the
+// state of its buyer.
+func quoteOrder(tx *gorm.DB, order *models.Orders) (*pricing.Quote, error) {
+	buyer, err := orderBuyer(tx, order)
+	if err != nil {
+		return nil, err
+	}
+	orderItems, err := liveOrderItems(tx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []pricing.Line
+	for i := range orderItems {
+		line, err := pricing.ForOrderItem(tx, &orderItems[i], orderItems[i].Quantity, nil, buyer.State)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, *line)
+	}
+	return pricing.NewQuote(order.Currency, lines), nil
+}
+
+// GetOrderQuote previews the price breakdown of an order. With item_id, and
+// optionally quantity, it also prices that item as if it were added.
+func GetOrderQuote(c *fiber.Ctx) error {
+	order_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var order models.Orders
+	if err := db.GetDB().Where("id = ?", order_id).First(&order).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	quote, err := quoteOrder(db.GetDB(), &order)
+	if err != nil {
+		return views.InternalServerError(c, err)
+	}
+
+	if c.Query("item_id", "") != "" {
+		item_id, err := uuid.Parse(c.Query("item_id"))
+		if err != nil {
+			return views.BadRequest(c)
+		}
+		quantity := c.QueryInt("quantity", 1)
+		if quantity < 1 {
+			return views.BadRequest(c)
+		}
+
+		var item models.Item
+		if err := db.GetDB().Where("id = ?", item_id).First(&item).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return views.BadRequestWithMessage(c, "item not found")
+			}
+			return views.InternalServerError(c, err)
+		}
+		buyer, err := orderBuyer(db.GetDB(), &order)
+		if err != nil {
+			return views.InternalServerError(c, err)
+		}
+		line, err := pricing.ForItem(db.GetDB(), &item, quantity, buyer.State)
+		if err != nil {
+			return views.InternalServerError(c, err)
+		}
+		quote = pricing.NewQuote(order.Currency, append(quote.Lines, *line))
+	}
+
+	return views.StatusOK(c, quote)
+}
@@ -0,0 +1,604 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/api/gateway"
+	"github.com/Baalamurgan/coin-selling-backend/api/schemas"
+	"github.com/Baalamurgan/coin-selling-backend/api/utils"
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/billing"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidRefundAmount = errors.New("invalid refund amount")
+
+// adjustsTotal reports whether a refund for reason lowers what the order is
+// worth. Refunds for cancelled goods return money for something the order no
+// longer holds, and overpayments were never part of it.
+func adjustsTotal(reason string) bool {
+	return reason != models.RefundReasonCancelled && reason != models.RefundReasonOverpayment
+}
+
+func refundedAmount(tx *gorm.DB, payment_id uuid.UUID) (money.Amount, error) {
+	var refunded money.Amount
+	err := tx.Model(&models.Refund{}).Select("COALESCE(SUM(amount), 0)").
+		Where("payment_id = ? AND status <> ?", payment_id, models.RefundStatusFailed).Scan(&refunded).Error
+	return refunded, err
+}
+
+// issueRefund records refund.Amount of a payment as paid back on an order
+// locked by the caller. Refunds of gateway payments are recorded pending and
+// sent to the gateway by submitRefund once the transaction has committed;
+// the others are processed at once.
+func issueRefund(tx *gorm.DB, order *models.Orders, refund *models.Refund) error {
+	var payment models.Payment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND order_id = ?", refund.PaymentID, order.ID).First(&payment).Error; err != nil {
+		return err
+	}
+
+	if refund.Amount <= 0 {
+		return ErrInvalidRefundAmount
+	}
+	refunded, err := refundedAmount(tx, payment.ID)
+	if err != nil {
+		return err
+	}
+	if refundable := payment.Amount - refunded; refund.Amount > refundable {
+		return &TransitionError{Message: fmt.Sprintf("refund exceeds the %s left to refund on this payment", refundable)}
+	}
+
+	switch refund.Reason {
+	case models.RefundReasonOverpayment:
+		if overpaid := order.BillableAmountPaid - order.BillableAmount; refund.Amount > overpaid {
+			return &TransitionError{Message: fmt.Sprintf("refund exceeds the overpayment of %s", money.Max(overpaid, 0))}
+		}
+	case models.RefundReasonCancelled:
+		orderCancelled := OrderStatus(order.Status) == StatusCancelled
+		cancelled := orderCancelled
+		if refund.OrderItemID != nil {
+			var orderItem models.OrderItem
+			if err := tx.Where("id = ? AND order_id = ?", *refund.OrderItemID, order.ID).First(&orderItem).Error; err != nil {
+				return err
+			}
+			cancelled = cancelled || orderItem.OrderItemStatus == "cancelled"
+
+			// a cancelled item gives back at most what it was billed
+			var itemRefunded money.Amount
+			if err := tx.Model(&models.Refund{}).Select("COALESCE(SUM(amount), 0)").
+				Where("order_item_id = ? AND status <> ?", orderItem.ID, models.RefundStatusFailed).
+				Scan(&itemRefunded).Error; err != nil {
+				return err
+			}
+			if left := orderItem.BillableAmount - itemRefunded; refund.Amount > left {
+				return &TransitionError{Message: fmt.Sprintf("refund exceeds the %s left to refund on this item", money.Max(left, 0))}
+			}
+		}
+		if !cancelled {
+			return &TransitionError{Message: "nothing has been cancelled to refund"}
+		}
+		// on an order that is still live, cancelled items only free up what
+		// was paid beyond the live items' total
+		if !orderCancelled {
+			if overpaid := order.BillableAmountPaid - order.BillableAmount; refund.Amount > overpaid {
+				return &TransitionError{Message: fmt.Sprintf("refund exceeds the %s paid for cancelled items", money.Max(overpaid, 0))}
+			}
+		}
+	default:
+		if refund.Amount > order.BillableAmount {
+			return &TransitionError{Message: fmt.Sprintf("refund exceeds the order total of %s", order.BillableAmount)}
+		}
+	}
+
+	refund.OrderID = order.ID
+	refund.AdjustsTotal = adjustsTotal(refund.Reason)
+	refund.Status = models.RefundStatusProcessed
+	if refund.Method == "" {
+		refund.Method = payment.Method
+	}
+
+	if payment.Provider != "" {
+		provider := gateway.GetProvider()
+		if provider.Name() != payment.Provider {
+			return &TransitionError{Message: fmt.Sprintf("payment was taken through %s, which is not the configured provider", payment.Provider)}
+		}
+		refund.Provider = payment.Provider
+		refund.Status = models.RefundStatusPending
+	}
+
+	if err := tx.Create(refund).Error; err != nil {
+		return err
+	}
+	if err := recalculateOrderTotal(tx, order.ID); err != nil {
+		return err
+	}
+
+	if err := recordEvent(tx, models.OrderEvent{
+		OrderID:     order.ID,
+		OrderItemID: refund.OrderItemID,
+		ActorID:     refund.RecordedBy,
+		Type:        models.OrderEventRefundIssued,
+		Note:        refund.Note,
+	}, map[string]interface{}{
+		"refund_id":  refund.ID,
+		"payment_id": payment.ID,
+		"amount":     refund.Amount,
+		"reason":     refund.Reason,
+		"status":     refund.Status,
+	}); err != nil {
+		return err
+	}
+
+	if refund.Status == models.RefundStatusProcessed {
+		return completeRefund(tx, order, refund)
+	}
+	return nil
+}
+
+// submitRefund sends a pending gateway refund, already committed, to the
+// gateway. The gateway is never called inside a transaction, so money it pays
+// out cannot be lost to a rollback; its answer settles the refund the same
+// way its webhooks do. A refund the gateway turns down is marked failed.
+func submitRefund(ctx context.Context, refund *models.Refund) error {
+	if refund.Provider == "" || refund.Status != models.RefundStatusPending {
+		return nil
+	}
+	var payment models.Payment
+	if err := db.GetDB().Where("id = ?", refund.PaymentID).First(&payment).Error; err != nil {
+		return err
+	}
+
+	result, err := gateway.GetProvider().Refund(ctx, payment.ProviderPaymentID, refund.Amount, refund.ID.String())
+	if err != nil {
+		if failErr := db.GetDB().Transaction(func(tx *gorm.DB) error {
+			return failSubmittedRefund(tx, refund, err.Error())
+		}); failErr != nil {
+			return failErr
+		}
+		return err
+	}
+
+	event := &gateway.Event{
+		Type:              gateway.EventPaymentRefunded,
+		ProviderPaymentID: payment.ProviderPaymentID,
+		ProviderRefundID:  result.ProviderRefundID,
+		Receipt:           refund.ID.String(),
+		Amount:            refund.Amount,
+	}
+	return db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if result.Status == gateway.RefundStatusProcessed {
+			return gatewayRefundProcessed(tx, event)
+		}
+		if _, err := lockOrder(tx, payment.OrderID); err != nil {
+			return err
+		}
+		_, err := gatewayRefund(tx, &payment, event)
+		return err
+	})
+}
+
+// submitRefunds submits each refund in turn, carrying on past failures so one
+// refund the gateway turns down does not hold back the others. It returns the
+// first error.
+func submitRefunds(ctx context.Context, refunds []models.Refund) error {
+	var firstErr error
+	for i := range refunds {
+		if err := submitRefund(ctx, &refunds[i]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// failSubmittedRefund marks a refund the gateway turned down as failed, unless
+// a webhook has settled it in the meantime.
+func failSubmittedRefund(tx *gorm.DB, submitted *models.Refund, reason string) error {
+	if _, err := lockOrder(tx, submitted.OrderID); err != nil {
+		return err
+	}
+	var refund models.Refund
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", submitted.ID).First(&refund).Error; err != nil {
+		return err
+	}
+	if refund.Status != models.RefundStatusPending || refund.ProviderRefundID != "" {
+		return nil
+	}
+	return failRefund(tx, &refund, reason)
+}
+
+// failRefund marks a pending refund failed, so the money counts as paid again.
+func failRefund(tx *gorm.DB, refund *models.Refund, reason string) error {
+	refund.Status = models.RefundStatusFailed
+	if err := tx.Model(refund).Update("status", refund.Status).Error; err != nil {
+		return err
+	}
+	if err := recalculateOrderTotal(tx, refund.OrderID); err != nil {
+		return err
+	}
+	return recordEvent(tx, models.OrderEvent{
+		OrderID: refund.OrderID,
+		Type:    models.OrderEventRefundFailed,
+		Note:    reason,
+	}, map[string]interface{}{
+		"refund_id": refund.ID,
+		"amount":    refund.Amount,
+	})
+}
+
+// gatewayRefund finds and locks the refund a gateway event is about: by the
+// gateway's refund id or, for a refund the shop started whose id it has not
+// recorded yet, by the receipt the refund was sent with. The order must be
+// locked by the caller.
+func gatewayRefund(tx *gorm.DB, payment *models.Payment, event *gateway.Event) (*models.Refund, error) {
+	var refund models.Refund
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("provider = ? AND provider_refund_id = ?", payment.Provider, event.ProviderRefundID).First(&refund).Error
+	if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) || event.Receipt == "" {
+		return &refund, err
+	}
+
+	refund_id, parseErr := uuid.Parse(event.Receipt)
+	if parseErr != nil {
+		return nil, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND payment_id = ? AND provider_refund_id = ''", refund_id, payment.ID).First(&refund).Error; err != nil {
+		return nil, err
+	}
+	refund.ProviderRefundID = event.ProviderRefundID
+	if err := tx.Model(&refund).Update("provider_refund_id", refund.ProviderRefundID).Error; err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+// completeRefund marks a refund processed and issues its credit note. Money
+// returned for an overpayment was never part of a supply, so it gets none.
+func completeRefund(tx *gorm.DB, order *models.Orders, refund *models.Refund) error {
+	refund.Status = models.RefundStatusProcessed
+	refund.ProcessedAt = int(time.Now().Unix())
+	if err := tx.Model(refund).Updates(map[string]interface{}{
+		"status":       refund.Status,
+		"processed_at": refund.ProcessedAt,
+	}).Error; err != nil {
+		return err
+	}
+
+	if refund.Reason == models.RefundReasonOverpayment {
+		return nil
+	}
+	note, err := creditNoteFor(tx, order, refund)
+	if err != nil {
+		return err
+	}
+	return billing.IssueCreditNote(tx, note)
+}
+
+// creditNoteFor spreads a refund over the order items it is for, in
+// proportion to their billable amounts, so each line carries the GST rate it
+// was sold at.
+func creditNoteFor(tx *gorm.DB, order *models.Orders, refund *models.Refund) (*models.CreditNote, error) {
+	note := &models.CreditNote{
+		OrderID:  order.ID,
+		RefundID: refund.ID,
+		Reason:   refund.Reason,
+		Currency: order.Currency,
+	}
+
+	buyer, err := orderBuyer(tx, order)
+	if err != nil {
+		return nil, err
+	}
+	note.BuyerName = buyer.Username
+	note.BuyerAddress = buyerAddress(buyer)
+	note.BuyerState = buyer.State
+
+	dbQuery := tx.Where("order_id = ?", order.ID)
+	if refund.OrderItemID != nil {
+		dbQuery = dbQuery.Where("id = ?", *refund.OrderItemID)
+	} else if refund.Reason == models.RefundReasonCancelled && OrderStatus(order.Status) != StatusCancelled {
+		dbQuery = dbQuery.Where("order_item_status = ?", "cancelled")
+	} else if refund.Reason == models.RefundReasonCancelled {
+		dbQuery = dbQuery.Where("order_item_status <> ?", "expired")
+	} else {
+		dbQuery = dbQuery.Where("order_item_status NOT IN ?", []string{"cancelled", "expired"})
+	}
+	var orderItems []models.OrderItem
+	if err := dbQuery.Order("created_at ASC").Find(&orderItems).Error; err != nil {
+		return nil, err
+	}
+
+	var weight money.Amount
+	for _, orderItem := range orderItems {
+		weight += orderItem.BillableAmount
+	}
+	if weight <= 0 {
+		note.Lines = []models.CreditNoteLine{{Description: "Refund against order " + order.ID.String(), Total: refund.Amount}}
+		return note, nil
+	}
+
+	remaining := refund.Amount
+	for i, orderItem := range orderItems {
+		details, err := soldAs(tx, &orderItem)
+		if err != nil {
+			return nil, err
+		}
+		line := models.CreditNoteLine{
+			OrderItemID: &orderItems[i].ID,
+			Description: details.Name,
+			SKU:         details.SKU,
+			HSN:         details.HSN,
+			GSTRate:     details.GST,
+		}
+		if i == len(orderItems)-1 {
+			// the last line takes what rounding left over
+			line.Total = remaining
+		} else {
+			line.Total = refund.Amount.Ratio(int64(orderItem.BillableAmount), int64(weight))
+		}
+		remaining -= line.Total
+		note.Lines = append(note.Lines, line)
+	}
+	return note, nil
+}
+
+// refundCancelledOrder refunds everything still paid on a cancelled order,
+// payment by payment, newest first. It returns the refunds for the caller to
+// submit once the transaction has committed.
+func refundCancelledOrder(tx *gorm.DB, order *models.Orders, actor_id uuid.UUID, note string) ([]models.Refund, error) {
+	var payments []models.Payment
+	if err := tx.Where("order_id = ?", order.ID).Order("received_at DESC").Find(&payments).Error; err != nil {
+		return nil, err
+	}
+	var refunds []models.Refund
+	for _, payment := range payments {
+		refunded, err := refundedAmount(tx, payment.ID)
+		if err != nil {
+			return nil, err
+		}
+		refundable := payment.Amount - refunded
+		if refundable <= 0 {
+			continue
+		}
+		refund := models.Refund{
+			PaymentID:  payment.ID,
+			Amount:     refundable,
+			Reason:     models.RefundReasonCancelled,
+			Note:       note,
+			RecordedBy: &actor_id,
+		}
+		if err := issueRefund(tx, order, &refund); err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, refund)
+	}
+	return refunds, nil
+}
+
+// gatewayRefundProcessed completes a refund the gateway reports processed.
+// Refunds started from the gateway's own dashboard are recorded here for the
+// first time.
+func gatewayRefundProcessed(tx *gorm.DB, event *gateway.Event) error {
+	var payment models.Payment
+	if err := tx.Where("provider = ? AND provider_payment_id = ?", gateway.GetProvider().Name(), event.ProviderPaymentID).
+		First(&payment).Error; err != nil {
+		return err
+	}
+	order, err := lockOrder(tx, payment.OrderID)
+	if err != nil {
+		return err
+	}
+
+	existing, err := gatewayRefund(tx, &payment, event)
+	if err == nil {
+		switch existing.Status {
+		case models.RefundStatusProcessed:
+			return nil
+		case models.RefundStatusFailed:
+			// the gateway paid out a refund whose submission seemed to fail
+			if err := completeRefund(tx, order, existing); err != nil {
+				return err
+			}
+			return recalculateOrderTotal(tx, order.ID)
+		}
+		return completeRefund(tx, order, existing)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	reason := models.RefundReasonOther
+	if OrderStatus(order.Status) == StatusCancelled {
+		reason = models.RefundReasonCancelled
+	}
+	refund := models.Refund{
+		OrderID:          order.ID,
+		PaymentID:        payment.ID,
+		Amount:           event.Amount,
+		Reason:           reason,
+		Note:             "refunded at the payment gateway",
+		AdjustsTotal:     adjustsTotal(reason),
+		Method:           payment.Method,
+		Provider:         payment.Provider,
+		ProviderRefundID: event.ProviderRefundID,
+		Status:           models.RefundStatusPending,
+	}
+	if err := tx.Create(&refund).Error; err != nil {
+		return err
+	}
+	if err := recalculateOrderTotal(tx, order.ID); err != nil {
+		return err
+	}
+	if err := recordEvent(tx, models.OrderEvent{
+		OrderID: order.ID,
+		Type:    models.OrderEventRefundIssued,
+		Note:    refund.Note,
+	}, map[string]interface{}{
+		"refund_id":  refund.ID,
+		"payment_id": payment.ID,
+		"amount":     refund.Amount,
+		"reason":     refund.Reason,
+		"status":     models.RefundStatusProcessed,
+	}); err != nil {
+		return err
+	}
+	return completeRefund(tx, order, &refund)
+}
+
+// gatewayRefundFailed marks a pending refund the gateway could not pay out as
+// failed, so the money counts as paid again.
+func gatewayRefundFailed(tx *gorm.DB, event *gateway.Event) error {
+	var payment models.Payment
+	if err := tx.Where("provider = ? AND provider_payment_id = ?", gateway.GetProvider().Name(), event.ProviderPaymentID).
+		First(&payment).Error; err != nil {
+		return err
+	}
+	if _, err := lockOrder(tx, payment.OrderID); err != nil {
+		return err
+	}
+	refund, err := gatewayRefund(tx, &payment, event)
+	if err != nil {
+		return err
+	}
+	if refund.Status != models.RefundStatusPending {
+		return nil
+	}
+	return failRefund(tx, refund, event.FailureReason)
+}
+
+func GetOrderRefunds(c *fiber.Ctx) error {
+	order_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	if err := db.GetDB().Where("id = ?", order_id).First(&models.Orders{}).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	var refunds []models.Refund
+	if err := db.GetDB().Where("order_id = ?", order_id).Order("created_at ASC").Find(&refunds).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	var creditNotes []models.CreditNote
+	if err := db.GetDB().Preload("Lines").Where("order_id = ?", order_id).Order("issued_at ASC").Find(&creditNotes).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.StatusOK(c, fiber.Map{
+		"refunds":      refunds,
+		"credit_notes": creditNotes,
+	})
+}
+
+func CreateRefund(c *fiber.Ctx) error {
+	var req schemas.CreateRefundRequest
+	if err := c.BodyParser(&req); err != nil {
+		return views.InvalidParams(c)
+	}
+	if err := utils.ValidateStruct(req); len(err) > 0 {
+		return views.InvalidParams(c)
+	}
+
+	order_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+	payment_id, err := uuid.Parse(req.PaymentID)
+	if err != nil {
+		return views.BadRequest(c)
+	}
+	user_id, err := uuid.Parse(req.UserID)
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	refund := models.Refund{
+		PaymentID:  payment_id,
+		Amount:     req.Amount,
+		Reason:     req.Reason,
+		Note:       req.Note,
+		Method:     req.Method,
+		RecordedBy: &user_id,
+	}
+	if req.OrderItemID != "" {
+		order_item_id, err := uuid.Parse(req.OrderItemID)
+		if err != nil {
+			return views.BadRequest(c)
+		}
+		refund.OrderItemID = &order_item_id
+	}
+
+	if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		order, err := lockOrder(tx, order_id)
+		if err != nil {
+			return err
+		}
+		return issueRefund(tx, order, &refund)
+	}); err != nil {
+		if errors.Is(err, ErrInvalidRefundAmount) {
+			return views.BadRequestWithMessage(c, err.Error())
+		}
+		return transitionFailed(c, err)
+	}
+
+	if err := submitRefund(c.Context(), &refund); err != nil {
+		return views.InternalServerError(c, err)
+	}
+	if err := db.GetDB().Where("id = ?", refund.ID).First(&refund).Error; err != nil {
+		return views.InternalServerError(c, err)
+	}
+	return views.ObjectCreated(c, refund)
+}
+
+func GetCreditNote(c *fiber.Ctx) error {
+	order_id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+	credit_note_id, err := uuid.Parse(c.Params("credit_note_id"))
+	if err != nil {
+		return views.BadRequest(c)
+	}
+
+	var note models.CreditNote
+	if err := db.GetDB().Preload("Lines").
+		Where("id = ? AND order_id = ?", credit_note_id, order_id).First(&note).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return views.RecordNotFound(c)
+		}
+		return views.InternalServerError(c, err)
+	}
+
+	if c.Query("format", "") != "pdf" {
+		return views.StatusOK(c, note)
+	}
+
+	reference := "order " + note.OrderID.String()
+	var invoice models.Invoice
+	if err := db.GetDB().Select("number").Where("order_id = ?", note.OrderID).First(&invoice).Error; err == nil {
+		reference = "invoice " + invoice.Number
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return views.InternalServerError(c, err)
+	}
+
+	pdf, err := billing.RenderCreditNote(&note, reference)
+	if err != nil {
+		return views.InternalServerError(c, err)
+	}
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`inline; filename="%s.pdf"`, strings.ReplaceAll(note.Number, "/", "-")))
+	return c.Send(pdf)
+}
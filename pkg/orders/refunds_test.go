@@ -0,0 +1,77 @@
+package orders_test
+
+import (
+	"testing"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestCancelledRefundOnLiveOrder pays ₹1000 for an order of a ₹900 line that
+// is still live and a ₹100 line that was cancelled. Only the ₹100 freed up by
+// the cancelled line can be refunded as cancelled.
+func TestCancelledRefundOnLiveOrder(t *testing.T) {
+	requireDB(t)
+	app := newApp()
+	item := newItem(t, 0)
+	user := newUser(t)
+
+	order := models.Orders{
+		UserID:             user.ID,
+		Currency:           money.INR,
+		Status:             "paid",
+		BillableAmount:     money.FromFloat(900),
+		BillableAmountPaid: money.FromFloat(1000),
+	}
+	if err := db.GetDB().Create(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	live := models.OrderItem{OrderID: order.ID, ItemID: item.ID, BillableAmount: money.FromFloat(900), BillableAmountPaid: money.FromFloat(900), OrderItemStatus: "booked"}
+	cancelled := models.OrderItem{OrderID: order.ID, ItemID: item.ID, BillableAmount: money.FromFloat(100), OrderItemStatus: "cancelled"}
+	for _, orderItem := range []*models.OrderItem{&live, &cancelled} {
+		if err := db.GetDB().Create(orderItem).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	payment := models.Payment{OrderID: order.ID, Amount: money.FromFloat(1000), Method: models.PaymentMethodCash, RecordedBy: user.ID}
+	if err := db.GetDB().Create(&payment).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	refund := func(amount float64, order_item_id string) int {
+		return send(t, app, "POST", "/order/"+order.ID.String()+"/refunds", map[string]interface{}{
+			"user_id":       user.ID,
+			"payment_id":    payment.ID,
+			"order_item_id": order_item_id,
+			"amount":        amount,
+			"reason":        models.RefundReasonCancelled,
+		})
+	}
+
+	cases := []struct {
+		name          string
+		amount        float64
+		order_item_id string
+		want          int
+	}{
+		{"the whole payment", 1000, "", fiber.StatusBadRequest},
+		{"more than the cancelled line", 150, cancelled.ID.String(), fiber.StatusBadRequest},
+		{"against the live line", 100, live.ID.String(), fiber.StatusBadRequest},
+		{"the cancelled line", 100, cancelled.ID.String(), fiber.StatusCreated},
+		{"the cancelled line again", 1, cancelled.ID.String(), fiber.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		if status := refund(tc.amount, tc.order_item_id); status != tc.want {
+			t.Errorf("refunding %s returned %d, want %d", tc.name, status, tc.want)
+		}
+	}
+
+	if err := db.GetDB().First(&order, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "paid" || order.BillableAmountPaid != order.BillableAmount {
+		t.Fatalf("order is %s with %s paid of %s, want paid in full", order.Status, order.BillableAmountPaid, order.BillableAmount)
+	}
+}
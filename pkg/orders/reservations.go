@@ -0,0 +1,98 @@
+package orders
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/db"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/inventory"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"gorm.io/gorm"
+)
+
+var errReservationClosed = errors.New("reservation is no longer active")
+
+// ReleaseExpiredReservations returns the stock of every expired reservation.
+// The order item is marked expired and its amount taken off the order total,
+// so the customer has to add it again.
+func ReleaseExpiredReservations() (int, error) {
+	reservations, err := inventory.ExpiredReservations(db.GetDB())
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, reservation := range reservations {
+		if err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+			order, err := lockOrder(tx, reservation.OrderID)
+			if err != nil {
+				return err
+			}
+			if OrderStatus(order.Status) != StatusPending {
+				// ConfirmOrder converts reservations in the same transaction that
+				// books the order, so this one is already being converted
+				return nil
+			}
+
+			orderItem, err := lockOrderItem(tx, reservation.OrderItemID)
+			if err != nil {
+				return err
+			}
+			if _, err := inventory.LockItem(tx, orderItem.ItemID); err != nil {
+				return err
+			}
+
+			// units first, so stock of serialised items is re-derived after they are back
+			if err := inventory.ReleaseUnits(tx, orderItem.ItemID, orderItem.ID, 0); err != nil {
+				return err
+			}
+			ok, err := inventory.ReleaseReservation(tx, orderItem.ID, models.ReservationStatusExpired)
+			if err != nil {
+				return err
+			} else if !ok {
+				return errReservationClosed
+			}
+
+			if err := tx.Model(orderItem).Update("order_item_status", "expired").Error; err != nil {
+				return err
+			}
+			if err := recalculateOrderTotal(tx, order.ID); err != nil {
+				return err
+			}
+			if err := recordEvent(tx, models.OrderEvent{
+				OrderID:     order.ID,
+				OrderItemID: &orderItem.ID,
+				Type:        models.OrderEventItemExpired,
+			}, map[string]interface{}{
+				"item_id":         orderItem.ItemID,
+				"quantity":        orderItem.Quantity,
+				"billable_amount": orderItem.BillableAmount,
+			}); err != nil {
+				return err
+			}
+			released++
+			return nil
+		}); err != nil && !errors.Is(err, errReservationClosed) {
+			log.Println("Failed to release reservation:", reservation.ID, err)
+		}
+	}
+	return released, nil
+}
+
+// StartReservationSweeper runs ReleaseExpiredReservations every interval for
+// the lifetime of the process.
+func StartReservationSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			released, err := ReleaseExpiredReservations()
+			if err != nil {
+				log.Println("Reservation sweep failed:", err)
+			} else if released > 0 {
+				log.Println("Released expired reservations:", released)
+			}
+		}
+	}()
+}
@@ -0,0 +1,298 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Baalamurgan/coin-selling-backend/pkg/inventory"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type OrderStatus string
+
+const (
+	StatusPending       OrderStatus = "pending"
+	StatusBooked        OrderStatus = "booked"
+	StatusPartiallyPaid OrderStatus = "partially_paid"
+	StatusPaid          OrderStatus = "paid"
+	StatusShipped       OrderStatus = "shipped"
+	StatusDelivered     OrderStatus = "delivered"
+	StatusCancelled     OrderStatus = "cancelled"
+)
+
+type OrderAction string
+
+const (
+	ActionConfirm OrderAction = "confirm"
+	ActionPartPay OrderAction = "part_pay"
+	ActionPay     OrderAction = "pay"
+	ActionShip    OrderAction = "ship"
+	ActionDeliver OrderAction = "deliver"
+	ActionCancel  OrderAction = "cancel"
+	ActionRestore OrderAction = "restore"
+)
+
+// TransitionError is a transition refused because of the order's state. Its
+// message is safe to show to the caller.
+type TransitionError struct {
+	Message string
+}
+
+func (e *TransitionError) Error() string {
+	return e.Message
+}
+
+// transitionContext is what guards and side effects of a transition work on.
+// Updates collects the order columns written together with the new status.
+type transitionContext struct {
+	Tx      *gorm.DB
+	Order   *models.Orders
+	ActorID uuid.UUID
+	Updates map[string]interface{}
+}
+
+// A transition moves an order from one of From to To when Action fires. Guard
+// decides whether it may fire and must not write; Effect runs inside the
+// transaction before the status changes.
+type transition struct {
+	Action OrderAction
+	From   []OrderStatus
+	To     OrderStatus // empty for restore, which returns to the status held before cancelling
+	Guard  func(ctx *transitionContext) error
+	Effect func(ctx *transitionContext) error
+}
+
+var transitions = []transition{
+	{
+		Action: ActionConfirm,
+		From:   []OrderStatus{StatusPending},
+		To:     StatusBooked,
+		Guard:  hasLiveItems,
+		Effect: bookOrderItems,
+	},
+	{
+		Action: ActionPartPay,
+		From:   []OrderStatus{StatusBooked},
+		To:     StatusPartiallyPaid,
+	},
+	{
+		Action: ActionPay,
+		From:   []OrderStatus{StatusBooked, StatusPartiallyPaid},
+		To:     StatusPaid,
+		Guard:  fullyPaid,
+		Effect: settleOrder,
+	},
+	{
+		Action: ActionShip,
+		From:   []OrderStatus{StatusPaid},
+		To:     StatusShipped,
+	},
+	{
+		Action: ActionDeliver,
+		From:   []OrderStatus{StatusShipped},
+		To:     StatusDelivered,
+	},
+	{
+		Action: ActionCancel,
+		From:   []OrderStatus{StatusPending, StatusBooked, StatusPartiallyPaid, StatusPaid, StatusShipped},
+		To:     StatusCancelled,
+		Effect: releaseOrderStock,
+	},
+	{
+		Action: ActionRestore,
+		From:   []OrderStatus{StatusCancelled},
+		Guard:  stockAvailable,
+		Effect: retakeOrderStock,
+	},
+}
+
+func findTransition(action OrderAction) *transition {
+	for i := range transitions {
+		if transitions[i].Action == action {
+			return &transitions[i]
+		}
+	}
+	return nil
+}
+
+func (t *transition) allowedFrom(status OrderStatus) bool {
+	for _, from := range t.From {
+		if from == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *transition) target(order *models.Orders) OrderStatus {
+	if t.To != "" {
+		return t.To
+	}
+	return restoredStatus(order)
+}
+
+// restoredStatus is the status a cancelled order returns to when restored.
+func restoredStatus(order *models.Orders) OrderStatus {
+	if order.CancelledFrom != "" {
+		return OrderStatus(order.CancelledFrom)
+	}
+	return StatusPending
+}
+
+// Fire runs the transition for action on an order locked by the caller's
+// transaction. effect, if given, runs after the transition's own side effect
+// and can add order columns to updates, such as shipping details. The
+// transition is recorded on the order's timeline.
+func Fire(tx *gorm.DB, order *models.Orders, action OrderAction, actor_id uuid.UUID, effect func(tx *gorm.DB, updates map[string]interface{}) error) error {
+	t := findTransition(action)
+	if t == nil {
+		return fmt.Errorf("unknown order action %q", action)
+	}
+
+	status := OrderStatus(order.Status)
+	if !t.allowedFrom(status) {
+		return &TransitionError{Message: fmt.Sprintf("cannot %s an order that is %s", action, status)}
+	}
+
+	ctx := &transitionContext{
+		Tx:      tx,
+		Order:   order,
+		ActorID: actor_id,
+		Updates: map[string]interface{}{},
+	}
+	if t.Guard != nil {
+		if err := t.Guard(ctx); err != nil {
+			return err
+		}
+	}
+	if t.Effect != nil {
+		if err := t.Effect(ctx); err != nil {
+			return err
+		}
+	}
+	if effect != nil {
+		if err := effect(tx, ctx.Updates); err != nil {
+			return err
+		}
+	}
+
+	data := map[string]interface{}{"action": action}
+	for column, value := range ctx.Updates {
+		data[column] = value
+	}
+
+	to := t.target(order)
+	ctx.Updates["status"] = string(to)
+	ctx.Updates["status_date"] = time.Now().Unix()
+	result := tx.Model(&models.Orders{}).Where("id = ?", order.ID).Updates(ctx.Updates)
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	if err := recordEvent(tx, models.OrderEvent{
+		OrderID:    order.ID,
+		ActorID:    &actor_id,
+		Type:       models.OrderEventTransition,
+		FromStatus: order.Status,
+		ToStatus:   string(to),
+	}, data); err != nil {
+		return err
+	}
+	order.Status = string(to)
+	return nil
+}
+
+// AvailableTransition is a next action for an order as listed to the admin UI.
+type AvailableTransition struct {
+	Action  OrderAction `json:"action"`
+	To      OrderStatus `json:"to"`
+	Allowed bool        `json:"allowed"`
+	Reason  string      `json:"reason,omitempty"` // why a guard refuses it
+}
+
+// availableTransitions lists the actions that can fire from the order's
+// status, with the outcome of their guards.
+func availableTransitions(tx *gorm.DB, order *models.Orders) ([]AvailableTransition, error) {
+	available := []AvailableTransition{}
+	for i := range transitions {
+		t := &transitions[i]
+		if !t.allowedFrom(OrderStatus(order.Status)) {
+			continue
+		}
+
+		next := AvailableTransition{Action: t.Action, To: t.target(order), Allowed: true}
+		if t.Guard != nil {
+			err := t.Guard(&transitionContext{Tx: tx, Order: order, Updates: map[string]interface{}{}})
+			var transitionErr *TransitionError
+			if errors.As(err, &transitionErr) {
+				next.Allowed = false
+				next.Reason = transitionErr.Message
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		available = append(available, next)
+	}
+	return available, nil
+}
+
+// lockOrder loads the order for update so concurrent transitions queue up.
+func lockOrder(tx *gorm.DB, order_id uuid.UUID) (*models.Orders, error) {
+	var order models.Orders
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", order_id).First(&order).Error; err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func hasLiveItems(ctx *transitionContext) error {
+	var liveItems int64
+	if err := ctx.Tx.Model(&models.OrderItem{}).
+		Where("order_id = ? AND order_item_status NOT IN ?", ctx.Order.ID, []string{"cancelled", "expired"}).
+		Count(&liveItems).Error; err != nil {
+		return err
+	}
+	if liveItems == 0 {
+		return &TransitionError{Message: "order has no items"}
+	}
+	return nil
+}
+
+// bookOrderItems turns the order's reservations into sales and books its
+// pending items.
+func bookOrderItems(ctx *transitionContext) error {
+	if err := inventory.ConvertReservations(ctx.Tx, ctx.Order.ID); err != nil {
+		return err
+	}
+	if err := ctx.Tx.Model(&models.OrderItem{}).Where("order_id = ? AND order_item_status = ?", ctx.Order.ID, "pending").
+		Update("order_item_status", "booked").Error; err != nil {
+		return err
+	}
+	ctx.Updates["user_id"] = ctx.ActorID
+	return nil
+}
+
+func fullyPaid(ctx *transitionContext) error {
+	if balance := ctx.Order.BillableAmount - ctx.Order.BillableAmountPaid; balance > 0 {
+		return &TransitionError{Message: fmt.Sprintf("order has a balance of %s", balance)}
+	}
+	return nil
+}
+
+// settleOrder marks the units of a paid order sold and issues its invoice.
+func settleOrder(ctx *transitionContext) error {
+	var orderItemIDs []uuid.UUID
+	if err := ctx.Tx.Model(&models.OrderItem{}).Where("order_id = ?", ctx.Order.ID).Pluck("id", &orderItemIDs).Error; err != nil {
+		return err
+	}
+	if err := inventory.MarkUnitsSold(ctx.Tx, orderItemIDs); err != nil {
+		return err
+	}
+	return issueInvoice(ctx.Tx, ctx.Order)
+}
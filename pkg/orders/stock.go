@@ -0,0 +1,132 @@
+package orders
+
+import (
+	"errors"
+
+	"github.com/Baalamurgan/coin-selling-backend/api/views"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/inventory"
+	itemPkg "github.com/Baalamurgan/coin-selling-backend/pkg/item"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrOrderConfirmed       = errors.New("order confirmed already")
+	ErrOrderNotEditable     = errors.New("order can no longer be changed")
+	ErrItemNotForSale       = errors.New("item is not available for sale")
+	ErrItemNotSerialised    = errors.New("item is not serialised")
+	ErrQuantityExceedsStock = errors.New("requested quantity exceeds available stock")
+	ErrReservationExpired   = errors.New("order item reservation has expired")
+	ErrOrderItemCancelled   = errors.New("order item has been cancelled")
+	ErrOrderItemNotOnOrder  = errors.New("order item does not belong to the order")
+	ErrQuantityBelowOne     = errors.New("quantity cannot be less than 1")
+	ErrInvalidPrice         = errors.New("invalid price per item")
+)
+
+// lockOrderItem loads an order item for update. Callers lock its order first.
+func lockOrderItem(tx *gorm.DB, order_item_id uuid.UUID) (*models.OrderItem, error) {
+	var orderItem models.OrderItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", order_item_id).First(&orderItem).Error; err != nil {
+		return nil, err
+	}
+	return &orderItem, nil
+}
+
+// recalculateOrderTotal sets the billable amount of an order to the sum of
+// its live items, so concurrent edits can never leave a stale total behind.
+// Expired and cancelled items no longer count towards it, and refunds given
+// as a reduction in price come off it. Payments are allocated again over the
+// items that are left.
+func recalculateOrderTotal(tx *gorm.DB, order_id uuid.UUID) error {
+	var total, adjustments money.Amount
+	if err := tx.Model(&models.OrderItem{}).
+		Select("COALESCE(SUM(billable_amount), 0)").
+		Where("order_id = ? AND order_item_status NOT IN ?", order_id, []string{"cancelled", "expired"}).
+		Scan(&total).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Refund{}).
+		Select("COALESCE(SUM(amount), 0)").
+		Where("order_id = ? AND adjusts_total AND status <> ?", order_id, models.RefundStatusFailed).
+		Scan(&adjustments).Error; err != nil {
+		return err
+	}
+	total -= adjustments
+	if err := tx.Model(&models.Orders{}).Where("id = ?", order_id).Update("billable_amount", total).Error; err != nil {
+		return err
+	}
+	_, err := allocatePayments(tx, order_id)
+	return err
+}
+
+// orderChangeFailed maps an error from a change to the items of an order to
+// its response.
+func orderChangeFailed(c *fiber.Ctx, err error) error {
+	var transitionErr *TransitionError
+	if errors.As(err, &transitionErr) {
+		return views.BadRequestWithMessage(c, transitionErr.Message)
+	}
+	switch {
+	case errors.Is(err, ErrOrderConfirmed),
+		errors.Is(err, ErrOrderNotEditable),
+		errors.Is(err, ErrItemNotForSale),
+		errors.Is(err, ErrItemNotSerialised),
+		errors.Is(err, ErrQuantityExceedsStock),
+		errors.Is(err, ErrReservationExpired),
+		errors.Is(err, ErrOrderItemCancelled),
+		errors.Is(err, ErrOrderItemNotOnOrder),
+		errors.Is(err, ErrQuantityBelowOne),
+		errors.Is(err, ErrInvalidPrice),
+		errors.Is(err, itemPkg.ErrVariantRequired),
+		errors.Is(err, itemPkg.ErrVariantNotFound),
+		errors.Is(err, inventory.ErrUnitsUnavailable),
+		errors.Is(err, inventory.ErrInsufficientStock):
+		return views.BadRequestWithMessage(c, err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return views.RecordNotFound(c)
+	}
+	return views.InternalServerError(c, err)
+}
+
+// adjustUnitAllocation reserves or releases units of a serialised item when
+// the quantity of its order item changes by diff.
+func adjustUnitAllocation(tx *gorm.DB, item *models.Item, orderItem *models.OrderItem, diff int) error {
+	if diff > 0 {
+		_, err := inventory.AllocateUnits(tx, item.ID, orderItem.ID, diff, nil)
+		return err
+	} else if diff < 0 {
+		return inventory.ReleaseUnits(tx, item.ID, orderItem.ID, -diff)
+	}
+	return nil
+}
+
+// adjustOrderItemStock moves stock when the quantity of an order item changes
+// by diff. Pending orders hold stock through reservations; confirmed orders,
+// and pending ones from before reservations existed, book it as a sale.
+func adjustOrderItemStock(tx *gorm.DB, order *models.Orders, orderItem *models.OrderItem, diff int) error {
+	if OrderStatus(order.Status) == StatusPending {
+		resized, err := inventory.ResizeReservation(tx, orderItem.ID, diff)
+		if err != nil || resized {
+			return err
+		}
+	}
+	return inventory.RecordSale(tx, orderItem.ItemID, diff, order.ID, orderItem.ID)
+}
+
+// releaseOrderItemStock returns the whole quantity of an order item removed
+// from its order to stock.
+func releaseOrderItemStock(tx *gorm.DB, order *models.Orders, orderItem *models.OrderItem) error {
+	if orderItem.OrderItemStatus == "expired" || orderItem.OrderItemStatus == "cancelled" {
+		// the sweeper or the cancellation already returned its stock
+		return nil
+	}
+	released, err := inventory.ReleaseReservation(tx, orderItem.ID, models.ReservationStatusReleased)
+	if err != nil || released {
+		return err
+	}
+	return inventory.RecordSale(tx, orderItem.ItemID, -orderItem.Quantity, order.ID, orderItem.ID)
+}
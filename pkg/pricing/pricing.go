@@ -0,0 +1,181 @@
+// Package pricing works out what an order line costs: the price tier it is
+// sold at, any discount off the list price, its taxable value and GST. Every
+// order path prices lines here, so an order's total is always the sum of
+// lines worked out the same way.
+package pricing
+
+import (
+	"encoding/json"
+
+	"github.com/Baalamurgan/coin-selling-backend/pkg/billing"
+	itemPkg "github.com/Baalamurgan/coin-selling-backend/pkg/item"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/models"
+	"github.com/Baalamurgan/coin-selling-backend/pkg/money"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// The tiers a line can be priced at.
+const (
+	TierList     = "list"     // the item's price
+	TierSale     = "sale"     // the price of a sale running when it was added
+	TierOverride = "override" // a price set on the order by staff
+)
+
+// Line is the price breakdown of quantity units of an item.
+type Line struct {
+	ItemID       uuid.UUID    `json:"item_id"`
+	OrderItemID  *uuid.UUID   `json:"order_item_id,omitempty"`
+	Name         string       `json:"name"`
+	SKU          string       `json:"sku"`
+	Quantity     int          `json:"quantity"`
+	UnitPrice    money.Amount `json:"unit_price"` // list price of one unit
+	Tier         string       `json:"tier"`
+	TierPrice    money.Amount `json:"tier_price"` // price of one unit at the tier
+	Discount     money.Amount `json:"discount"`   // off the list price, for the whole quantity
+	TaxableValue money.Amount `json:"taxable_value"`
+	GSTRate      float64      `json:"gst_rate"`
+	CGST         money.Amount `json:"cgst"`
+	SGST         money.Amount `json:"sgst"`
+	IGST         money.Amount `json:"igst"`
+	Total        money.Amount `json:"total"`
+}
+
+// price fills in the amounts of a line from its quantity, prices and rate.
+// GST is split for a buyer in state; the total does not depend on it.
+func (l *Line) price(state string) {
+	l.Discount = 0
+	if l.TierPrice < l.UnitPrice {
+		l.Discount = (l.UnitPrice - l.TierPrice).Times(l.Quantity)
+	}
+	split := billing.TaxOn(l.TierPrice.Times(l.Quantity), l.GSTRate, state)
+	l.TaxableValue = split.TaxableValue
+	l.CGST = split.CGST
+	l.SGST = split.SGST
+	l.IGST = split.IGST
+	l.Total = split.Total
+}
+
+// Metadata is what an order item keeps of how it was priced, so later
+// changes to it are priced the same way.
+func (l *Line) Metadata() map[string]interface{} {
+	return map[string]interface{}{
+		"price":      l.TierPrice,
+		"list_price": l.UnitPrice,
+		"price_tier": l.Tier,
+		"gst":        l.GSTRate,
+	}
+}
+
+// ForItem prices quantity units of an item as it sells now: at the price of a
+// running sale, or its list price.
+func ForItem(tx *gorm.DB, item *models.Item, quantity int, state string) (*Line, error) {
+	line := &Line{
+		ItemID:    item.ID,
+		Name:      item.Name,
+		SKU:       item.SKU,
+		Quantity:  quantity,
+		UnitPrice: item.Price,
+		Tier:      TierList,
+		TierPrice: item.Price,
+		GSTRate:   item.GST,
+	}
+	sale, err := itemPkg.ActiveSale(tx, item.ID)
+	if err != nil {
+		return nil, err
+	}
+	if sale != nil {
+		line.Tier = TierSale
+		line.TierPrice = sale.Price
+	}
+	line.price(state)
+	return line, nil
+}
+
+// soldAt is the pricing an order item keeps in its metadata. Amounts are read
+// as floats since older items kept them with more than two decimals.
+type soldAt struct {
+	Name      string   `json:"name"`
+	SKU       string   `json:"sku"`
+	Price     *float64 `json:"price"`
+	ListPrice *float64 `json:"list_price"`
+	Tier      string   `json:"price_tier"`
+	GST       *float64 `json:"gst"`
+}
+
+// ForOrderItem prices an order item at quantity, keeping the tier, price and
+// GST rate it was sold at. override, when given, replaces its price per unit.
+// Items that kept no price are priced as their item sells now.
+func ForOrderItem(tx *gorm.DB, orderItem *models.OrderItem, quantity int, override *money.Amount, state string) (*Line, error) {
+	var sold soldAt
+	_ = json.Unmarshal(orderItem.MetaData, &sold)
+
+	var line *Line
+	if sold.Price == nil || sold.GST == nil {
+		var item models.Item
+		if err := tx.Where("id = ?", orderItem.ItemID).First(&item).Error; err != nil {
+			return nil, err
+		}
+		var err error
+		if line, err = ForItem(tx, &item, quantity, state); err != nil {
+			return nil, err
+		}
+	} else {
+		line = &Line{
+			ItemID:    orderItem.ItemID,
+			Name:      sold.Name,
+			SKU:       sold.SKU,
+			Quantity:  quantity,
+			TierPrice: money.FromFloat(*sold.Price),
+			Tier:      sold.Tier,
+			GSTRate:   *sold.GST,
+		}
+		line.UnitPrice = line.TierPrice
+		if sold.ListPrice != nil {
+			line.UnitPrice = money.FromFloat(*sold.ListPrice)
+		}
+		if line.Tier == "" {
+			line.Tier = TierList
+			if line.TierPrice < line.UnitPrice {
+				line.Tier = TierSale
+			}
+		}
+	}
+
+	line.OrderItemID = &orderItem.ID
+	if override != nil {
+		line.Tier = TierOverride
+		line.TierPrice = *override
+	}
+	line.price(state)
+	return line, nil
+}
+
+// Quote is the priced lines of an order and their totals.
+type Quote struct {
+	Currency     money.Currency `json:"currency"`
+	Lines        []Line         `json:"lines"`
+	Discount     money.Amount   `json:"discount"`
+	TaxableValue money.Amount   `json:"taxable_value"`
+	CGST         money.Amount   `json:"cgst"`
+	SGST         money.Amount   `json:"sgst"`
+	IGST         money.Amount   `json:"igst"`
+	Total        money.Amount   `json:"total"`
+}
+
+// NewQuote adds up lines.
+func NewQuote(currency money.Currency, lines []Line) *Quote {
+	quote := &Quote{Currency: currency, Lines: lines}
+	for _, line := range lines {
+		quote.Discount += line.Discount
+		quote.TaxableValue += line.TaxableValue
+		quote.CGST += line.CGST
+		quote.SGST += line.SGST
+		quote.IGST += line.IGST
+		quote.Total += line.Total
+	}
+	if quote.Lines == nil {
+		quote.Lines = []Line{}
+	}
+	return quote
+}